@@ -4,21 +4,32 @@ import (
 	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
 	"waterlogger/internal/config"
 	"waterlogger/internal/database"
+	"waterlogger/internal/export"
 	"waterlogger/internal/handlers"
+	"waterlogger/internal/importer"
+	"waterlogger/internal/homeassistant"
+	"waterlogger/internal/influx"
+	"waterlogger/internal/metrics"
 	"waterlogger/internal/middleware"
 	"waterlogger/internal/models"
+	"waterlogger/internal/notify"
+	"waterlogger/internal/sensors"
+	"waterlogger/internal/webhooks"
 )
 
 // Build information - set at compile time
@@ -34,8 +45,19 @@ func main() {
 	var showHelp bool
 	var migrateToMariaDB bool
 	var migrateToSQLite bool
+	var migrateFrom string
+	var migrateTo string
+	var truncateTarget bool
 	var exportData string
+	var exportFormat string
+	var exportFilter string
 	var importData string
+	var importCSVPath string
+	var importMapping string
+	var importFormat string
+	var importDryRun bool
+	var compressBackup bool
+	var encryptBackup bool
 	var resetPassword string
 	
 	flag.StringVar(&configPath, "config", "config.yaml", "Path to configuration file")
@@ -43,8 +65,19 @@ func main() {
 	flag.BoolVar(&showHelp, "help", false, "Show help information")
 	flag.BoolVar(&migrateToMariaDB, "migrate-to-mariadb", false, "Migrate data from SQLite to MariaDB")
 	flag.BoolVar(&migrateToSQLite, "migrate-to-sqlite", false, "Migrate data from MariaDB to SQLite")
+	flag.StringVar(&migrateFrom, "migrate-from", "", "Source database type for -migrate-to (default: configured type)")
+	flag.StringVar(&migrateTo, "migrate-to", "", "Migrate data to the given database type (sqlite, mariadb, postgres)")
+	flag.BoolVar(&truncateTarget, "truncate-target", false, "Replace existing data in the migration target")
 	flag.StringVar(&exportData, "export", "", "Export database data to backup file")
+	flag.StringVar(&exportFormat, "export-format", "backup", "Format of the -export file (backup, excel, markdown, csv, ndjson)")
+	flag.StringVar(&exportFilter, "export-filter", "", "Filter for excel, markdown, csv and ndjson exports as a query string, e.g. \"pools=1,2&days=30&units=metric\"")
 	flag.StringVar(&importData, "import", "", "Import database data from backup file")
+	flag.StringVar(&importCSVPath, "import-csv", "", "Import samples from a CSV file")
+	flag.StringVar(&importMapping, "import-mapping", "", "YAML or JSON column mapping for -import-csv")
+	flag.StringVar(&importFormat, "import-format", importer.DefaultFormat, "Format of the -import-csv file (csv, poolmath, testkit)")
+	flag.BoolVar(&importDryRun, "import-dry-run", false, "Validate and preview the -import-csv file without importing it")
+	flag.BoolVar(&compressBackup, "compress", false, "Compress the -export backup with gzip")
+	flag.BoolVar(&encryptBackup, "encrypt", false, "Encrypt the -export backup with a passphrase")
 	flag.StringVar(&resetPassword, "reset-password", "", "Reset password for specified username")
 	flag.Parse()
 
@@ -65,8 +98,19 @@ func main() {
 		fmt.Println("  -help                    Show this help message")
 		fmt.Println("  -migrate-to-mariadb      Migrate data from SQLite to MariaDB")
 		fmt.Println("  -migrate-to-sqlite       Migrate data from MariaDB to SQLite")
+		fmt.Println("  -migrate-to string       Migrate data to the given database type (sqlite, mariadb, postgres)")
+		fmt.Println("  -migrate-from string     Source database type for -migrate-to (default: configured type)")
+		fmt.Println("  -truncate-target         Replace existing data in the migration target")
 		fmt.Println("  -export string           Export database data to backup file")
+		fmt.Println("  -export-format string    Format of the -export file: backup, excel, markdown, csv or ndjson (default: backup)")
+		fmt.Println("  -export-filter string    Filter for excel, markdown, csv and ndjson exports, e.g. \"pools=1,2&days=30&units=metric\"")
 		fmt.Println("  -import string           Import database data from backup file")
+		fmt.Println("  -import-csv string       Import samples from a CSV file")
+		fmt.Println("  -import-mapping string   YAML or JSON column mapping for -import-csv")
+		fmt.Println("  -import-format string    Format of the -import-csv file: csv, poolmath or testkit (default: csv)")
+		fmt.Println("  -import-dry-run          Validate and preview the -import-csv file without importing it")
+		fmt.Println("  -compress                Compress the -export backup with gzip")
+		fmt.Println("  -encrypt                 Encrypt the -export backup with a passphrase")
 		fmt.Println("  -reset-password string   Reset password for specified username")
 		fmt.Println()
 		fmt.Println("For more information, visit: https://github.com/your-org/waterlogger")
@@ -98,9 +142,11 @@ func main() {
 	defer db.Close()
 	
 	// Handle migration commands
+	migrationOpts := database.MigrationOptions{Truncate: truncateTarget}
+	
 	if migrateToMariaDB {
 		log.Println("Starting migration from SQLite to MariaDB...")
-		if err := database.MigrateSQLiteToMariaDB(cfg); err != nil {
+		if _, err := database.MigrateSQLiteToMariaDB(cfg, migrationOpts); err != nil {
 			log.Fatalf("Migration failed: %v", err)
 		}
 		log.Println("Migration completed successfully!")
@@ -109,16 +155,57 @@ func main() {
 	
 	if migrateToSQLite {
 		log.Println("Starting migration from MariaDB to SQLite...")
-		if err := database.MigrateMariaDBToSQLite(cfg); err != nil {
+		if _, err := database.MigrateMariaDBToSQLite(cfg, migrationOpts); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Println("Migration completed successfully!")
+		os.Exit(0)
+	}
+	
+	if migrateTo != "" {
+		source := migrateFrom
+		if source == "" {
+			source = cfg.Database.Type
+		}
+		log.Printf("Starting migration from %s to %s...", source, migrateTo)
+		if _, err := database.MigrateBetween(cfg, source, migrateTo, migrationOpts); err != nil {
 			log.Fatalf("Migration failed: %v", err)
 		}
 		log.Println("Migration completed successfully!")
 		os.Exit(0)
 	}
 	
+	if exportData != "" && exportFormat != "backup" {
+		log.Printf("Exporting %s data to %s...", exportFormat, exportData)
+		filter, err := export.ParseFilterQuery(exportFilter, time.Now())
+		if err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		if err := writeExport(db.DB, exportData, exportFormat, filter); err != nil {
+			log.Fatalf("Export failed: %v", err)
+		}
+		log.Println("Export completed successfully!")
+		os.Exit(0)
+	}
+	
 	if exportData != "" {
+		// Backups must restore completely, so they are never filtered
+		if exportFilter != "" {
+			log.Fatalf("Export failed: -export-filter only applies to the excel, markdown, csv and ndjson formats")
+		}
 		log.Printf("Exporting database data to %s...", exportData)
-		if err := database.ExportData(db.DB, exportData, cfg.Database.Type); err != nil {
+		archiveOpts := database.ArchiveOptionsFromConfig(cfg.Backup)
+		if compressBackup {
+			archiveOpts.Compress = true
+		}
+		if (encryptBackup || cfg.Backup.Encrypt) && archiveOpts.Passphrase == "" {
+			passphrase, err := promptBackupPassphrase(true)
+			if err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			archiveOpts.Passphrase = passphrase
+		}
+		if err := database.ExportData(db.DB, exportData, cfg.Database.Type, archiveOpts); err != nil {
 			log.Fatalf("Export failed: %v", err)
 		}
 		log.Println("Export completed successfully!")
@@ -127,13 +214,36 @@ func main() {
 	
 	if importData != "" {
 		log.Printf("Importing database data from %s...", importData)
-		if err := database.ImportData(db.DB, importData); err != nil {
+		encrypted, err := database.IsEncryptedBackupFile(importData)
+		if err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		passphrase := cfg.Backup.Passphrase
+		if encrypted && passphrase == "" {
+			if passphrase, err = promptBackupPassphrase(false); err != nil {
+				log.Fatalf("Import failed: %v", err)
+			}
+		}
+		if err := database.ImportData(db.DB, importData, passphrase); err != nil {
 			log.Fatalf("Import failed: %v", err)
 		}
 		log.Println("Import completed successfully!")
 		os.Exit(0)
 	}
 	
+	if importCSVPath != "" {
+		log.Printf("Importing samples from %s...", importCSVPath)
+		if err := importCSV(db.DB, importCSVPath, importFormat, importMapping, importDryRun); err != nil {
+			log.Fatalf("Import failed: %v", err)
+		}
+		if importDryRun {
+			log.Println("Validation completed successfully!")
+		} else {
+			log.Println("Import completed successfully!")
+		}
+		os.Exit(0)
+	}
+	
 	if resetPassword != "" {
 		log.Printf("Resetting password for user: %s", resetPassword)
 		if err := resetUserPassword(db.DB, resetPassword); err != nil {
@@ -155,6 +265,9 @@ func main() {
 	
 	router := gin.Default()
 
+	// Count and time every request for /metrics
+	router.Use(metrics.Middleware())
+
 	// Load HTML templates
 	templatesPattern := filepath.Join("web", "templates", "*.html")
 	router.LoadHTMLGlob(templatesPattern)
@@ -172,13 +285,48 @@ func main() {
 	// Setup middleware
 	router.Use(middleware.CORSMiddleware())
 	router.Use(middleware.RequireSetup(db.DB))
+	router.Use(middleware.DeviceTokenAuth(db.DB, cfg.Readings.Token))
 	router.Use(middleware.AuthMiddleware(db.DB))
 
 	// Initialize handlers
 	h := handlers.NewHandlers(db.DB, cfg)
 
 	// Setup routes
-	setupRoutes(router, h)
+	setupRoutes(router, h, middleware.RequireAdmin(db.DB))
+
+	// Permanently delete records that have been in the trash too long
+	database.StartTrashRetention(db.DB, cfg.Trash.RetentionDays)
+
+	// Send new samples to InfluxDB
+	if err := influx.StartPusher(db.DB, cfg.Influx); err != nil {
+		log.Printf("InfluxDB push disabled: %v", err)
+	}
+
+	// Downsample and delete old sensor readings
+	sensors.StartReadingRetention(db.DB, cfg.Readings)
+
+	// Record sensor readings published over MQTT
+	if err := sensors.StartMQTT(db.DB, cfg.MQTT); err != nil {
+		log.Printf("MQTT ingestion disabled: %v", err)
+	}
+
+	// Send queued webhook deliveries
+	webhooks.StartDispatcher(db.DB, cfg.Webhooks)
+
+	// Email weekly digests
+	if err := notify.StartDigests(db.DB, cfg.Email); err != nil {
+		log.Printf("Digest emails disabled: %v", err)
+	}
+
+	// Remind users of pools overdue for testing
+	if err := notify.StartReminders(db.DB, cfg.Email); err != nil {
+		log.Printf("Test reminders disabled: %v", err)
+	}
+
+	// Publish pools to Home Assistant over MQTT
+	if err := homeassistant.StartDiscovery(db.DB, cfg.MQTT); err != nil {
+		log.Printf("Home Assistant discovery disabled: %v", err)
+	}
 
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -190,7 +338,7 @@ func main() {
 	}
 }
 
-func setupRoutes(router *gin.Engine, h *handlers.Handlers) {
+func setupRoutes(router *gin.Engine, h *handlers.Handlers, requireAdmin gin.HandlerFunc) {
 	// Setup wizard routes
 	router.GET("/setup", h.SetupWizardPage)
 	router.POST("/api/setup", h.SetupWizardAPI)
@@ -200,11 +348,15 @@ func setupRoutes(router *gin.Engine, h *handlers.Handlers) {
 	router.POST("/api/login", h.LoginAPI)
 	router.POST("/api/logout", h.LogoutAPI)
 
+	// Prometheus metrics, protected by metrics.token instead of a session
+	router.GET("/metrics", h.Metrics)
+
 	// Main application routes
 	router.GET("/", h.Dashboard)
 	router.GET("/pools", h.PoolsPage)
 	router.GET("/kits", h.KitsPage)
 	router.GET("/samples", h.SamplesPage)
+	router.GET("/maintenance", h.MaintenancePage)
 	router.GET("/export", h.ExportPage)
 	router.GET("/settings", h.SettingsPage)
 
@@ -222,6 +374,25 @@ func setupRoutes(router *gin.Engine, h *handlers.Handlers) {
 		api.POST("/pools", h.CreatePool)
 		api.PUT("/pools/:id", h.UpdatePool)
 		api.DELETE("/pools/:id", h.DeletePool)
+		api.GET("/pools/:id/delete-preview", h.PreviewPoolDelete)
+		api.GET("/pools/:id/state", h.GetPoolState)
+		api.GET("/pools/:id/orp-calibration", h.GetORPCalibration)
+		api.POST("/pools/:id/orp-calibration", h.CalibrateORP)
+		api.DELETE("/pools/:id/orp-calibration", h.DeleteORPCalibration)
+		api.GET("/pools/:id/fc-estimate", h.EstimateFC)
+		api.GET("/pools/:id/schedule", h.GetPoolSchedule)
+		api.PUT("/pools/:id/schedule", h.SetPoolSchedule)
+		api.DELETE("/pools/:id/schedule", h.DeletePoolSchedule)
+		api.GET("/schedules", h.GetSchedules)
+
+		// Maintenance
+		api.GET("/maintenance", h.GetMaintenance)
+		api.POST("/maintenance/tasks", h.CreateMaintenanceTask)
+		api.PUT("/maintenance/tasks/:id", h.UpdateMaintenanceTask)
+		api.DELETE("/maintenance/tasks/:id", h.DeleteMaintenanceTask)
+		api.GET("/maintenance/tasks/:id/completions", h.GetMaintenanceCompletions)
+		api.POST("/maintenance/tasks/:id/complete", h.CompleteMaintenanceTask)
+		api.DELETE("/maintenance/completions/:id", h.DeleteMaintenanceCompletion)
 
 		// Kits
 		api.GET("/kits", h.GetKits)
@@ -234,16 +405,61 @@ func setupRoutes(router *gin.Engine, h *handlers.Handlers) {
 		api.POST("/samples", h.CreateSample)
 		api.PUT("/samples/:id", h.UpdateSample)
 		api.DELETE("/samples/:id", h.DeleteSample)
+		api.GET("/samples/:id/delete-preview", h.PreviewSampleDelete)
+		api.GET("/samples/:id/report.pdf", h.SampleReportPDF)
+
+		// Charts
+		api.GET("/charts/data", h.GetChartData)
+
+		// Sensor readings
+		api.GET("/readings", h.GetReadings)
+		api.POST("/readings", h.CreateReadings)
 
+		// Webhooks
+		api.GET("/webhooks", requireAdmin, h.GetWebhooks)
+		api.POST("/webhooks", requireAdmin, h.CreateWebhook)
+		api.PUT("/webhooks/:id", requireAdmin, h.UpdateWebhook)
+		api.DELETE("/webhooks/:id", requireAdmin, h.DeleteWebhook)
+		api.POST("/webhooks/:id/test", requireAdmin, h.TestWebhook)
+		api.GET("/webhook-deliveries", requireAdmin, h.GetWebhookDeliveries)
+		api.POST("/webhook-deliveries/:id/retry", requireAdmin, h.RetryWebhookDelivery)
+
+		// Trash
+		api.GET("/trash", h.GetTrash)
+		api.POST("/trash/:type/:id/restore", h.RestoreTrashItem)
+		api.DELETE("/trash/:type/:id", requireAdmin, h.PurgeTrashItem)
+		api.DELETE("/trash", requireAdmin, h.EmptyTrash)
 
 		// Export
 		api.GET("/export", h.ExportBackup)
 		api.GET("/export/excel", h.ExportExcel)
 		api.GET("/export/markdown", h.ExportMarkdown)
+		api.GET("/export/csv", h.ExportCSV)
+		api.GET("/export/ndjson", h.ExportNDJSON)
+		api.GET("/export/influx", h.ExportLineProtocol)
+		api.POST("/export/influx/push", requireAdmin, h.PushInflux)
+
+		// Import
+		api.GET("/import/formats", h.GetImportFormats)
+		api.POST("/import/samples", h.ImportSamples)
+
+		// Reports
+		api.GET("/reports/:template", h.RenderReport)
+		api.GET("/report-templates", h.GetReportTemplates)
+		api.POST("/report-templates", requireAdmin, h.CreateReportTemplate)
+		api.PUT("/report-templates/:id", requireAdmin, h.UpdateReportTemplate)
+		api.DELETE("/report-templates/:id", requireAdmin, h.DeleteReportTemplate)
 
 		// Settings
 		api.GET("/settings", h.GetSettings)
 		api.POST("/settings", h.UpdateSettings)
+		api.GET("/settings/notifications", h.GetNotificationSettings)
+		api.PUT("/settings/notifications", h.UpdateNotificationSettings)
+		api.POST("/settings/notifications/digest", h.SendDigestNow)
+		api.POST("/settings/notifications/channels", requireAdmin, h.CreateNotificationChannel)
+		api.PUT("/settings/notifications/channels/:id", requireAdmin, h.UpdateNotificationChannel)
+		api.DELETE("/settings/notifications/channels/:id", requireAdmin, h.DeleteNotificationChannel)
+		api.POST("/settings/notifications/channels/:id/test", requireAdmin, h.TestNotificationChannel)
 		
 		// Unit conversion
 		api.POST("/convert", h.ConvertUnits)
@@ -320,6 +536,141 @@ func resetUserPassword(db *gorm.DB, username string) error {
 	return nil
 }
 
+// writeExport writes an excel, markdown, csv or ndjson export of the filtered data to path
+func writeExport(db *gorm.DB, path string, format string, filter export.Filter) error {
+	var write func(*gorm.DB, io.Writer, export.Filter) error
+	switch format {
+	case "excel":
+		write = export.WriteExcel
+	case "markdown":
+		write = export.WriteMarkdown
+	case "csv":
+		write = func(db *gorm.DB, w io.Writer, filter export.Filter) error {
+			return export.WriteCSV(db, w, filter, export.CSVOptions{})
+		}
+	case "ndjson":
+		write = export.WriteNDJSON
+	default:
+		return fmt.Errorf("unknown export format: %s (expected backup, excel, markdown, csv or ndjson)", format)
+	}
+	
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create export directory: %v", err)
+	}
+	
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %v", err)
+	}
+	
+	if err := write(db, file, filter); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// importCSV imports samples from a CSV file as the first administrator,
+// printing every row error, or the samples it would import in a dry run
+func importCSV(db *gorm.DB, path string, format string, mappingPath string, dryRun bool) error {
+	imp, ok := importer.Lookup(format)
+	if !ok {
+		return fmt.Errorf("unknown import format: %s", format)
+	}
+
+	var mapping importer.Mapping
+	if mappingPath != "" {
+		data, err := os.ReadFile(mappingPath)
+		if err != nil {
+			return fmt.Errorf("failed to read column mapping: %v", err)
+		}
+		// YAML also reads JSON mappings
+		if err := yaml.Unmarshal(data, &mapping); err != nil {
+			return fmt.Errorf("invalid column mapping: %v", err)
+		}
+	}
+	
+	var admin models.User
+	if err := db.Where("is_admin = ?", true).Order("id ASC").First(&admin).Error; err != nil {
+		return fmt.Errorf("no administrator to import as: %v", err)
+	}
+	
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+	
+	result, err := importer.ImportFile(db, imp, file, mapping, importer.Options{UserID: admin.ID, DryRun: dryRun})
+	if err != nil {
+		return err
+	}
+	
+	for _, e := range result.Errors {
+		fmt.Println(e.Error())
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%d problems in %d rows; nothing was imported", len(result.Errors), result.Rows)
+	}
+	for _, rec := range result.Preview {
+		fmt.Println(previewLine(rec))
+	}
+	if result.Imported > len(result.Preview) {
+		fmt.Printf("... and %d more\n", result.Imported-len(result.Preview))
+	}
+	for _, name := range result.PoolsCreated {
+		fmt.Printf("New pool: %s\n", name)
+	}
+	verb := "imported"
+	if dryRun {
+		verb = "to import"
+	}
+	fmt.Printf("Rows: %d, %s: %d, already present: %d\n", result.Rows, verb, result.Imported, result.Skipped)
+	return nil
+}
+
+// previewLine summarizes a record of an import dry run on one line, e.g.
+// "row 2: 2024-07-14 10:30 Backyard fc=3 ph=7.4; Liquid chlorine 1 gal"
+func previewLine(rec importer.Record) string {
+	parts := []string{fmt.Sprintf("row %d: %s %s", rec.Row, rec.Time.Format("2006-01-02 15:04"), rec.Pool)}
+	for _, p := range importer.Fields {
+		if v, ok := rec.Values[p]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%g", p, v))
+		}
+	}
+	line := strings.Join(parts, " ")
+	for _, a := range rec.Additions {
+		line += fmt.Sprintf("; %s %g %s", a.Chemical, a.Amount, a.Unit)
+	}
+	return strings.TrimSpace(line)
+}
+
+// promptBackupPassphrase asks for the backup passphrase, with confirmation
+// when a new backup is being encrypted
+func promptBackupPassphrase(confirm bool) (string, error) {
+	passphrase, err := getPasswordFromInput("Backup passphrase: ")
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %v", err)
+	}
+	
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase cannot be empty")
+	}
+	
+	if confirm && term.IsTerminal(int(syscall.Stdin)) {
+		confirmPassphrase, err := getPasswordFromInput("Confirm backup passphrase: ")
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase confirmation: %v", err)
+		}
+		
+		if passphrase != confirmPassphrase {
+			return "", fmt.Errorf("passphrases do not match")
+		}
+	}
+	
+	return passphrase, nil
+}
+
 // getPasswordFromInput securely reads a password from stdin
 func getPasswordFromInput(prompt string) (string, error) {
 	fmt.Print(prompt)
@@ -0,0 +1,145 @@
+package chemistry
+
+import (
+	"fmt"
+	"waterlogger/internal/models"
+)
+
+// Alert severities
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// IdealRange is the numeric ideal range of a parameter. Values outside
+// Min-Max raise a warning, and values outside CriticalMin-CriticalMax a
+// critical alert.
+type IdealRange struct {
+	Min         float64 `json:"min"`
+	Max         float64 `json:"max"`
+	CriticalMin float64 `json:"critical_min"`
+	CriticalMax float64 `json:"critical_max"`
+}
+
+// Alert reports a parameter outside its ideal range
+type Alert struct {
+	Parameter string     `json:"parameter"`
+	Value     float64    `json:"value"`
+	Unit      string     `json:"unit"`
+	Status    string     `json:"status"` // low, high
+	Severity  string     `json:"severity"`
+	Range     IdealRange `json:"range"`
+	Message   string     `json:"message"`
+}
+
+// GetIdealRangeLimits returns the numeric ideal ranges behind GetIdealRanges,
+// in stored units. Parameters without a fixed range are not listed.
+func GetIdealRangeLimits() map[string]IdealRange {
+	return map[string]IdealRange{
+		"fc":       {Min: 1.0, Max: 4.0, CriticalMin: 0.5, CriticalMax: 10.0},
+		"ph":       {Min: 7.4, Max: 7.6, CriticalMin: 7.0, CriticalMax: 8.0},
+		"ta":       {Min: 80, Max: 120, CriticalMin: 50, CriticalMax: 180},
+		"ch":       {Min: 200, Max: 400, CriticalMin: 100, CriticalMax: 800},
+		"cya":      {Min: 30, Max: 50, CriticalMin: 0, CriticalMax: 100},
+		"salinity": {Min: 2700, Max: 3400, CriticalMin: 2000, CriticalMax: 4500},
+		"orp":      {Min: 650, Max: 750, CriticalMin: 550, CriticalMax: 900},
+		"lsi":      {Min: -0.3, Max: 0.3, CriticalMin: -1.0, CriticalMax: 1.0},
+		"rsi":      {Min: 6.0, Max: 7.0, CriticalMin: 5.0, CriticalMax: 8.0},
+	}
+}
+
+// validRanges are the values that can be measured for each parameter, in
+// stored units
+var validRanges = map[string][2]float64{
+	"fc":          {0, 50},
+	"tc":          {0, 50},
+	"ph":          {0, 14},
+	"ta":          {0, 1000},
+	"ch":          {0, 5000},
+	"cya":         {0, 500},
+	"temperature": {32, 120},
+	"salinity":    {0, 10000},
+	"tds":         {0, 50000},
+	"orp":         {0, 1200},
+}
+
+// ValidRange returns the values that can be measured for a parameter, in
+// stored units. Values outside it are input errors rather than alerts.
+func ValidRange(parameter string) (min, max float64, ok bool) {
+	limits, ok := validRanges[parameter]
+	return limits[0], limits[1], ok
+}
+
+// alertParameters fixes the order alerts are reported in
+var alertParameters = []string{"fc", "ph", "ta", "ch", "cya", "salinity", "orp", "lsi", "rsi"}
+
+// CheckValue returns an alert when a stored value of a parameter is outside
+// its ideal range
+func CheckValue(parameter string, value float64) (Alert, bool) {
+	r, ok := GetIdealRangeLimits()[parameter]
+	if !ok || (value >= r.Min && value <= r.Max) {
+		return Alert{}, false
+	}
+
+	alert := Alert{
+		Parameter: parameter,
+		Value:     value,
+		Unit:      ParameterUnit(parameter),
+		Status:    "low",
+		Severity:  SeverityWarning,
+		Range:     r,
+	}
+	if value > r.Max {
+		alert.Status = "high"
+	}
+	if value < r.CriticalMin || value > r.CriticalMax {
+		alert.Severity = SeverityCritical
+	}
+
+	name := GetParameterNames()[parameter]
+	alert.Message = fmt.Sprintf("%s is %s: %s, ideal %s", name, alert.Status,
+		formatWithUnit(value, alert.Unit), GetIdealRanges()[parameter])
+	return alert, true
+}
+
+// CheckSample returns an alert for every recorded parameter of a sample
+// that is outside its ideal range
+func CheckSample(s *models.Sample) []Alert {
+	var alerts []Alert
+	for _, parameter := range alertParameters {
+		value, ok := s.ParameterValue(parameter)
+		if !ok {
+			continue
+		}
+		if alert, ok := CheckValue(parameter, value); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// NewAlerts returns the alerts whose parameter was not out of range in the
+// same direction before, such as the alerts of a sample before an update
+func NewAlerts(alerts, before []Alert) []Alert {
+	var added []Alert
+	for _, alert := range alerts {
+		found := false
+		for _, b := range before {
+			if b.Parameter == alert.Parameter && b.Status == alert.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, alert)
+		}
+	}
+	return added
+}
+
+func formatWithUnit(value float64, unit string) string {
+	if unit == "" {
+		return fmt.Sprintf("%.2f", value)
+	}
+	return fmt.Sprintf("%.2f %s", value, unit)
+}
@@ -87,15 +87,16 @@ func ConvertMeasurement(value float64, parameter string, fromSystem UnitSystem)
 		// Most chemical measurements (ppm, pH) are universal
 		return ConvertedValue{
 			Value:         value,
-			Unit:          getParameterUnit(parameter),
+			Unit:          ParameterUnit(parameter),
 			Converted:     value,
-			ConvertedUnit: getParameterUnit(parameter),
+			ConvertedUnit: ParameterUnit(parameter),
 		}
 	}
 }
 
-// getParameterUnit returns the standard unit for a parameter
-func getParameterUnit(parameter string) string {
+// ParameterUnit returns the unit a parameter is stored in, or "" for
+// dimensionless values such as pH and the indices
+func ParameterUnit(parameter string) string {
 	units := map[string]string{
 		"ph":          "",
 		"fc":          "ppm",
@@ -105,6 +106,7 @@ func getParameterUnit(parameter string) string {
 		"cya":         "ppm",
 		"salinity":    "ppm",
 		"tds":         "ppm",
+		"orp":         "mV",
 		"temperature": "°F",
 		"volume":      "gal",
 		"lsi":         "",
@@ -219,6 +221,38 @@ func CalculateIndices(m *models.Measurements) (*models.Indices, error) {
 	}, nil
 }
 
+// InterpretLSI describes what a Langelier Saturation Index means for the water
+func InterpretLSI(lsi float64) string {
+	switch {
+	case lsi < -1.0:
+		return "Severely corrosive: water will etch plaster and corrode metal"
+	case lsi < -0.3:
+		return "Corrosive: water tends to dissolve calcium from surfaces"
+	case lsi <= 0.3:
+		return "Balanced: water neither dissolves nor deposits calcium"
+	case lsi <= 1.0:
+		return "Scale forming: calcium tends to deposit on surfaces"
+	default:
+		return "Severely scale forming: expect cloudy water and scale"
+	}
+}
+
+// InterpretRSI describes what a Ryznar Stability Index means for the water
+func InterpretRSI(rsi float64) string {
+	switch {
+	case rsi < 5.0:
+		return "Heavy scale forming"
+	case rsi < 6.0:
+		return "Scale forming"
+	case rsi <= 7.0:
+		return "Stable: little scale or corrosion"
+	case rsi <= 8.0:
+		return "Corrosive"
+	default:
+		return "Heavily corrosive"
+	}
+}
+
 // GetIdealRanges returns ideal ranges for water parameters
 func GetIdealRanges() map[string]string {
 	return map[string]string{
@@ -229,11 +263,43 @@ func GetIdealRanges() map[string]string {
 		"ch":          "200 - 400 ppm",
 		"cya":         "30 - 50 ppm",
 		"salinity":    "2,700 - 3,400 ppm (optimal: 3,200 ppm)",
+		"orp":         "650 - 750 mV",
 		"lsi":         "-0.3 to +0.3 (balanced water)",
 		"rsi":         "6.0 - 7.0 (stable water)",
 	}
 }
 
+// GetParameterNames returns the full display name of each parameter
+func GetParameterNames() map[string]string {
+	return map[string]string{
+		"fc":          "Free Chlorine",
+		"tc":          "Total Chlorine",
+		"ph":          "pH",
+		"ta":          "Total Alkalinity",
+		"ch":          "Calcium Hardness",
+		"cya":         "Cyanuric Acid",
+		"temperature": "Temperature",
+		"salinity":    "Salinity",
+		"tds":         "Total Dissolved Solids",
+		"orp":         "Oxidation Reduction Potential",
+		"lsi":         "Langelier Saturation Index",
+		"rsi":         "Ryznar Stability Index",
+	}
+}
+
+// ParameterLabel returns the display name of a parameter followed by its
+// unit, e.g. "Free Chlorine (ppm)"
+func ParameterLabel(parameter string) string {
+	name, ok := GetParameterNames()[parameter]
+	if !ok {
+		name = parameter
+	}
+	if unit := ParameterUnit(parameter); unit != "" {
+		return fmt.Sprintf("%s (%s)", name, unit)
+	}
+	return name
+}
+
 // GetParameterDescriptions returns detailed descriptions for tooltips
 func GetParameterDescriptions() map[string]string {
 	return map[string]string{
@@ -246,6 +312,7 @@ func GetParameterDescriptions() map[string]string {
 		"temperature": "Water temperature affects chemical reaction rates, chlorine effectiveness, and swimmer comfort. Higher temperatures require more sanitizer.",
 		"salinity": "Salinity measures dissolved salt content in saltwater pools. Proper levels ensure the chlorine generator can produce adequate chlorine for sanitation.",
 		"tds": "Total Dissolved Solids measures all dissolved substances in the water. High TDS can interfere with chemical effectiveness and water clarity.",
+		"orp": "Oxidation Reduction Potential measures the oxidizing strength of the water in millivolts, as reported by automation controllers and probes. It rises with the active form of chlorine, so it falls as pH or cyanuric acid rise even when free chlorine stays the same.",
 		"appearance": "Visual observations about water clarity, color, or any visible issues that may indicate water quality problems.",
 		"maintenance": "Notes about maintenance activities performed, equipment issues, or other relevant information about pool care.",
 		"lsi": "Langelier Saturation Index indicates whether water is balanced, scale-forming, or corrosive. Values near zero indicate balanced water.",
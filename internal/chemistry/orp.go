@@ -0,0 +1,92 @@
+package chemistry
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DefaultTemperature is assumed for ORP calculations without a water
+// temperature, in °F
+const DefaultTemperature = 77.0
+
+// MinORPPoints is the fewest manual tests an ORP calibration is fitted from
+const MinORPPoints = 3
+
+// ErrORPFit is wrapped by the errors of FitORP when ORP readings and tests
+// do not determine a calibration
+var ErrORPFit = errors.New("cannot fit ORP calibration")
+
+// HOClFraction returns the fraction of free chlorine present as
+// hypochlorous acid, the form ORP probes respond to, at a pH and water
+// temperature (°F). The dissociation constant is that of Morris (1966).
+func HOClFraction(ph, temperature float64) float64 {
+	t := FahrenheitToCelsius(temperature) + 273.15
+	pKa := 3000.0/t - 10.0686 + 0.0253*t
+	return 1 / (1 + math.Pow(10, ph-pKa))
+}
+
+// ORPPoint is a manually tested FC with the ORP reading taken with it
+type ORPPoint struct {
+	ORP         float64 `json:"orp"`
+	FC          float64 `json:"fc"`
+	PH          float64 `json:"ph"`
+	Temperature float64 `json:"temperature"`
+	CYA         float64 `json:"cya"`
+}
+
+// ORPFit relates ORP to free chlorine as
+// ORP = Intercept + Slope × ln(FC × HOClFraction(pH, temperature)).
+// Cyanuric acid binds chlorine and shifts the relationship, so a fit holds
+// for the CYA level of the tests it came from.
+type ORPFit struct {
+	Intercept float64 `json:"intercept"`
+	Slope     float64 `json:"slope"`
+	RSquared  float64 `json:"r_squared"`
+	Points    int     `json:"points"`
+}
+
+// FitORP fits ORP readings to tested FC by least squares
+func FitORP(points []ORPPoint) (ORPFit, error) {
+	if len(points) < MinORPPoints {
+		return ORPFit{}, fmt.Errorf("%w: at least %d tests with ORP readings are needed", ErrORPFit, MinORPPoints)
+	}
+
+	n := float64(len(points))
+	var sumX, sumY float64
+	xs := make([]float64, len(points))
+	for i, p := range points {
+		xs[i] = math.Log(p.FC * HOClFraction(p.PH, p.Temperature))
+		sumX += xs[i]
+		sumY += p.ORP
+	}
+	meanX, meanY := sumX/n, sumY/n
+
+	var sxx, sxy, syy float64
+	for i, p := range points {
+		dx, dy := xs[i]-meanX, p.ORP-meanY
+		sxx += dx * dx
+		sxy += dx * dy
+		syy += dy * dy
+	}
+	if sxx == 0 {
+		return ORPFit{}, fmt.Errorf("%w: tests must cover more than one chlorine level", ErrORPFit)
+	}
+
+	fit := ORPFit{Slope: sxy / sxx, Points: len(points)}
+	if fit.Slope <= 0 {
+		return ORPFit{}, fmt.Errorf("%w: ORP readings do not rise with free chlorine", ErrORPFit)
+	}
+	fit.Intercept = meanY - fit.Slope*meanX
+	fit.RSquared = 1
+	if syy > 0 {
+		fit.RSquared = sxy * sxy / (sxx * syy)
+	}
+	return fit, nil
+}
+
+// EstimateFC returns the free chlorine an ORP reading indicates at a pH and
+// water temperature (°F)
+func (f ORPFit) EstimateFC(orp, ph, temperature float64) float64 {
+	return math.Exp((orp-f.Intercept)/f.Slope) / HOClFraction(ph, temperature)
+}
@@ -11,6 +11,14 @@ type Config struct {
 	Server   ServerConfig   `yaml:"server"`
 	Database DatabaseConfig `yaml:"database"`
 	App      AppConfig      `yaml:"app"`
+	Backup   BackupConfig   `yaml:"backup"`
+	Trash    TrashConfig    `yaml:"trash"`
+	Influx   InfluxConfig   `yaml:"influx"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	MQTT     MQTTConfig     `yaml:"mqtt"`
+	Readings ReadingsConfig `yaml:"readings"`
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+	Email    EmailConfig    `yaml:"email"`
 }
 
 type ServerConfig struct {
@@ -19,9 +27,10 @@ type ServerConfig struct {
 }
 
 type DatabaseConfig struct {
-	Type    string         `yaml:"type"`
-	SQLite  SQLiteConfig   `yaml:"sqlite"`
-	MariaDB MariaDBConfig  `yaml:"mariadb"`
+	Type     string         `yaml:"type"`
+	SQLite   SQLiteConfig   `yaml:"sqlite"`
+	MariaDB  MariaDBConfig  `yaml:"mariadb"`
+	Postgres PostgresConfig `yaml:"postgres"`
 }
 
 type SQLiteConfig struct {
@@ -36,6 +45,89 @@ type MariaDBConfig struct {
 	Database string `yaml:"database"`
 }
 
+type PostgresConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Database string `yaml:"database"`
+	SSLMode  string `yaml:"sslmode"` // disable, require, verify-ca, verify-full
+	Schema   string `yaml:"schema"`
+}
+
+type BackupConfig struct {
+	Compress   bool   `yaml:"compress"`
+	Encrypt    bool   `yaml:"encrypt"`
+	Passphrase string `yaml:"passphrase"` // Prompted for on the command line when empty
+}
+
+type TrashConfig struct {
+	RetentionDays int `yaml:"retention_days"` // 0 keeps deleted records until purged by an admin
+}
+
+type InfluxConfig struct {
+	URL             string `yaml:"url"`              // Write endpoint, e.g. http://localhost:8086/api/v2/write?org=home&bucket=pool
+	Token           string `yaml:"token"`            // Sent as "Authorization: Token <token>"; empty sends none
+	Push            bool   `yaml:"push"`             // Push new and edited samples in the background
+	IntervalSeconds int    `yaml:"interval_seconds"` // Time between background pushes
+}
+
+type MetricsConfig struct {
+	Token string `yaml:"token"` // Bearer token required by /metrics; empty leaves it open
+}
+
+type MQTTConfig struct {
+	Enabled  bool              `yaml:"enabled"`
+	Broker   string            `yaml:"broker"` // tcp://localhost:1883, or ssl:// for TLS
+	ClientID string            `yaml:"client_id"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Topics   []MQTTTopicConfig `yaml:"topics"`
+
+	HomeAssistant HomeAssistantConfig `yaml:"home_assistant"`
+}
+
+type MQTTTopicConfig struct {
+	Topic     string            `yaml:"topic"`     // Topic filter; + and # are wildcards
+	Pool      string            `yaml:"pool"`      // Pool name or ID
+	Parameter string            `yaml:"parameter"` // Parameter of a payload holding a plain number
+	Fields    map[string]string `yaml:"fields"`    // Parameters of a JSON payload by field path, e.g. ph: "probe.ph"
+	Celsius   bool              `yaml:"celsius"`   // Temperatures are in °C
+}
+
+type HomeAssistantConfig struct {
+	Enabled         bool   `yaml:"enabled"`          // Publish pools as Home Assistant sensors over MQTT
+	DiscoveryPrefix string `yaml:"discovery_prefix"` // Home Assistant discovery prefix (default: homeassistant)
+	StatePrefix     string `yaml:"state_prefix"`     // Prefix of the state topics (default: waterlogger)
+	IntervalSeconds int    `yaml:"interval_seconds"` // Seconds between state updates (default: 60)
+}
+
+type ReadingsConfig struct {
+	Token             string `yaml:"token"`              // Bearer token devices send to POST /api/readings; empty requires a login session
+	RawDays           int    `yaml:"raw_days"`           // Downsample readings older than this; 0 keeps every reading
+	DownsampleMinutes int    `yaml:"downsample_minutes"` // Interval averaged into one downsampled reading (default: 60)
+	RetentionDays     int    `yaml:"retention_days"`     // Delete readings older than this; 0 keeps them
+}
+
+type WebhooksConfig struct {
+	MaxAttempts    int `yaml:"max_attempts"`    // Attempts before a delivery is given up (default: 8)
+	TimeoutSeconds int `yaml:"timeout_seconds"` // Time a webhook has to respond (default: 10)
+	LogDays        int `yaml:"log_days"`        // Delete finished deliveries older than this; 0 keeps them
+}
+
+type EmailConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Host          string `yaml:"host"`
+	Port          int    `yaml:"port"`
+	Username      string `yaml:"username"` // Empty sends without authentication
+	Password      string `yaml:"password"`
+	From          string `yaml:"from"`           // Sender address, e.g. Waterlogger <pool@example.com>
+	Security      string `yaml:"security"`       // starttls, tls or none (default: starttls)
+	DigestWeekday string `yaml:"digest_weekday"` // Day weekly digests are sent (default: monday)
+	DigestHour    int    `yaml:"digest_hour"`    // Hour of the day digests are sent, in server time (default: 8)
+	OverdueDays   int    `yaml:"overdue_days"`   // Days without a test before a pool without a test schedule is overdue in digests (default: 3)
+}
+
 type AppConfig struct {
 	Name      string `yaml:"name"`
 	Version   string `yaml:"version"`
@@ -102,6 +194,47 @@ func Default() *Config {
 				Password: "password",
 				Database: "waterlogger",
 			},
+			Postgres: PostgresConfig{
+				Host:     "localhost",
+				Port:     5432,
+				Username: "waterlogger",
+				Password: "password",
+				Database: "waterlogger",
+				SSLMode:  "disable",
+				Schema:   "public",
+			},
+		},
+		Trash: TrashConfig{
+			RetentionDays: 30,
+		},
+		Influx: InfluxConfig{
+			IntervalSeconds: 60,
+		},
+		MQTT: MQTTConfig{
+			Broker:   "tcp://localhost:1883",
+			ClientID: "waterlogger",
+			HomeAssistant: HomeAssistantConfig{
+				DiscoveryPrefix: "homeassistant",
+				StatePrefix:     "waterlogger",
+				IntervalSeconds: 60,
+			},
+		},
+		Readings: ReadingsConfig{
+			RawDays:           7,
+			DownsampleMinutes: 60,
+			RetentionDays:     730,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:    8,
+			TimeoutSeconds: 10,
+			LogDays:        30,
+		},
+		Email: EmailConfig{
+			Port:          587,
+			Security:      "starttls",
+			DigestWeekday: "monday",
+			DigestHour:    8,
+			OverdueDays:   3,
 		},
 		App: AppConfig{
 			Name:      "Waterlogger",
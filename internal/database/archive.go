@@ -0,0 +1,212 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"waterlogger/internal/config"
+)
+
+// Backup archives are stored in one of three layouts:
+//
+//   - plain JSON
+//   - gzip-compressed JSON
+//   - encrypted: archiveMagic, a random scrypt salt and AES-GCM nonce, then
+//     the sealed (optionally gzip-compressed) JSON
+//
+// DecodeArchive detects the layout automatically, so restores never need to
+// be told how a backup was written.
+const (
+	archiveMagic    = "WLENC1"
+	archiveSaltSize = 16
+	archiveKeySize  = 32
+
+	// scrypt cost parameters recommended for interactive logins (2017)
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// ErrPassphraseRequired is returned when an encrypted backup is opened without a passphrase
+var ErrPassphraseRequired = errors.New("backup is encrypted: a passphrase is required")
+
+// ArchiveOptions controls how backup archives are written
+type ArchiveOptions struct {
+	Compress   bool
+	Passphrase string // Encrypts the archive when non-empty
+}
+
+// ArchiveOptionsFromConfig builds archive options from the backup configuration
+func ArchiveOptionsFromConfig(cfg config.BackupConfig) ArchiveOptions {
+	opts := ArchiveOptions{Compress: cfg.Compress}
+	if cfg.Encrypt {
+		opts.Passphrase = cfg.Passphrase
+	}
+	return opts
+}
+
+// Encrypted reports whether archives written with these options are encrypted
+func (o ArchiveOptions) Encrypted() bool {
+	return o.Passphrase != ""
+}
+
+// Extension returns the file extension matching the archive layout
+func (o ArchiveOptions) Extension() string {
+	ext := ".json"
+	if o.Compress {
+		ext += ".gz"
+	}
+	if o.Encrypted() {
+		ext += ".enc"
+	}
+	return ext
+}
+
+// ContentType returns the MIME type matching the archive layout
+func (o ArchiveOptions) ContentType() string {
+	switch {
+	case o.Encrypted():
+		return "application/octet-stream"
+	case o.Compress:
+		return "application/gzip"
+	default:
+		return "application/json"
+	}
+}
+
+// EncodeArchive compresses and/or encrypts a JSON payload according to opts
+func EncodeArchive(payload []byte, opts ArchiveOptions) ([]byte, error) {
+	data := payload
+
+	if opts.Compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to compress backup: %v", err)
+		}
+		if err := gz.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress backup: %v", err)
+		}
+		data = buf.Bytes()
+	}
+
+	if !opts.Encrypted() {
+		return data, nil
+	}
+
+	salt := make([]byte, archiveSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+
+	gcm, err := newArchiveCipher(opts.Passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+
+	header := make([]byte, 0, len(archiveMagic)+len(salt)+len(nonce))
+	header = append(header, archiveMagic...)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	// The header is authenticated along with the payload so it cannot be swapped
+	return gcm.Seal(header, nonce, data, header), nil
+}
+
+// DecodeArchive returns the JSON payload of a backup archive in any supported layout
+func DecodeArchive(data []byte, passphrase string) ([]byte, error) {
+	if IsEncryptedArchive(data) {
+		if passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+
+		saltEnd := len(archiveMagic) + archiveSaltSize
+		if len(data) < saltEnd {
+			return nil, fmt.Errorf("encrypted backup is truncated")
+		}
+		salt := data[len(archiveMagic):saltEnd]
+
+		gcm, err := newArchiveCipher(passphrase, salt)
+		if err != nil {
+			return nil, err
+		}
+
+		nonceEnd := saltEnd + gcm.NonceSize()
+		if len(data) < nonceEnd+gcm.Overhead() {
+			return nil, fmt.Errorf("encrypted backup is truncated")
+		}
+
+		plain, err := gcm.Open(nil, data[saltEnd:nonceEnd], data[nonceEnd:], data[:nonceEnd])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt backup: wrong passphrase or corrupted file")
+		}
+		data = plain
+	}
+
+	// gzip streams start with the magic bytes 0x1f 0x8b
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress backup: %v", err)
+		}
+		defer gz.Close()
+
+		plain, err := io.ReadAll(gz)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress backup: %v", err)
+		}
+		data = plain
+	}
+
+	return data, nil
+}
+
+// IsEncryptedArchive reports whether data is an encrypted backup archive
+func IsEncryptedArchive(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(archiveMagic))
+}
+
+// IsEncryptedBackupFile reports whether the backup file at path is encrypted
+func IsEncryptedBackupFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open backup file: %v", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, len(archiveMagic))
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, fmt.Errorf("failed to read backup file: %v", err)
+	}
+
+	return IsEncryptedArchive(header[:n]), nil
+}
+
+// newArchiveCipher derives an AES-256-GCM cipher from a passphrase and salt
+func newArchiveCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, archiveKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive backup key: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cipher: %v", err)
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -3,11 +3,14 @@ package database
 import (
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
 
 	"waterlogger/internal/config"
 	"waterlogger/internal/models"
 
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -43,26 +46,59 @@ func NewDB(cfg *config.Config) (*DB, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to MariaDB database: %w", err)
 		}
+	case "postgres":
+		db, err = gorm.Open(postgres.Open(postgresDSN(cfg.Database.Postgres)), gormConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to PostgreSQL database: %w", err)
+		}
+		if schema := cfg.Database.Postgres.Schema; schema != "" && schema != "public" {
+			quoted := `"` + strings.ReplaceAll(schema, `"`, `""`) + `"`
+			if err := db.Exec("CREATE SCHEMA IF NOT EXISTS " + quoted).Error; err != nil {
+				return nil, fmt.Errorf("failed to create PostgreSQL schema %s: %w", schema, err)
+			}
+		}
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.Database.Type)
 	}
 
+	// A database without tables gets the current schema from AutoMigrate
+	fresh := !db.Migrator().HasTable(&models.User{})
+
 	// Auto-migrate the schema
-	if err := db.AutoMigrate(
-		&models.User{},
-		&models.UserPreferences{},
-		&models.Pool{},
-		&models.Kit{},
-		&models.Sample{},
-		&models.Measurements{},
-		&models.Indices{},
-	); err != nil {
+	if err := db.AutoMigrate(schemaModels()...); err != nil {
 		return nil, fmt.Errorf("failed to auto-migrate schema: %w", err)
 	}
 
+	if err := runSchemaUpgrades(db, cfg, fresh); err != nil {
+		return nil, err
+	}
+
 	return &DB{db}, nil
 }
 
+// postgresDSN builds a connection URL, escaping credentials and selecting
+// the configured schema through search_path
+func postgresDSN(cfg config.PostgresConfig) string {
+	query := url.Values{}
+	sslMode := cfg.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	query.Set("sslmode", sslMode)
+	if cfg.Schema != "" {
+		query.Set("search_path", cfg.Schema)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.Username, cfg.Password),
+		Host:     fmt.Sprintf("%s:%d", cfg.Host, cfg.Port),
+		Path:     "/" + cfg.Database,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
+
 func (db *DB) Close() error {
 	sqlDB, err := db.DB.DB()
 	if err != nil {
@@ -71,7 +107,9 @@ func (db *DB) Close() error {
 	return sqlDB.Close()
 }
 
-// CreateDefaultAdminUser creates a default admin user if no users exist
+// CreateDefaultAdminUser creates a default admin user if no users exist.
+// Databases created or restored from before the admin flag existed have no
+// administrator, so the oldest user is promoted.
 func (db *DB) CreateDefaultAdminUser() error {
 	var count int64
 	if err := db.Model(&models.User{}).Count(&count).Error; err != nil {
@@ -84,7 +122,21 @@ func (db *DB) CreateDefaultAdminUser() error {
 		return nil
 	}
 
-	return nil
+	var admins int64
+	if err := db.Model(&models.User{}).Where("is_admin = ?", true).Count(&admins).Error; err != nil {
+		return err
+	}
+	if admins > 0 {
+		return nil
+	}
+
+	var user models.User
+	if err := db.Order("id ASC").First(&user).Error; err != nil {
+		return err
+	}
+
+	log.Printf("No administrator found, making user %s an administrator", user.Username)
+	return db.Model(&user).UpdateColumn("is_admin", true).Error
 }
 
 // Migration utilities
@@ -93,28 +145,28 @@ func (db *DB) ExportData() (map[string]interface{}, error) {
 
 	// Export users
 	var users []models.User
-	if err := db.Preload("Preferences").Find(&users).Error; err != nil {
+	if err := db.Unscoped().Preload("Preferences").Find(&users).Error; err != nil {
 		return nil, err
 	}
 	data["users"] = users
 
 	// Export pools
 	var pools []models.Pool
-	if err := db.Find(&pools).Error; err != nil {
+	if err := db.Unscoped().Find(&pools).Error; err != nil {
 		return nil, err
 	}
 	data["pools"] = pools
 
 	// Export kits
 	var kits []models.Kit
-	if err := db.Find(&kits).Error; err != nil {
+	if err := db.Unscoped().Find(&kits).Error; err != nil {
 		return nil, err
 	}
 	data["kits"] = kits
 
 	// Export samples with measurements and indices
 	var samples []models.Sample
-	if err := db.Preload("Measurements").Preload("Indices").Find(&samples).Error; err != nil {
+	if err := db.Unscoped().Preload("Measurements").Preload("Indices").Preload("Additions").Find(&samples).Error; err != nil {
 		return nil, err
 	}
 	data["samples"] = samples
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// DeletePreview counts the rows removed by deleting a pool or sample
+type DeletePreview struct {
+	Pools        int64 `json:"pools"`
+	Samples      int64 `json:"samples"`
+	Measurements int64 `json:"measurements"`
+	Indices      int64 `json:"indices"`
+	Additions    int64 `json:"additions"`
+}
+
+// HasDependents reports whether the delete removes more than the record itself
+func (p *DeletePreview) HasDependents() bool {
+	return p.Samples > 0 || p.Measurements > 0 || p.Indices > 0 || p.Additions > 0
+}
+
+// PreviewPoolDelete counts the pool's samples and their measurements, indices
+// and additions
+func PreviewPoolDelete(db *gorm.DB, poolID uint) (*DeletePreview, error) {
+	preview := &DeletePreview{Pools: 1}
+	samples := poolSampleIDs(db, poolID)
+
+	if err := db.Model(&models.Sample{}).Where("pool_id = ?", poolID).Count(&preview.Samples).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Measurements{}).Where("sample_id IN (?)", samples).Count(&preview.Measurements).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Indices{}).Where("sample_id IN (?)", samples).Count(&preview.Indices).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Addition{}).Where("sample_id IN (?)", samples).Count(&preview.Additions).Error; err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// PreviewSampleDelete counts the sample's measurements, indices and additions
+func PreviewSampleDelete(db *gorm.DB, sampleID uint) (*DeletePreview, error) {
+	preview := &DeletePreview{Samples: 1}
+
+	if err := db.Model(&models.Measurements{}).Where("sample_id = ?", sampleID).Count(&preview.Measurements).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Indices{}).Where("sample_id = ?", sampleID).Count(&preview.Indices).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Model(&models.Addition{}).Where("sample_id = ?", sampleID).Count(&preview.Additions).Error; err != nil {
+		return nil, err
+	}
+
+	return preview, nil
+}
+
+// DeletePool moves a pool to the trash together with its samples and
+// everything they own
+func DeletePool(db *gorm.DB, poolID uint) (*DeletePreview, error) {
+	var preview *DeletePreview
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if preview, err = PreviewPoolDelete(tx, poolID); err != nil {
+			return err
+		}
+		return MoveToTrash(tx, "pools", poolID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// DeleteSample moves a sample to the trash together with its measurements,
+// indices and additions
+func DeleteSample(db *gorm.DB, sampleID uint) (*DeletePreview, error) {
+	var preview *DeletePreview
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if preview, err = PreviewSampleDelete(tx, sampleID); err != nil {
+			return err
+		}
+		return MoveToTrash(tx, "samples", sampleID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return preview, nil
+}
+
+// poolSampleIDs returns a subquery selecting the IDs of all of a pool's
+// samples, including trashed ones
+func poolSampleIDs(db *gorm.DB, poolID uint) *gorm.DB {
+	return db.Unscoped().Model(&models.Sample{}).Select("id").Where("pool_id = ?", poolID)
+}
+
+// poolTaskIDs returns a subquery selecting the IDs of all of a pool's
+// maintenance tasks, including trashed ones
+func poolTaskIDs(db *gorm.DB, poolID uint) *gorm.DB {
+	return db.Unscoped().Model(&models.MaintenanceTask{}).Select("id").Where("pool_id = ?", poolID)
+}
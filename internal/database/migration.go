@@ -6,9 +6,11 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"waterlogger/internal/config"
 	"waterlogger/internal/models"
 )
@@ -24,9 +26,20 @@ type BackupData struct {
 	Samples          []models.Sample        `json:"samples"`
 	Measurements     []models.Measurements  `json:"measurements"`
 	Indices          []models.Indices       `json:"indices"`
+	Additions        []models.Addition      `json:"additions"`
+	SensorReadings   []models.SensorReading `json:"sensor_readings"`
+	ORPCalibrations  []models.ORPCalibration `json:"orp_calibrations"`
+	ReportTemplates  []models.ReportTemplate `json:"report_templates"`
+	Webhooks         []models.Webhook       `json:"webhooks"`
+	WebhookDeliveries []models.WebhookDelivery `json:"webhook_deliveries"`
+	NotificationPreferences []models.NotificationPreferences `json:"notification_preferences"`
+	NotificationChannels []models.NotificationChannel `json:"notification_channels"`
+	TestSchedules    []models.TestSchedule  `json:"test_schedules"`
+	MaintenanceTasks []models.MaintenanceTask `json:"maintenance_tasks"`
+	MaintenanceCompletions []models.MaintenanceCompletion `json:"maintenance_completions"`
 }
 
-// DatabaseMigrator handles database migrations between SQLite and MariaDB
+// DatabaseMigrator handles database migrations between SQLite, MariaDB and PostgreSQL
 type DatabaseMigrator struct {
 	sourceDB *gorm.DB
 	targetDB *gorm.DB
@@ -41,99 +54,174 @@ func NewDatabaseMigrator(sourceDB, targetDB *gorm.DB) *DatabaseMigrator {
 }
 
 // CreateBackup creates a complete backup of the database
-func (dm *DatabaseMigrator) CreateBackup(backupPath string) error {
+func (dm *DatabaseMigrator) CreateBackup(backupPath string, opts ArchiveOptions) error {
 	log.Printf("Creating backup at %s", backupPath)
 	
 	backup := BackupData{
 		Timestamp:      time.Now(),
-		SourceDatabase: "unknown", // Will be set by caller
+		SourceDatabase: dm.sourceDB.Dialector.Name(),
 	}
 	
 	// Backup Users
-	if err := dm.sourceDB.Find(&backup.Users).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.Users).Error; err != nil {
 		return fmt.Errorf("failed to backup users: %v", err)
 	}
 	
 	// Backup UserPreferences
-	if err := dm.sourceDB.Find(&backup.UserPreferences).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.UserPreferences).Error; err != nil {
 		return fmt.Errorf("failed to backup user preferences: %v", err)
 	}
 	
 	// Backup Pools
-	if err := dm.sourceDB.Find(&backup.Pools).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.Pools).Error; err != nil {
 		return fmt.Errorf("failed to backup pools: %v", err)
 	}
 	
 	// Backup Kits
-	if err := dm.sourceDB.Find(&backup.Kits).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.Kits).Error; err != nil {
 		return fmt.Errorf("failed to backup kits: %v", err)
 	}
 	
 	// Backup Samples
-	if err := dm.sourceDB.Find(&backup.Samples).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.Samples).Error; err != nil {
 		return fmt.Errorf("failed to backup samples: %v", err)
 	}
 	
 	// Backup Measurements
-	if err := dm.sourceDB.Find(&backup.Measurements).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.Measurements).Error; err != nil {
 		return fmt.Errorf("failed to backup measurements: %v", err)
 	}
 	
 	// Backup Indices
-	if err := dm.sourceDB.Find(&backup.Indices).Error; err != nil {
+	if err := dm.sourceDB.Unscoped().Find(&backup.Indices).Error; err != nil {
 		return fmt.Errorf("failed to backup indices: %v", err)
 	}
 	
+	// Backup Additions
+	if err := dm.sourceDB.Unscoped().Find(&backup.Additions).Error; err != nil {
+		return fmt.Errorf("failed to backup additions: %v", err)
+	}
+	
+	// Backup SensorReadings
+	if err := dm.sourceDB.Find(&backup.SensorReadings).Error; err != nil {
+		return fmt.Errorf("failed to backup sensor readings: %v", err)
+	}
+	
+	// Backup ORPCalibrations
+	if err := dm.sourceDB.Unscoped().Find(&backup.ORPCalibrations).Error; err != nil {
+		return fmt.Errorf("failed to backup ORP calibrations: %v", err)
+	}
+	
+	// Backup ReportTemplates
+	if err := dm.sourceDB.Unscoped().Find(&backup.ReportTemplates).Error; err != nil {
+		return fmt.Errorf("failed to backup report templates: %v", err)
+	}
+	
+	// Backup Webhooks
+	if err := dm.sourceDB.Unscoped().Find(&backup.Webhooks).Error; err != nil {
+		return fmt.Errorf("failed to backup webhooks: %v", err)
+	}
+	
+	// Backup WebhookDeliveries
+	if err := dm.sourceDB.Find(&backup.WebhookDeliveries).Error; err != nil {
+		return fmt.Errorf("failed to backup webhook deliveries: %v", err)
+	}
+	
+	// Backup NotificationPreferences
+	if err := dm.sourceDB.Unscoped().Find(&backup.NotificationPreferences).Error; err != nil {
+		return fmt.Errorf("failed to backup notification preferences: %v", err)
+	}
+	
+	// Backup NotificationChannels
+	if err := dm.sourceDB.Unscoped().Find(&backup.NotificationChannels).Error; err != nil {
+		return fmt.Errorf("failed to backup notification channels: %v", err)
+	}
+	
+	// Backup TestSchedules
+	if err := dm.sourceDB.Unscoped().Find(&backup.TestSchedules).Error; err != nil {
+		return fmt.Errorf("failed to backup test schedules: %v", err)
+	}
+	
+	// Backup MaintenanceTasks
+	if err := dm.sourceDB.Unscoped().Find(&backup.MaintenanceTasks).Error; err != nil {
+		return fmt.Errorf("failed to backup maintenance tasks: %v", err)
+	}
+	
+	// Backup MaintenanceCompletions
+	if err := dm.sourceDB.Unscoped().Find(&backup.MaintenanceCompletions).Error; err != nil {
+		return fmt.Errorf("failed to backup maintenance completions: %v", err)
+	}
+	
 	// Create backup directory if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(backupPath), 0755); err != nil {
 		return fmt.Errorf("failed to create backup directory: %v", err)
 	}
 	
-	// Write backup to file
-	file, err := os.Create(backupPath)
+	payload, err := json.MarshalIndent(backup, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to create backup file: %v", err)
+		return fmt.Errorf("failed to encode backup data: %v", err)
 	}
-	defer file.Close()
 	
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(backup); err != nil {
-		return fmt.Errorf("failed to write backup data: %v", err)
+	archive, err := EncodeArchive(payload, opts)
+	if err != nil {
+		return err
+	}
+	
+	// Backups contain password hashes and emails, so keep them owner-only
+	if err := os.WriteFile(backupPath, archive, 0600); err != nil {
+		return fmt.Errorf("failed to write backup file: %v", err)
 	}
 	
-	log.Printf("Backup created successfully with %d users, %d pools, %d samples", 
-		len(backup.Users), len(backup.Pools), len(backup.Samples))
+	log.Printf("Backup created successfully with %d users, %d pools, %d samples (compressed: %t, encrypted: %t)", 
+		len(backup.Users), len(backup.Pools), len(backup.Samples), opts.Compress, opts.Encrypted())
 	
 	return nil
 }
 
-// RestoreFromBackup restores data from a backup file
-func (dm *DatabaseMigrator) RestoreFromBackup(backupPath string) error {
+// restoreSampleDeletedAt copies deleted_at from the samples of a backup
+// onto the decoded samples, so trashed samples stay in the trash. The
+// Sample unmarshaler leaves it out so API clients cannot trash or restore
+// samples by updating them.
+func restoreSampleDeletedAt(payload []byte, samples []models.Sample) error {
+	var trashed struct {
+		Samples []struct {
+			DeletedAt gorm.DeletedAt `json:"deleted_at"`
+		} `json:"samples"`
+	}
+	if err := json.Unmarshal(payload, &trashed); err != nil {
+		return err
+	}
+	for i := range samples {
+		samples[i].DeletedAt = trashed.Samples[i].DeletedAt
+	}
+	return nil
+}
+
+// RestoreFromBackup restores data from a backup file in any supported archive layout
+func (dm *DatabaseMigrator) RestoreFromBackup(backupPath string, passphrase string) error {
 	log.Printf("Restoring from backup at %s", backupPath)
 	
 	// Read backup file
-	file, err := os.Open(backupPath)
+	archive, err := os.ReadFile(backupPath)
 	if err != nil {
 		return fmt.Errorf("failed to open backup file: %v", err)
 	}
-	defer file.Close()
+	
+	payload, err := DecodeArchive(archive, passphrase)
+	if err != nil {
+		return err
+	}
 	
 	var backup BackupData
-	if err := json.NewDecoder(file).Decode(&backup); err != nil {
+	if err := json.Unmarshal(payload, &backup); err != nil {
+		return fmt.Errorf("failed to decode backup data: %v", err)
+	}
+	if err := restoreSampleDeletedAt(payload, backup.Samples); err != nil {
 		return fmt.Errorf("failed to decode backup data: %v", err)
 	}
 	
 	// Ensure target database has the correct schema
-	if err := dm.targetDB.AutoMigrate(
-		&models.User{},
-		&models.UserPreferences{},
-		&models.Pool{},
-		&models.Kit{},
-		&models.Sample{},
-		&models.Measurements{},
-		&models.Indices{},
-	); err != nil {
+	if err := dm.targetDB.AutoMigrate(schemaModels()...); err != nil {
 		return fmt.Errorf("failed to migrate target database schema: %v", err)
 	}
 	
@@ -188,113 +276,296 @@ func (dm *DatabaseMigrator) RestoreFromBackup(backupPath string) error {
 		}
 	}
 	
-	log.Printf("Restore completed successfully")
-	return nil
-}
-
-// MigrateDatabase migrates data from SQLite to MariaDB or vice versa
-func (dm *DatabaseMigrator) MigrateDatabase(tempBackupPath string) error {
-	log.Printf("Starting database migration")
+	// 8. Additions (depends on Samples)
+	if len(backup.Additions) > 0 {
+		if err := dm.targetDB.Create(&backup.Additions).Error; err != nil {
+			return fmt.Errorf("failed to restore additions: %v", err)
+		}
+	}
 	
-	// Step 1: Create backup of source database
-	if err := dm.CreateBackup(tempBackupPath); err != nil {
-		return fmt.Errorf("failed to create backup: %v", err)
+	// 9. SensorReadings (depends on Pools)
+	if len(backup.SensorReadings) > 0 {
+		if err := dm.targetDB.CreateInBatches(&backup.SensorReadings, migrationBatchSize).Error; err != nil {
+			return fmt.Errorf("failed to restore sensor readings: %v", err)
+		}
 	}
 	
-	// Step 2: Restore to target database
-	if err := dm.RestoreFromBackup(tempBackupPath); err != nil {
-		return fmt.Errorf("failed to restore to target database: %v", err)
+	// 10. ORPCalibrations (depends on Pools)
+	if len(backup.ORPCalibrations) > 0 {
+		if err := dm.targetDB.Create(&backup.ORPCalibrations).Error; err != nil {
+			return fmt.Errorf("failed to restore ORP calibrations: %v", err)
+		}
 	}
 	
-	// Step 3: Clean up temporary backup file
-	if err := os.Remove(tempBackupPath); err != nil {
-		log.Printf("Warning: failed to remove temporary backup file: %v", err)
+	// 11. ReportTemplates (no dependencies)
+	if len(backup.ReportTemplates) > 0 {
+		if err := dm.targetDB.Create(&backup.ReportTemplates).Error; err != nil {
+			return fmt.Errorf("failed to restore report templates: %v", err)
+		}
 	}
 	
-	log.Printf("Database migration completed successfully")
+	// 12. Webhooks (no dependencies)
+	if len(backup.Webhooks) > 0 {
+		if err := dm.targetDB.Create(&backup.Webhooks).Error; err != nil {
+			return fmt.Errorf("failed to restore webhooks: %v", err)
+		}
+	}
+	
+	// 13. WebhookDeliveries (depends on Webhooks)
+	if len(backup.WebhookDeliveries) > 0 {
+		if err := dm.targetDB.CreateInBatches(&backup.WebhookDeliveries, migrationBatchSize).Error; err != nil {
+			return fmt.Errorf("failed to restore webhook deliveries: %v", err)
+		}
+	}
+	
+	// 14. NotificationPreferences (depends on Users)
+	if len(backup.NotificationPreferences) > 0 {
+		if err := dm.targetDB.Create(&backup.NotificationPreferences).Error; err != nil {
+			return fmt.Errorf("failed to restore notification preferences: %v", err)
+		}
+	}
+	
+	// 15. NotificationChannels (depends on Users)
+	if len(backup.NotificationChannels) > 0 {
+		if err := dm.targetDB.Create(&backup.NotificationChannels).Error; err != nil {
+			return fmt.Errorf("failed to restore notification channels: %v", err)
+		}
+	}
+	
+	// 16. TestSchedules (depends on Pools)
+	if len(backup.TestSchedules) > 0 {
+		if err := dm.targetDB.Create(&backup.TestSchedules).Error; err != nil {
+			return fmt.Errorf("failed to restore test schedules: %v", err)
+		}
+	}
+	
+	// 17. MaintenanceTasks (depends on Pools)
+	if len(backup.MaintenanceTasks) > 0 {
+		if err := dm.targetDB.Create(&backup.MaintenanceTasks).Error; err != nil {
+			return fmt.Errorf("failed to restore maintenance tasks: %v", err)
+		}
+	}
+	
+	// 18. MaintenanceCompletions (depends on MaintenanceTasks, Samples)
+	if len(backup.MaintenanceCompletions) > 0 {
+		if err := dm.targetDB.Create(&backup.MaintenanceCompletions).Error; err != nil {
+			return fmt.Errorf("failed to restore maintenance completions: %v", err)
+		}
+	}
+	
+	log.Printf("Restore completed successfully")
 	return nil
 }
 
-// MigrateSQLiteToMariaDB migrates data from SQLite to MariaDB
-func MigrateSQLiteToMariaDB(cfg *config.Config) error {
-	log.Printf("Migrating from SQLite to MariaDB")
+// MigrationOptions controls how MigrateDatabase treats the target database
+type MigrationOptions struct {
+	Truncate bool // Empty a non-empty target instead of refusing to migrate
+}
+
+// migrationBatchSize is the number of rows copied or hashed per query
+const migrationBatchSize = 500
+
+// MigrateDatabase copies every table from the source to the target database
+// in a single transaction. Primary keys are preserved, so relationships
+// survive the move, and each table is verified by row count and checksum
+// before the transaction commits. A non-empty target is refused unless
+// opts.Truncate is set.
+func (dm *DatabaseMigrator) MigrateDatabase(opts MigrationOptions) (*MigrationReport, error) {
+	log.Printf("Starting database migration")
 	
-	// Create SQLite connection
-	sqliteConfig := &config.Config{
-		Database: config.DatabaseConfig{
-			Type:   "sqlite",
-			SQLite: cfg.Database.SQLite,
-		},
+	// Step 1: Ensure target database has the correct schema
+	if err := dm.targetDB.AutoMigrate(schemaModels()...); err != nil {
+		return nil, fmt.Errorf("failed to migrate target database schema: %v", err)
 	}
 	
-	sqliteDB, err := NewDB(sqliteConfig)
+	// Step 2: Make sure we are not about to duplicate data
+	nonEmpty, err := dm.nonEmptyTargetTables()
 	if err != nil {
-		return fmt.Errorf("failed to connect to SQLite database: %v", err)
+		return nil, err
+	}
+	if len(nonEmpty) > 0 && !opts.Truncate {
+		return nil, fmt.Errorf("target database is not empty (%s); rerun with -truncate-target to replace its contents",
+			strings.Join(nonEmpty, ", "))
 	}
-	defer sqliteDB.Close()
 	
-	// Create MariaDB connection
-	mariadbConfig := &config.Config{
-		Database: config.DatabaseConfig{
-			Type:    "mariadb",
-			MariaDB: cfg.Database.MariaDB,
-		},
+	report := &MigrationReport{
+		Source: dm.sourceDB.Dialector.Name(),
+		Target: dm.targetDB.Dialector.Name(),
 	}
 	
-	mariadbDB, err := NewDB(mariadbConfig)
+	// Step 3: Copy and verify everything in one transaction
+	err = dm.targetDB.Transaction(func(tx *gorm.DB) error {
+		if len(nonEmpty) > 0 {
+			log.Printf("Truncating target tables: %s", strings.Join(nonEmpty, ", "))
+			if err := truncateTables(tx); err != nil {
+				return err
+			}
+		}
+		
+		for _, t := range migrationTables {
+			if err := dm.copyTable(tx, t); err != nil {
+				return err
+			}
+		}
+		
+		// Verify before committing so a mismatch leaves the target untouched
+		for _, t := range migrationTables {
+			table, err := tableName(tx, t.model)
+			if err != nil {
+				return err
+			}
+			
+			tableReport := TableReport{Table: table}
+			if tableReport.SourceRows, tableReport.SourceChecksum, err = tableChecksum(dm.sourceDB, t); err != nil {
+				return fmt.Errorf("failed to checksum source table %s: %v", table, err)
+			}
+			if tableReport.TargetRows, tableReport.TargetChecksum, err = tableChecksum(tx, t); err != nil {
+				return fmt.Errorf("failed to checksum target table %s: %v", table, err)
+			}
+			report.Tables = append(report.Tables, tableReport)
+		}
+		
+		log.Print(report.String())
+		if !report.OK() {
+			return fmt.Errorf("verification failed: target data does not match source")
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to connect to MariaDB database: %v", err)
+		return report, fmt.Errorf("migration rolled back: %v", err)
 	}
-	defer mariadbDB.Close()
 	
-	// Perform migration
-	migrator := NewDatabaseMigrator(sqliteDB.DB, mariadbDB.DB)
-	tempBackupPath := fmt.Sprintf("temp_backup_%d.json", time.Now().Unix())
+	// Step 4: Move auto-increment sequences past the preserved IDs
+	for _, t := range migrationTables {
+		table, err := tableName(dm.targetDB, t.model)
+		if err != nil {
+			return report, err
+		}
+		if err := resetSequence(dm.targetDB, table); err != nil {
+			return report, fmt.Errorf("data migrated but failed to reset sequence for %s: %v", table, err)
+		}
+	}
 	
-	return migrator.MigrateDatabase(tempBackupPath)
+	log.Printf("Database migration completed successfully")
+	return report, nil
 }
 
-// MigrateMariaDBToSQLite migrates data from MariaDB to SQLite
-func MigrateMariaDBToSQLite(cfg *config.Config) error {
-	log.Printf("Migrating from MariaDB to SQLite")
-	
-	// Create MariaDB connection
-	mariadbConfig := &config.Config{
-		Database: config.DatabaseConfig{
-			Type:    "mariadb",
-			MariaDB: cfg.Database.MariaDB,
-		},
+// nonEmptyTargetTables lists target tables that already contain rows
+func (dm *DatabaseMigrator) nonEmptyTargetTables() ([]string, error) {
+	var nonEmpty []string
+	for _, t := range migrationTables {
+		table, err := tableName(dm.targetDB, t.model)
+		if err != nil {
+			return nil, err
+		}
+		
+		var count int64
+		if err := dm.targetDB.Unscoped().Model(t.model).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to count rows in %s: %v", table, err)
+		}
+		if count > 0 {
+			nonEmpty = append(nonEmpty, fmt.Sprintf("%s: %d rows", table, count))
+		}
+	}
+	return nonEmpty, nil
+}
+
+// truncateTables deletes every row from the migrated tables, children first
+func truncateTables(tx *gorm.DB) error {
+	for i := len(migrationTables) - 1; i >= 0; i-- {
+		t := migrationTables[i]
+		if err := tx.Session(&gorm.Session{AllowGlobalUpdate: true}).Unscoped().Delete(t.model).Error; err != nil {
+			table, _ := tableName(tx, t.model)
+			return fmt.Errorf("failed to truncate %s: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// copyTable copies all rows of a table in batches, preserving primary keys
+func (dm *DatabaseMigrator) copyTable(tx *gorm.DB, t migrationTable) error {
+	table, err := tableName(tx, t.model)
+	if err != nil {
+		return err
 	}
 	
-	mariadbDB, err := NewDB(mariadbConfig)
+	var copied int
+	rows := t.rows()
+	err = dm.sourceDB.Unscoped().Model(t.model).FindInBatches(rows, migrationBatchSize, func(batch *gorm.DB, _ int) error {
+		copied += int(batch.RowsAffected)
+		return tx.Omit(clause.Associations).Create(rows).Error
+	}).Error
 	if err != nil {
-		return fmt.Errorf("failed to connect to MariaDB database: %v", err)
+		return fmt.Errorf("failed to copy %s: %v", table, err)
 	}
-	defer mariadbDB.Close()
 	
-	// Create SQLite connection
-	sqliteConfig := &config.Config{
-		Database: config.DatabaseConfig{
-			Type:   "sqlite",
-			SQLite: cfg.Database.SQLite,
-		},
+	log.Printf("Copied %d rows into %s", copied, table)
+	return nil
+}
+
+// openDatabase connects to one of the configured databases by type
+func openDatabase(cfg *config.Config, dbType string) (*DB, error) {
+	dbConfig := *cfg
+	dbConfig.Database.Type = dbType
+	return NewDB(&dbConfig)
+}
+
+// SupportedDatabaseTypes lists the database types accepted by NewDB and MigrateBetween
+var SupportedDatabaseTypes = []string{"sqlite", "mariadb", "postgres"}
+
+// MigrateBetween migrates all data from one configured database to another.
+// Any pair of supported database types can be used.
+func MigrateBetween(cfg *config.Config, sourceType, targetType string, opts MigrationOptions) (*MigrationReport, error) {
+	if !isSupportedDatabaseType(sourceType) {
+		return nil, fmt.Errorf("unsupported source database type: %s", sourceType)
+	}
+	if !isSupportedDatabaseType(targetType) {
+		return nil, fmt.Errorf("unsupported target database type: %s", targetType)
 	}
+	if sourceType == targetType {
+		return nil, fmt.Errorf("source and target database are both %s", sourceType)
+	}
+	
+	log.Printf("Migrating from %s to %s", sourceType, targetType)
 	
-	sqliteDB, err := NewDB(sqliteConfig)
+	sourceDB, err := openDatabase(cfg, sourceType)
 	if err != nil {
-		return fmt.Errorf("failed to connect to SQLite database: %v", err)
+		return nil, fmt.Errorf("failed to connect to source database: %v", err)
 	}
-	defer sqliteDB.Close()
+	defer sourceDB.Close()
 	
-	// Perform migration
-	migrator := NewDatabaseMigrator(mariadbDB.DB, sqliteDB.DB)
-	tempBackupPath := fmt.Sprintf("temp_backup_%d.json", time.Now().Unix())
+	targetDB, err := openDatabase(cfg, targetType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to target database: %v", err)
+	}
+	defer targetDB.Close()
 	
-	return migrator.MigrateDatabase(tempBackupPath)
+	migrator := NewDatabaseMigrator(sourceDB.DB, targetDB.DB)
+	return migrator.MigrateDatabase(opts)
+}
+
+func isSupportedDatabaseType(dbType string) bool {
+	for _, t := range SupportedDatabaseTypes {
+		if t == dbType {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateSQLiteToMariaDB migrates data from SQLite to MariaDB
+func MigrateSQLiteToMariaDB(cfg *config.Config, opts MigrationOptions) (*MigrationReport, error) {
+	return MigrateBetween(cfg, "sqlite", "mariadb", opts)
+}
+
+// MigrateMariaDBToSQLite migrates data from MariaDB to SQLite
+func MigrateMariaDBToSQLite(cfg *config.Config, opts MigrationOptions) (*MigrationReport, error) {
+	return MigrateBetween(cfg, "mariadb", "sqlite", opts)
 }
 
-// ExportData exports database data to a backup file
-func ExportData(db *gorm.DB, backupPath string, databaseType string) error {
+// ExportData exports database data to a backup file, compressing and
+// encrypting it according to opts
+func ExportData(db *gorm.DB, backupPath string, databaseType string, opts ArchiveOptions) error {
 	migrator := &DatabaseMigrator{sourceDB: db}
 	
 	// Create backup directory if it doesn't exist
@@ -302,11 +573,12 @@ func ExportData(db *gorm.DB, backupPath string, databaseType string) error {
 		return fmt.Errorf("failed to create backup directory: %v", err)
 	}
 	
-	return migrator.CreateBackup(backupPath)
+	return migrator.CreateBackup(backupPath, opts)
 }
 
-// ImportData imports database data from a backup file
-func ImportData(db *gorm.DB, backupPath string) error {
+// ImportData imports database data from a backup file. The passphrase is
+// only needed for encrypted backups.
+func ImportData(db *gorm.DB, backupPath string, passphrase string) error {
 	migrator := &DatabaseMigrator{targetDB: db}
-	return migrator.RestoreFromBackup(backupPath)
+	return migrator.RestoreFromBackup(backupPath, passphrase)
 }
\ No newline at end of file
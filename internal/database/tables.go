@@ -0,0 +1,55 @@
+package database
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// migrationTable describes a table copied by DatabaseMigrator
+type migrationTable struct {
+	model interface{}
+	rows  func() interface{} // Returns a pointer to an empty slice of the model
+}
+
+// migrationTables lists every table in dependency order: parents come
+// before the tables that reference them.
+var migrationTables = []migrationTable{
+	{&models.User{}, func() interface{} { return &[]models.User{} }},
+	{&models.UserPreferences{}, func() interface{} { return &[]models.UserPreferences{} }},
+	{&models.NotificationPreferences{}, func() interface{} { return &[]models.NotificationPreferences{} }},
+	{&models.NotificationChannel{}, func() interface{} { return &[]models.NotificationChannel{} }},
+	{&models.Pool{}, func() interface{} { return &[]models.Pool{} }},
+	{&models.Kit{}, func() interface{} { return &[]models.Kit{} }},
+	{&models.Sample{}, func() interface{} { return &[]models.Sample{} }},
+	{&models.Measurements{}, func() interface{} { return &[]models.Measurements{} }},
+	{&models.Indices{}, func() interface{} { return &[]models.Indices{} }},
+	{&models.Addition{}, func() interface{} { return &[]models.Addition{} }},
+	{&models.SensorReading{}, func() interface{} { return &[]models.SensorReading{} }},
+	{&models.ORPCalibration{}, func() interface{} { return &[]models.ORPCalibration{} }},
+	{&models.TestSchedule{}, func() interface{} { return &[]models.TestSchedule{} }},
+	{&models.MaintenanceTask{}, func() interface{} { return &[]models.MaintenanceTask{} }},
+	{&models.MaintenanceCompletion{}, func() interface{} { return &[]models.MaintenanceCompletion{} }},
+	{&models.ReportTemplate{}, func() interface{} { return &[]models.ReportTemplate{} }},
+	{&models.Webhook{}, func() interface{} { return &[]models.Webhook{} }},
+	{&models.WebhookDelivery{}, func() interface{} { return &[]models.WebhookDelivery{} }},
+}
+
+// schemaModels returns the models to auto-migrate, in dependency order
+func schemaModels() []interface{} {
+	schema := make([]interface{}, 0, len(migrationTables)+1)
+	for _, t := range migrationTables {
+		schema = append(schema, t.model)
+	}
+	return append(schema, &models.SchemaMigration{})
+}
+
+// tableName resolves the table name GORM uses for a model
+func tableName(db *gorm.DB, model interface{}) (string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return "", fmt.Errorf("failed to resolve table name: %v", err)
+	}
+	return stmt.Schema.Table, nil
+}
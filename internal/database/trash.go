@@ -0,0 +1,393 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+var (
+	ErrUnknownTrashType = errors.New("unknown trash type")
+	ErrNotInTrash       = errors.New("record is not in the trash")
+	ErrParentInTrash    = errors.New("record belongs to a parent that is in the trash")
+	ErrStillReferenced  = errors.New("record is still referenced by samples")
+)
+
+// TrashTypes lists the record types that can be trashed, restored and
+// purged, in the order they are purged
+var TrashTypes = []string{"samples", "pools", "kits", "users"}
+
+// trashRetentionInterval is how often StartTrashRetention looks for expired items
+const trashRetentionInterval = time.Hour
+
+// TrashItem describes a deleted record waiting in the trash
+type TrashItem struct {
+	Type      string    `json:"type"`
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// trashDependent selects rows owned by a trashed record. They are trashed,
+// restored and purged together with it.
+type trashDependent struct {
+	model interface{}
+	where func(db *gorm.DB, id uint) *gorm.DB
+}
+
+// trashReference is a nullable column of rows that outlive the records it
+// references. It is cleared when a referenced record is purged.
+type trashReference struct {
+	model  interface{}
+	column string
+}
+
+// trashType describes how one record type moves through the trash
+type trashType struct {
+	model      interface{}
+	dependents []trashDependent // Children first
+	owned      []trashDependent // Rows without a trash state, kept until the record is purged
+	references []trashReference // Links to the record that are cleared when it is purged
+	list       func(db *gorm.DB) ([]TrashItem, error)
+	canRestore func(db *gorm.DB, id uint) error
+	canPurge   func(db *gorm.DB, id uint) error
+}
+
+var sampleDependents = []trashDependent{
+	{&models.Addition{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("sample_id = ?", id) }},
+	{&models.Indices{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("sample_id = ?", id) }},
+	{&models.Measurements{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("sample_id = ?", id) }},
+}
+
+var trashTypes = map[string]trashType{
+	"pools": {
+		model: &models.Pool{},
+		dependents: []trashDependent{
+			{&models.Addition{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("sample_id IN (?)", poolSampleIDs(db, id)) }},
+			{&models.Indices{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("sample_id IN (?)", poolSampleIDs(db, id)) }},
+			{&models.Measurements{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("sample_id IN (?)", poolSampleIDs(db, id)) }},
+			{&models.Sample{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("pool_id = ?", id) }},
+			{&models.ORPCalibration{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("pool_id = ?", id) }},
+			{&models.TestSchedule{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("pool_id = ?", id) }},
+			{&models.MaintenanceCompletion{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("task_id IN (?)", poolTaskIDs(db, id)) }},
+			{&models.MaintenanceTask{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("pool_id = ?", id) }},
+		},
+		owned: []trashDependent{
+			{&models.SensorReading{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("pool_id = ?", id) }},
+		},
+		list: listTrashedPools,
+	},
+	"samples": {
+		model:      &models.Sample{},
+		dependents: sampleDependents,
+		references: []trashReference{
+			{&models.MaintenanceCompletion{}, "sample_id"},
+		},
+		list: listTrashedSamples,
+		canRestore: func(db *gorm.DB, id uint) error {
+			var sample models.Sample
+			if err := db.Unscoped().First(&sample, id).Error; err != nil {
+				return err
+			}
+			var pools int64
+			if err := db.Model(&models.Pool{}).Where("id = ?", sample.PoolID).Count(&pools).Error; err != nil {
+				return err
+			}
+			if pools == 0 {
+				return ErrParentInTrash
+			}
+			return nil
+		},
+	},
+	"kits": {
+		model: &models.Kit{},
+		list:  listTrashedKits,
+		canPurge: func(db *gorm.DB, id uint) error {
+			return ensureUnreferenced(db.Unscoped().Model(&models.Sample{}).Where("kit_id = ?", id))
+		},
+	},
+	"users": {
+		model: &models.User{},
+		dependents: []trashDependent{
+			{&models.UserPreferences{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("user_id = ?", id) }},
+			{&models.NotificationPreferences{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("user_id = ?", id) }},
+			{&models.NotificationChannel{}, func(db *gorm.DB, id uint) *gorm.DB { return db.Where("user_id = ?", id) }},
+		},
+		list: listTrashedUsers,
+		canPurge: func(db *gorm.DB, id uint) error {
+			if err := ensureUnreferenced(db.Unscoped().Model(&models.Sample{}).Where("user_id = ?", id)); err != nil {
+				return err
+			}
+			return ensureUnreferenced(db.Unscoped().Model(&models.Pool{}).Where("created_by = ? OR updated_by = ?", id, id))
+		},
+	},
+}
+
+// IsTrashType reports whether typ names a record type that uses the trash
+func IsTrashType(typ string) bool {
+	_, ok := trashTypes[typ]
+	return ok
+}
+
+// ListTrash returns the trashed records of one type, or of every type when
+// typ is empty, most recently deleted first
+func ListTrash(db *gorm.DB, typ string) ([]TrashItem, error) {
+	types := TrashTypes
+	if typ != "" {
+		if !IsTrashType(typ) {
+			return nil, ErrUnknownTrashType
+		}
+		types = []string{typ}
+	}
+
+	unscoped := db.Unscoped().Session(&gorm.Session{})
+	items := []TrashItem{}
+	for _, name := range types {
+		listed, err := trashTypes[name].list(unscoped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list trashed %s: %w", name, err)
+		}
+		items = append(items, listed...)
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].DeletedAt.After(items[j].DeletedAt)
+	})
+	return items, nil
+}
+
+// MoveToTrash soft deletes a record together with the rows it owns. Every
+// row gets the same deletion time, which is how RestoreFromTrash finds the
+// rows that were trashed with the record.
+func MoveToTrash(db *gorm.DB, typ string, id uint) error {
+	t, ok := trashTypes[typ]
+	if !ok {
+		return ErrUnknownTrashType
+	}
+
+	// Second precision so the timestamp compares equal on every engine
+	now := time.Now().UTC().Truncate(time.Second)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, d := range t.dependents {
+			if err := tx.Model(d.model).Where(d.where(tx, id)).UpdateColumn("deleted_at", now).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Model(t.model).Where("id = ?", id).UpdateColumn("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// RestoreFromTrash undeletes a record and the rows that were trashed with it.
+// Rows deleted separately before the record stay in the trash.
+func RestoreFromTrash(db *gorm.DB, typ string, id uint) error {
+	t, ok := trashTypes[typ]
+	if !ok {
+		return ErrUnknownTrashType
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		deletedAt, err := trashedAt(tx, t, id)
+		if err != nil {
+			return err
+		}
+		if t.canRestore != nil {
+			if err := t.canRestore(tx, id); err != nil {
+				return err
+			}
+		}
+
+		for _, d := range t.dependents {
+			err := tx.Unscoped().Model(d.model).Where(d.where(tx, id)).
+				Where("deleted_at = ?", deletedAt).UpdateColumn("deleted_at", nil).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Model(t.model).Where("id = ?", id).UpdateColumn("deleted_at", nil).Error
+	})
+}
+
+// PurgeFromTrash permanently deletes a trashed record and everything it owns
+func PurgeFromTrash(db *gorm.DB, typ string, id uint) error {
+	t, ok := trashTypes[typ]
+	if !ok {
+		return ErrUnknownTrashType
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := trashedAt(tx, t, id); err != nil {
+			return err
+		}
+		if t.canPurge != nil {
+			if err := t.canPurge(tx, id); err != nil {
+				return err
+			}
+		}
+
+		for _, r := range t.references {
+			if err := tx.Unscoped().Model(r.model).Where(r.column+" = ?", id).UpdateColumn(r.column, nil).Error; err != nil {
+				return err
+			}
+		}
+		for _, d := range append(t.owned, t.dependents...) {
+			if err := tx.Unscoped().Where(d.where(tx, id)).Delete(d.model).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Unscoped().Where("id = ?", id).Delete(t.model).Error
+	})
+}
+
+// PurgeTrash permanently deletes records of one type, or of every type when
+// typ is empty, that were trashed before the cutoff. Records that are still
+// referenced are skipped. It returns the number of records purged.
+func PurgeTrash(db *gorm.DB, typ string, before time.Time) (int, error) {
+	types := TrashTypes
+	if typ != "" {
+		if !IsTrashType(typ) {
+			return 0, ErrUnknownTrashType
+		}
+		types = []string{typ}
+	}
+
+	purged := 0
+	for _, name := range types {
+		var ids []uint
+		err := db.Unscoped().Model(trashTypes[name].model).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return purged, fmt.Errorf("failed to find trashed %s: %w", name, err)
+		}
+
+		for _, id := range ids {
+			err := PurgeFromTrash(db, name, id)
+			switch {
+			case err == nil:
+				purged++
+			case errors.Is(err, ErrStillReferenced), errors.Is(err, ErrNotInTrash):
+				// Purged along with its parent, or kept until its samples are gone
+			default:
+				return purged, fmt.Errorf("failed to purge %s %d: %w", name, id, err)
+			}
+		}
+	}
+	return purged, nil
+}
+
+// StartTrashRetention periodically purges records that have been in the
+// trash for longer than the retention period. A period of zero or less keeps
+// trashed records until they are purged by hand.
+func StartTrashRetention(db *gorm.DB, days int) {
+	if days <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			cutoff := time.Now().UTC().AddDate(0, 0, -days)
+			purged, err := PurgeTrash(db, "", cutoff)
+			if err != nil {
+				log.Printf("Trash retention failed: %v", err)
+			} else if purged > 0 {
+				log.Printf("Trash retention purged %d records deleted more than %d days ago", purged, days)
+			}
+			time.Sleep(trashRetentionInterval)
+		}
+	}()
+}
+
+// trashedAt returns the deletion time of a trashed record
+func trashedAt(db *gorm.DB, t trashType, id uint) (time.Time, error) {
+	var deletedAt []time.Time
+	err := db.Unscoped().Model(t.model).Where("id = ? AND deleted_at IS NOT NULL", id).
+		Pluck("deleted_at", &deletedAt).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(deletedAt) == 0 {
+		return time.Time{}, ErrNotInTrash
+	}
+	return deletedAt[0], nil
+}
+
+func ensureUnreferenced(query *gorm.DB) error {
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrStillReferenced
+	}
+	return nil
+}
+
+func listTrashedPools(db *gorm.DB) ([]TrashItem, error) {
+	var pools []models.Pool
+	if err := db.Where("deleted_at IS NOT NULL").Find(&pools).Error; err != nil {
+		return nil, err
+	}
+	items := make([]TrashItem, len(pools))
+	for i, pool := range pools {
+		items[i] = TrashItem{Type: "pools", ID: pool.ID, Name: pool.Name, DeletedAt: pool.DeletedAt.Time}
+	}
+	return items, nil
+}
+
+// listTrashedSamples skips samples whose pool is in the trash as well; they
+// come back when the pool is restored
+func listTrashedSamples(db *gorm.DB) ([]TrashItem, error) {
+	var samples []models.Sample
+	err := db.Preload("Pool").Where("deleted_at IS NOT NULL").
+		Where("pool_id NOT IN (?)", db.Model(&models.Pool{}).Select("id").Where("deleted_at IS NOT NULL")).
+		Find(&samples).Error
+	if err != nil {
+		return nil, err
+	}
+	items := make([]TrashItem, len(samples))
+	for i, sample := range samples {
+		name := sample.SampleDateTime.UTC().Format("2006-01-02 15:04")
+		if sample.Pool != nil {
+			name = sample.Pool.Name + " " + name
+		}
+		items[i] = TrashItem{Type: "samples", ID: sample.ID, Name: name, DeletedAt: sample.DeletedAt.Time}
+	}
+	return items, nil
+}
+
+func listTrashedKits(db *gorm.DB) ([]TrashItem, error) {
+	var kits []models.Kit
+	if err := db.Where("deleted_at IS NOT NULL").Find(&kits).Error; err != nil {
+		return nil, err
+	}
+	items := make([]TrashItem, len(kits))
+	for i, kit := range kits {
+		items[i] = TrashItem{Type: "kits", ID: kit.ID, Name: kit.Name, DeletedAt: kit.DeletedAt.Time}
+	}
+	return items, nil
+}
+
+func listTrashedUsers(db *gorm.DB) ([]TrashItem, error) {
+	var users []models.User
+	if err := db.Where("deleted_at IS NOT NULL").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	items := make([]TrashItem, len(users))
+	for i, user := range users {
+		items[i] = TrashItem{Type: "users", ID: user.ID, Name: user.Username, DeletedAt: user.DeletedAt.Time}
+	}
+	return items, nil
+}
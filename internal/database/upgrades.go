@@ -0,0 +1,150 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/config"
+	"waterlogger/internal/models"
+)
+
+// schemaUpgrade is a one-time change to an existing database that
+// AutoMigrate cannot make on its own
+type schemaUpgrade struct {
+	version string
+	apply   func(db *gorm.DB, cfg *config.Config) error
+}
+
+// schemaUpgrades run in order, each at most once per database
+var schemaUpgrades = []schemaUpgrade{
+	{"0001_foreign_key_actions", upgradeForeignKeyActions},
+}
+
+// runSchemaUpgrades applies pending schema upgrades. A freshly created
+// database already has the current schema, so its upgrades are only recorded.
+func runSchemaUpgrades(db *gorm.DB, cfg *config.Config, fresh bool) error {
+	for _, upgrade := range schemaUpgrades {
+		var count int64
+		if err := db.Model(&models.SchemaMigration{}).Where("version = ?", upgrade.version).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check schema upgrade %s: %w", upgrade.version, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		if !fresh {
+			log.Printf("Applying schema upgrade %s", upgrade.version)
+			if err := upgrade.apply(db, cfg); err != nil {
+				return fmt.Errorf("schema upgrade %s failed: %w", upgrade.version, err)
+			}
+		}
+
+		record := models.SchemaMigration{Version: upgrade.version, AppliedAt: time.Now()}
+		if err := db.Create(&record).Error; err != nil {
+			return fmt.Errorf("failed to record schema upgrade %s: %w", upgrade.version, err)
+		}
+	}
+	return nil
+}
+
+// backupBeforeUpgrade writes a backup of the whole database before an
+// upgrade removes rows, next to the SQLite database or in the working
+// directory, and returns its path. It follows the backup section of the
+// configuration. Encrypted backups need backup.passphrase, as nobody may be
+// there to type one; without it no backup is written.
+func backupBeforeUpgrade(db *gorm.DB, cfg *config.Config, version string) (string, error) {
+	if cfg.Backup.Encrypt && cfg.Backup.Passphrase == "" {
+		return "", errors.New("backups are encrypted but backup.passphrase is not set; set it to back up the database before the upgrade")
+	}
+	dir := "."
+	if cfg.Database.Type == "sqlite" {
+		dir = filepath.Dir(cfg.Database.SQLite.Path)
+	}
+	opts := ArchiveOptionsFromConfig(cfg.Backup)
+	name := fmt.Sprintf("waterlogger-before-%s-%s%s", version, time.Now().Format("20060102-150405"), opts.Extension())
+	path := filepath.Join(dir, name)
+	if err := ExportData(db, path, db.Dialector.Name(), opts); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// upgradeForeignKeyActions removes rows orphaned by earlier pool and sample
+// deletes, then recreates the pool, kit and sample foreign keys so they
+// carry their ON DELETE actions. AutoMigrate only creates missing
+// constraints and never alters existing ones. The orphans are counted and
+// logged first, and the database is backed up before any are removed.
+func upgradeForeignKeyActions(db *gorm.DB, cfg *config.Config) error {
+	// Trashed rows are real rows as far as the foreign keys are concerned
+	db = db.Unscoped().Session(&gorm.Session{})
+
+	orphanedSamples := db.Model(&models.Sample{}).Select("id").
+		Where("pool_id NOT IN (?)", db.Model(&models.Pool{}).Select("id"))
+
+	cleanups := []struct {
+		name  string
+		model interface{}
+		where *gorm.DB
+	}{
+		{"measurements of samples without a pool", &models.Measurements{}, db.Where("sample_id IN (?)", orphanedSamples)},
+		{"indices of samples without a pool", &models.Indices{}, db.Where("sample_id IN (?)", orphanedSamples)},
+		{"samples without a pool", &models.Sample{}, db.Where("pool_id NOT IN (?)", db.Model(&models.Pool{}).Select("id"))},
+		{"measurements without a sample", &models.Measurements{}, db.Where("sample_id NOT IN (?)", db.Model(&models.Sample{}).Select("id"))},
+		{"indices without a sample", &models.Indices{}, db.Where("sample_id NOT IN (?)", db.Model(&models.Sample{}).Select("id"))},
+	}
+	var orphans int64
+	for _, cleanup := range cleanups {
+		var count int64
+		if err := db.Model(cleanup.model).Where(cleanup.where).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count orphaned %s: %w", cleanup.name, err)
+		}
+		if count > 0 {
+			log.Printf("Found %d orphaned %s", count, cleanup.name)
+		}
+		orphans += count
+	}
+	if orphans > 0 {
+		path, err := backupBeforeUpgrade(db, cfg, "0001_foreign_key_actions")
+		if err != nil {
+			return fmt.Errorf("not removing %d orphaned rows as the backup failed: %w", orphans, err)
+		}
+		log.Printf("Backed up the database to %s before removing %d orphaned rows", path, orphans)
+	}
+
+	for _, cleanup := range cleanups {
+		result := db.Where(cleanup.where).Delete(cleanup.model)
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove orphaned %s: %w", cleanup.name, result.Error)
+		}
+		if result.RowsAffected > 0 {
+			log.Printf("Removed %d orphaned %s", result.RowsAffected, cleanup.name)
+		}
+	}
+
+	constraints := []struct {
+		model interface{}
+		name  string
+	}{
+		{&models.Pool{}, "Samples"},
+		{&models.Kit{}, "Samples"},
+		{&models.Sample{}, "Measurements"},
+		{&models.Sample{}, "Indices"},
+	}
+	migrator := db.Migrator()
+	for _, c := range constraints {
+		if migrator.HasConstraint(c.model, c.name) {
+			if err := migrator.DropConstraint(c.model, c.name); err != nil {
+				return fmt.Errorf("failed to drop constraint %s: %w", c.name, err)
+			}
+		}
+		if err := migrator.CreateConstraint(c.model, c.name); err != nil {
+			return fmt.Errorf("failed to create constraint %s: %w", c.name, err)
+		}
+	}
+
+	return nil
+}
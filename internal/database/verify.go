@@ -0,0 +1,190 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TableReport summarizes the verification of a single migrated table
+type TableReport struct {
+	Table          string `json:"table"`
+	SourceRows     int64  `json:"source_rows"`
+	TargetRows     int64  `json:"target_rows"`
+	SourceChecksum string `json:"source_checksum"`
+	TargetChecksum string `json:"target_checksum"`
+}
+
+// OK reports whether the row counts and checksums match
+func (t TableReport) OK() bool {
+	return t.SourceRows == t.TargetRows && t.SourceChecksum == t.TargetChecksum
+}
+
+// MigrationReport summarizes a database migration table by table
+type MigrationReport struct {
+	Source string        `json:"source"`
+	Target string        `json:"target"`
+	Tables []TableReport `json:"tables"`
+}
+
+// OK reports whether every table was verified successfully
+func (r *MigrationReport) OK() bool {
+	for _, t := range r.Tables {
+		if !t.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// String renders the report as a plain-text table
+func (r *MigrationReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Migration report (%s -> %s)\n", r.Source, r.Target)
+	fmt.Fprintf(&sb, "%-20s %12s %12s  %-12s %-12s %s\n", "Table", "Source rows", "Target rows", "Source sum", "Target sum", "Status")
+	for _, t := range r.Tables {
+		status := "OK"
+		if !t.OK() {
+			status = "MISMATCH"
+		}
+		fmt.Fprintf(&sb, "%-20s %12d %12d  %-12s %-12s %s\n",
+			t.Table, t.SourceRows, t.TargetRows, shortChecksum(t.SourceChecksum), shortChecksum(t.TargetChecksum), status)
+	}
+	return sb.String()
+}
+
+func shortChecksum(sum string) string {
+	if len(sum) > 12 {
+		return sum[:12]
+	}
+	return sum
+}
+
+// tableChecksum counts the rows of a table and hashes their canonical form.
+// Rows are read in primary key order so that the same data produces the
+// same checksum on every database engine.
+func tableChecksum(db *gorm.DB, t migrationTable) (int64, string, error) {
+	h := sha256.New()
+	var count int64
+
+	rows := t.rows()
+	err := db.Unscoped().Model(t.model).FindInBatches(rows, migrationBatchSize, func(tx *gorm.DB, batch int) error {
+		slice := reflect.ValueOf(rows).Elem()
+		for i := 0; i < slice.Len(); i++ {
+			writeCanonicalRow(h, slice.Index(i))
+			count++
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return 0, "", err
+	}
+
+	return count, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeCanonicalRow writes the column values of a model struct in a form
+// that does not depend on how the database engine stores them. Relationship
+// fields are skipped, and times are compared in UTC at second precision
+// because MariaDB rounds fractional seconds.
+func writeCanonicalRow(h hash.Hash, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fv := v.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			writeCanonicalRow(h, fv)
+			continue
+		}
+
+		value, ok := canonicalValue(fv)
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(h, "%s=%s;", field.Name, value)
+	}
+	h.Write([]byte("\n"))
+}
+
+// canonicalValue formats a column value, returning false for relationship fields
+func canonicalValue(v reflect.Value) (string, bool) {
+	if tv, ok := v.Interface().(time.Time); ok {
+		return canonicalTime(tv), true
+	}
+
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil || value == nil {
+			return "NULL", true
+		}
+		return canonicalValue(reflect.ValueOf(value))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.Type().Elem().Kind() == reflect.Struct && v.Type().Elem() != reflect.TypeOf(time.Time{}) {
+			return "", false
+		}
+		if v.IsNil() {
+			return "NULL", true
+		}
+		return canonicalValue(v.Elem())
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		return "", false
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.String:
+		return strconv.Quote(v.String()), true
+	default:
+		return fmt.Sprint(v.Interface()), true
+	}
+}
+
+func canonicalTime(t time.Time) string {
+	return t.UTC().Round(time.Millisecond).Truncate(time.Second).Format(time.RFC3339)
+}
+
+// resetSequence moves a table's auto-increment sequence past the highest
+// preserved primary key so new rows do not collide with migrated ones
+func resetSequence(db *gorm.DB, table string) error {
+	var maxID int64
+	if err := db.Table(table).Select("COALESCE(MAX(id), 0)").Scan(&maxID).Error; err != nil {
+		return fmt.Errorf("failed to read max id of %s: %v", table, err)
+	}
+
+	switch db.Dialector.Name() {
+	case "mysql":
+		// ALTER TABLE commits implicitly, so this must run outside the copy transaction
+		return db.Exec(fmt.Sprintf("ALTER TABLE `%s` AUTO_INCREMENT = %d", table, maxID+1)).Error
+	case "sqlite":
+		// sqlite_sequence only exists once an AUTOINCREMENT table has been written
+		var exists int64
+		if err := db.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'sqlite_sequence'").Scan(&exists).Error; err != nil {
+			return err
+		}
+		if exists == 0 {
+			return nil
+		}
+		return db.Exec("UPDATE sqlite_sequence SET seq = ? WHERE name = ?", maxID, table).Error
+	case "postgres":
+		// setval with is_called=false makes an empty table start again at 1
+		if maxID == 0 {
+			return db.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), 1, false)", table).Error
+		}
+		return db.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), ?)", table, maxID).Error
+	}
+
+	return nil
+}
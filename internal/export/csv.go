@@ -0,0 +1,223 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// CSVContentType is the MIME type of CSV exports
+const CSVContentType = "text/csv; charset=utf-8"
+
+// samplePageSize is the number of samples the streaming exports read from
+// the database at a time
+const samplePageSize = 500
+
+// csvDelimiters maps the named delimiters accepted by ParseCSVOptions
+var csvDelimiters = map[string]rune{
+	"comma":     ',',
+	"semicolon": ';',
+	"tab":       '\t',
+	"pipe":      '|',
+}
+
+// CSVOptions controls the CSV dialect
+type CSVOptions struct {
+	// Delimiter separates fields; zero means a comma
+	Delimiter rune
+	// DecimalComma writes 7,4 instead of 7.4
+	DecimalComma bool
+}
+
+// CSVFilename returns the export file name for a time, e.g. WL20240714_143022.csv
+func CSVFilename(t time.Time) string {
+	return fmt.Sprintf("WL%s.csv", t.Format("20060102_150405"))
+}
+
+// ParseCSVOptions parses the CSV dialect from query parameters:
+//
+//	delimiter  comma, semicolon, tab, pipe or a single character
+//	decimal    point (the default) or comma
+//
+// A decimal comma defaults the delimiter to a semicolon.
+func ParseCSVOptions(values url.Values) (CSVOptions, error) {
+	var opts CSVOptions
+
+	switch decimal := strings.ToLower(values.Get("decimal")); decimal {
+	case "", "point":
+	case "comma":
+		opts.DecimalComma = true
+		opts.Delimiter = ';'
+	default:
+		return CSVOptions{}, fmt.Errorf("invalid decimal: %s", decimal)
+	}
+
+	if delimiter := values.Get("delimiter"); delimiter != "" {
+		r, ok := csvDelimiters[strings.ToLower(delimiter)]
+		if !ok {
+			if utf8.RuneCountInString(delimiter) != 1 {
+				return CSVOptions{}, fmt.Errorf("invalid delimiter: %s", delimiter)
+			}
+			r, _ = utf8.DecodeRuneInString(delimiter)
+		}
+		opts.Delimiter = r
+	}
+
+	if opts.DecimalComma && opts.Delimiter == ',' {
+		return CSVOptions{}, fmt.Errorf("a comma delimiter cannot be used with decimal commas")
+	}
+
+	// Reject delimiters encoding/csv cannot write unambiguously
+	if opts.Delimiter != 0 {
+		w := csv.NewWriter(io.Discard)
+		w.Comma = opts.Delimiter
+		if err := w.Write(nil); err != nil {
+			return CSVOptions{}, fmt.Errorf("invalid delimiter: %q", opts.Delimiter)
+		}
+	}
+
+	return opts, nil
+}
+
+// WriteCSV streams one row per sample selected by the filter, with the
+// pool, user and kit, every measurement and index field and the additions.
+// Samples are read a page at a time and each page is flushed to w, calling
+// w.Flush when w has one. Nothing is written before the first page is read,
+// so a database error at the start can still be reported by the caller.
+func WriteCSV(db *gorm.DB, w io.Writer, f Filter, opts CSVOptions) error {
+	cw := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		cw.Comma = opts.Delimiter
+	}
+	flusher, _ := w.(interface{ Flush() })
+
+	measurements := f.MeasurementParameters()
+	indices := f.IndexParameters()
+
+	header := []string{"Sample ID", "Sample Date (UTC)", "Pool ID", "Pool", "User ID", "User", "Kit ID", "Kit"}
+	for _, p := range measurements {
+		header = append(header, f.Label(p))
+	}
+	header = append(header, "Appearance", "Maintenance")
+	for _, p := range indices {
+		header = append(header, f.Label(p))
+	}
+	header = append(header, "Index Comment", "Additions", "Notes")
+
+	for offset := 0; ; offset += samplePageSize {
+		var samples []models.Sample
+		err := db.Preload("Pool").Preload("User").Preload("Kit").
+			Preload("Measurements").Preload("Indices").Preload("Additions", orderByID).
+			Scopes(f.Samples).Limit(samplePageSize).Offset(offset).Find(&samples).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch samples: %w", err)
+		}
+
+		if offset == 0 {
+			if err := cw.Write(header); err != nil {
+				return err
+			}
+		}
+
+		for i := range samples {
+			s := &samples[i]
+			row := []string{
+				formatID(s.ID), s.SampleDateTime.UTC().Format("2006-01-02 15:04:05"),
+				formatID(s.PoolID), poolName(*s),
+				formatID(s.UserID), userName(*s),
+				optionalID(s.KitID), kitName(*s),
+			}
+			for _, p := range measurements {
+				row = append(row, opts.parameter(s, p, f))
+			}
+			var appearance, maintenance *string
+			if s.Measurements != nil {
+				appearance, maintenance = s.Measurements.Appearance, s.Measurements.Maintenance
+			}
+			row = append(row, optionalString(appearance), optionalString(maintenance))
+			for _, p := range indices {
+				row = append(row, opts.parameter(s, p, f))
+			}
+			var comment *string
+			if s.Indices != nil {
+				comment = s.Indices.Comment
+			}
+			row = append(row, optionalString(comment), opts.additions(s.Additions), s.Notes)
+
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(samples) < samplePageSize {
+			return nil
+		}
+	}
+}
+
+// parameter formats a parameter in the filter's unit system, or returns an
+// empty field when it was not recorded
+func (opts CSVOptions) parameter(s *models.Sample, parameter string, f Filter) string {
+	value, ok := s.ParameterValue(parameter)
+	if !ok {
+		return ""
+	}
+	return opts.number(f.Value(parameter, value))
+}
+
+func (opts CSVOptions) number(value float64) string {
+	formatted := strconv.FormatFloat(value, 'f', -1, 64)
+	if opts.DecimalComma {
+		formatted = strings.Replace(formatted, ".", ",", 1)
+	}
+	return formatted
+}
+
+// additions joins the additions of a sample into one field, e.g.
+// "Liquid chlorine 32 fl oz; Muriatic acid 8 fl oz"
+func (opts CSVOptions) additions(additions []models.Addition) string {
+	parts := make([]string, 0, len(additions))
+	for _, a := range additions {
+		part := a.Chemical + " " + opts.number(a.Amount)
+		if a.Unit != "" {
+			part += " " + a.Unit
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+func formatID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// optionalID formats an ID, leaving the field empty for 0
+func optionalID(id uint) string {
+	if id == 0 {
+		return ""
+	}
+	return formatID(id)
+}
+
+func optionalString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// ExcelContentType is the MIME type of XLSX workbooks
+const ExcelContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// measurementParameters are the numeric measurement columns, in display order
+var measurementParameters = []string{"fc", "tc", "ph", "ta", "ch", "cya", "temperature", "salinity", "tds", "orp"}
+
+// ExcelFilename returns the export file name for a time, e.g. WL20240714_143022.xlsx
+func ExcelFilename(t time.Time) string {
+	return fmt.Sprintf("WL%s.xlsx", t.Format("20060102_150405"))
+}
+
+// WriteExcel writes a workbook with one worksheet per entity: users, pools,
+// kits, samples, measurements, indices and additions. The filter selects the
+// pools, samples and parameter columns, the unit system and the sort order.
+func WriteExcel(db *gorm.DB, w io.Writer, f Filter) error {
+	var users []models.User
+	if err := db.Order("id ASC").Find(&users).Error; err != nil {
+		return fmt.Errorf("failed to fetch users: %w", err)
+	}
+	var pools []models.Pool
+	if err := db.Scopes(f.Pools).Find(&pools).Error; err != nil {
+		return fmt.Errorf("failed to fetch pools: %w", err)
+	}
+	var kits []models.Kit
+	if err := db.Order("id ASC").Find(&kits).Error; err != nil {
+		return fmt.Errorf("failed to fetch kits: %w", err)
+	}
+	var samples []models.Sample
+	err := db.Preload("Pool").Preload("User").Preload("Kit").
+		Preload("Measurements").Preload("Indices").Preload("Additions", orderByID).
+		Scopes(f.Samples).Find(&samples).Error
+	if err != nil {
+		return fmt.Errorf("failed to fetch samples: %w", err)
+	}
+
+	wb := NewWorkbook()
+	addUsersSheet(wb, users)
+	addPoolsSheet(wb, pools, f)
+	addKitsSheet(wb, kits)
+	addSamplesSheet(wb, samples)
+	addMeasurementsSheet(wb, samples, f)
+	addIndicesSheet(wb, samples, f)
+	addAdditionsSheet(wb, samples)
+
+	return wb.Write(w)
+}
+
+func orderByID(db *gorm.DB) *gorm.DB {
+	return db.Order("id ASC")
+}
+
+func addUsersSheet(wb *Workbook, users []models.User) {
+	sheet := wb.AddSheet("Users", "ID", "Username", "Email", "Administrator", "Created (UTC)", "Updated (UTC)")
+	for _, u := range users {
+		admin := "No"
+		if u.IsAdmin {
+			admin = "Yes"
+		}
+		sheet.AddRow(Number(float64(u.ID)), Text(u.Username), Text(u.Email), Text(admin),
+			DateTime(u.CreatedAt), DateTime(u.UpdatedAt))
+	}
+}
+
+func addPoolsSheet(wb *Workbook, pools []models.Pool, f Filter) {
+	sheet := wb.AddSheet("Pools", "ID", "Name", "Type", fmt.Sprintf("Volume (%s)", f.Unit("volume")), "System Description",
+		"Created (UTC)", "Updated (UTC)", "Created By", "Updated By")
+	for _, p := range pools {
+		volume := Empty()
+		if p.VolumeGallons != nil {
+			volume = Number(f.Value("volume", *p.VolumeGallons))
+		}
+		sheet.AddRow(Number(float64(p.ID)), Text(p.Name), Text(p.Type), volume,
+			OptionalText(p.SystemDescription), DateTime(p.CreatedAt), DateTime(p.UpdatedAt),
+			Number(float64(p.CreatedBy)), Number(float64(p.UpdatedBy)))
+	}
+}
+
+func addKitsSheet(wb *Workbook, kits []models.Kit) {
+	sheet := wb.AddSheet("Kits", "ID", "Name", "Description", "Purchased", "Replenished",
+		"Created (UTC)", "Updated (UTC)", "Created By", "Updated By")
+	for _, k := range kits {
+		sheet.AddRow(Number(float64(k.ID)), Text(k.Name), OptionalText(k.Description),
+			Date(k.PurchasedDate), Date(k.ReplenishedDate), DateTime(k.CreatedAt), DateTime(k.UpdatedAt),
+			Number(float64(k.CreatedBy)), Number(float64(k.UpdatedBy)))
+	}
+}
+
+func addSamplesSheet(wb *Workbook, samples []models.Sample) {
+	sheet := wb.AddSheet("Samples", "ID", "Sample Date (UTC)", "Pool ID", "Pool", "User ID", "User",
+		"Kit ID", "Kit", "Notes", "Created (UTC)", "Updated (UTC)")
+	for _, s := range samples {
+		sheet.AddRow(Number(float64(s.ID)), DateTime(s.SampleDateTime),
+			Number(float64(s.PoolID)), Text(poolName(s)),
+			Number(float64(s.UserID)), Text(userName(s)),
+			Number(float64(s.KitID)), Text(kitName(s)),
+			Text(s.Notes), DateTime(s.CreatedAt), DateTime(s.UpdatedAt))
+	}
+}
+
+func addMeasurementsSheet(wb *Workbook, samples []models.Sample, f Filter) {
+	parameters := f.MeasurementParameters()
+	headers := []string{"ID", "Sample ID", "Sample Date (UTC)", "Pool"}
+	for _, p := range parameters {
+		headers = append(headers, f.Label(p))
+	}
+	headers = append(headers, "Appearance", "Maintenance")
+
+	sheet := wb.AddSheet("Measurements", headers...)
+	for i := range samples {
+		s := &samples[i]
+		m := s.Measurements
+		if m == nil {
+			continue
+		}
+		row := []Cell{Number(float64(m.ID)), Number(float64(s.ID)), DateTime(s.SampleDateTime), Text(poolName(*s))}
+		for _, p := range parameters {
+			row = append(row, parameterCell(s, p, f))
+		}
+		row = append(row, OptionalText(m.Appearance), OptionalText(m.Maintenance))
+		sheet.AddRow(row...)
+	}
+}
+
+func addIndicesSheet(wb *Workbook, samples []models.Sample, f Filter) {
+	parameters := f.IndexParameters()
+	headers := []string{"ID", "Sample ID", "Sample Date (UTC)", "Pool"}
+	for _, p := range parameters {
+		headers = append(headers, f.Label(p))
+	}
+	headers = append(headers, "Comment")
+
+	sheet := wb.AddSheet("Indices", headers...)
+	for i := range samples {
+		s := &samples[i]
+		idx := s.Indices
+		if idx == nil {
+			continue
+		}
+		row := []Cell{Number(float64(idx.ID)), Number(float64(s.ID)), DateTime(s.SampleDateTime), Text(poolName(*s))}
+		for _, p := range parameters {
+			row = append(row, parameterCell(s, p, f))
+		}
+		row = append(row, OptionalText(idx.Comment))
+		sheet.AddRow(row...)
+	}
+}
+
+func addAdditionsSheet(wb *Workbook, samples []models.Sample) {
+	sheet := wb.AddSheet("Additions", "ID", "Sample ID", "Sample Date (UTC)", "Pool",
+		"Chemical", "Amount", "Unit", "Notes")
+	for _, s := range samples {
+		for _, a := range s.Additions {
+			sheet.AddRow(Number(float64(a.ID)), Number(float64(s.ID)), DateTime(s.SampleDateTime), Text(poolName(s)),
+				Text(a.Chemical), Number(a.Amount), Text(a.Unit), OptionalText(a.Notes))
+		}
+	}
+}
+
+// parameterCell returns a measured parameter as a number in the filter's
+// unit system, or a blank cell when it was not recorded
+func parameterCell(s *models.Sample, parameter string, f Filter) Cell {
+	if value, ok := s.ParameterValue(parameter); ok {
+		return Number(f.Value(parameter, value))
+	}
+	return Empty()
+}
+
+func poolName(s models.Sample) string {
+	if s.Pool != nil {
+		return s.Pool.Name
+	}
+	return ""
+}
+
+func userName(s models.Sample) string {
+	if s.User != nil {
+		return s.User.Username
+	}
+	return ""
+}
+
+func kitName(s models.Sample) string {
+	if s.Kit != nil {
+		return s.Kit.Name
+	}
+	return ""
+}
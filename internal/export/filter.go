@@ -0,0 +1,261 @@
+package export
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+)
+
+// filterParameters are the parameters that can be selected for export, in display order
+var filterParameters = append(append([]string{}, measurementParameters...), "lsi", "rsi")
+
+// Filter narrows and formats an export. The zero value exports everything,
+// in stored (imperial) units, oldest first.
+type Filter struct {
+	// PoolIDs limits the export to these pools; empty means all pools
+	PoolIDs []uint
+	// From is the earliest sample time to include; zero means no lower bound
+	From time.Time
+	// To is the latest sample time to include; zero means no upper bound
+	To time.Time
+	// Parameters limits the measurement and index columns; empty means all
+	Parameters []string
+	// Units is the unit system values are converted to; empty means imperial
+	Units chemistry.UnitSystem
+	// Descending sorts samples newest first instead of oldest first
+	Descending bool
+
+	// toWholeDay makes To include the whole day when only a date was given
+	toWholeDay bool
+}
+
+// ParseFilterQuery parses a filter from a URL query string such as
+// "pools=1,2&days=30&units=metric"
+func ParseFilterQuery(query string, now time.Time) (Filter, error) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return Filter{}, fmt.Errorf("invalid filter: %w", err)
+	}
+	return ParseFilter(values, now)
+}
+
+// ParseFilter parses a filter from query parameters:
+//
+//	pools       comma-separated pool IDs
+//	days        only samples from the last N days
+//	from, to    date (YYYY-MM-DD, whole day) or RFC 3339 timestamp bounds
+//	parameters  comma-separated parameters, e.g. fc,ph,lsi
+//	units       imperial or metric
+//	sort        asc (oldest first, the default) or desc
+func ParseFilter(values url.Values, now time.Time) (Filter, error) {
+	var f Filter
+
+	for _, field := range splitList(values.Get("pools")) {
+		id, err := strconv.ParseUint(field, 10, 32)
+		if err != nil || id == 0 {
+			return Filter{}, fmt.Errorf("invalid pool ID: %s", field)
+		}
+		f.PoolIDs = append(f.PoolIDs, uint(id))
+	}
+
+	if days := values.Get("days"); days != "" && days != "all" {
+		if values.Get("from") != "" {
+			return Filter{}, fmt.Errorf("days cannot be combined with from")
+		}
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return Filter{}, fmt.Errorf("invalid days: %s", days)
+		}
+		f.From = now.UTC().AddDate(0, 0, -n)
+	}
+
+	if from := values.Get("from"); from != "" {
+		t, _, err := parseDate(from)
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid from: %s", from)
+		}
+		f.From = t
+	}
+
+	if to := values.Get("to"); to != "" {
+		t, dateOnly, err := parseDate(to)
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid to: %s", to)
+		}
+		f.To, f.toWholeDay = t, dateOnly
+	}
+
+	if !f.From.IsZero() && !f.To.IsZero() && f.To.Before(f.From) {
+		return Filter{}, fmt.Errorf("to is before from")
+	}
+
+	for _, p := range splitList(values.Get("parameters")) {
+		p = strings.ToLower(p)
+		if !slices.Contains(filterParameters, p) {
+			return Filter{}, fmt.Errorf("unknown parameter: %s", p)
+		}
+		if !slices.Contains(f.Parameters, p) {
+			f.Parameters = append(f.Parameters, p)
+		}
+	}
+
+	switch units := chemistry.UnitSystem(strings.ToLower(values.Get("units"))); units {
+	case "", chemistry.Imperial, chemistry.Metric:
+		f.Units = units
+	default:
+		return Filter{}, fmt.Errorf("invalid units: %s", units)
+	}
+
+	switch strings.ToLower(values.Get("sort")) {
+	case "", "asc":
+	case "desc":
+		f.Descending = true
+	default:
+		return Filter{}, fmt.Errorf("invalid sort: %s", values.Get("sort"))
+	}
+
+	return f, nil
+}
+
+// Samples scopes a sample query to the filter's pools and dates and orders it.
+// Use it with db.Scopes.
+func (f Filter) Samples(db *gorm.DB) *gorm.DB {
+	if len(f.PoolIDs) > 0 {
+		db = db.Where("pool_id IN ?", f.PoolIDs)
+	}
+	if !f.From.IsZero() {
+		db = db.Where("sample_date_time >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		if f.toWholeDay {
+			db = db.Where("sample_date_time < ?", f.To.AddDate(0, 0, 1))
+		} else {
+			db = db.Where("sample_date_time <= ?", f.To)
+		}
+	}
+	direction := "ASC"
+	if f.Descending {
+		direction = "DESC"
+	}
+	return db.Order("sample_date_time " + direction).Order("id " + direction)
+}
+
+// Pools scopes a pool query to the filter's pools. Use it with db.Scopes.
+func (f Filter) Pools(db *gorm.DB) *gorm.DB {
+	if len(f.PoolIDs) > 0 {
+		db = db.Where("id IN ?", f.PoolIDs)
+	}
+	return db.Order("id ASC")
+}
+
+// Includes reports whether a measurement or index parameter is exported
+func (f Filter) Includes(parameter string) bool {
+	return len(f.Parameters) == 0 || slices.Contains(f.Parameters, parameter)
+}
+
+// MeasurementParameters returns the exported measurement parameters in display order
+func (f Filter) MeasurementParameters() []string {
+	return f.selected(measurementParameters)
+}
+
+// IndexParameters returns the exported index parameters in display order
+func (f Filter) IndexParameters() []string {
+	return f.selected([]string{"lsi", "rsi"})
+}
+
+func (f Filter) selected(parameters []string) []string {
+	var out []string
+	for _, p := range parameters {
+		if f.Includes(p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Value converts a stored value of a parameter to the filter's unit system.
+// Converted values are rounded to two decimals.
+func (f Filter) Value(parameter string, value float64) float64 {
+	if f.Units != chemistry.Metric {
+		return value
+	}
+	switch parameter {
+	case "temperature", "volume":
+		converted := chemistry.ConvertMeasurement(value, parameter, chemistry.Imperial).Converted
+		return math.Round(converted*100) / 100
+	}
+	return value
+}
+
+// Unit returns the unit of a parameter in the filter's unit system
+func (f Filter) Unit(parameter string) string {
+	if f.Units != chemistry.Metric {
+		return chemistry.ParameterUnit(parameter)
+	}
+	switch parameter {
+	case "temperature", "volume":
+		return chemistry.ConvertMeasurement(0, parameter, chemistry.Imperial).ConvertedUnit
+	}
+	return chemistry.ParameterUnit(parameter)
+}
+
+// Label returns the display name of a parameter followed by its unit in the
+// filter's unit system, e.g. "Temperature (°C)"
+func (f Filter) Label(parameter string) string {
+	name, ok := chemistry.GetParameterNames()[parameter]
+	if !ok {
+		name = parameter
+	}
+	if unit := f.Unit(parameter); unit != "" {
+		return fmt.Sprintf("%s (%s)", name, unit)
+	}
+	return name
+}
+
+// describe summarizes the filter for the report introduction
+func (f Filter) describe() string {
+	var parts []string
+	switch {
+	case !f.From.IsZero() && !f.To.IsZero():
+		parts = append(parts, fmt.Sprintf("Samples from %s to %s", f.From.Format("2006-01-02"), f.To.Format("2006-01-02")))
+	case !f.From.IsZero():
+		parts = append(parts, fmt.Sprintf("Samples from %s", f.From.Format("2006-01-02")))
+	case !f.To.IsZero():
+		parts = append(parts, fmt.Sprintf("Samples up to %s", f.To.Format("2006-01-02")))
+	default:
+		parts = append(parts, "All samples")
+	}
+	units := chemistry.Imperial
+	if f.Units == chemistry.Metric {
+		units = chemistry.Metric
+	}
+	parts = append(parts, fmt.Sprintf("%s units", units))
+	return strings.Join(parts, ", ") + "."
+}
+
+// parseDate parses a date (YYYY-MM-DD) or RFC 3339 timestamp as UTC,
+// reporting whether only a date was given
+func parseDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t.UTC(), false, err
+}
+
+func splitList(value string) []string {
+	var out []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			out = append(out, field)
+		}
+	}
+	return out
+}
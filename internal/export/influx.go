@@ -0,0 +1,116 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// LineProtocolContentType is the MIME type of InfluxDB line protocol exports
+const LineProtocolContentType = "text/plain; charset=utf-8"
+
+// InfluxDB measurement names of the line protocol export
+const (
+	MeasurementsMeasurement = "waterlogger_measurements"
+	IndicesMeasurement      = "waterlogger_indices"
+)
+
+// LineProtocolFilename returns the export file name for a time, e.g. WL20240714_143022.lp
+func LineProtocolFilename(t time.Time) string {
+	return fmt.Sprintf("WL%s.lp", t.Format("20060102_150405"))
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	tagEscaper         = strings.NewReplacer(`,`, `\,`, `=`, `\=`, ` `, `\ `)
+)
+
+// WriteLineProtocol streams the samples selected by the filter as InfluxDB
+// line protocol, a page at a time like WriteCSV. Pages are flushed by
+// calling w.Flush when w has one.
+func WriteLineProtocol(db *gorm.DB, w io.Writer, f Filter) error {
+	flusher, _ := w.(interface{ Flush() })
+
+	for offset := 0; ; offset += samplePageSize {
+		var samples []models.Sample
+		err := db.Preload("Pool").Preload("Kit").Preload("Measurements").Preload("Indices").
+			Scopes(f.Samples).Limit(samplePageSize).Offset(offset).Find(&samples).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch samples: %w", err)
+		}
+
+		var page []byte
+		for i := range samples {
+			page = AppendLineProtocol(page, &samples[i], f)
+		}
+		if _, err := w.Write(page); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(samples) < samplePageSize {
+			return nil
+		}
+	}
+}
+
+// AppendLineProtocol appends the lines of a sample to b: one for its
+// measurements and one for its indices, each tagged with the pool, pool
+// type and kit and holding the recorded parameters selected by the filter
+// as fields. Timestamps are in seconds, so points must be written with
+// precision=s. Records without any recorded parameter are left out.
+func AppendLineProtocol(b []byte, s *models.Sample, f Filter) []byte {
+	var tags []byte
+	if s.Kit != nil && s.Kit.Name != "" {
+		tags = append(tags, ",kit="+tagEscaper.Replace(s.Kit.Name)...)
+	}
+	if s.Pool != nil {
+		if s.Pool.Name != "" {
+			tags = append(tags, ",pool="+tagEscaper.Replace(s.Pool.Name)...)
+		}
+		if s.Pool.Type != "" {
+			tags = append(tags, ",pool_type="+tagEscaper.Replace(s.Pool.Type)...)
+		}
+	}
+	timestamp := strconv.FormatInt(s.SampleDateTime.Unix(), 10)
+
+	line := func(measurement string, parameters []string) {
+		var fields []byte
+		for _, p := range parameters {
+			value, ok := s.ParameterValue(p)
+			if !ok {
+				continue
+			}
+			fields = append(fields, ',')
+			fields = append(fields, tagEscaper.Replace(p)+"="...)
+			fields = strconv.AppendFloat(fields, f.Value(p, value), 'f', -1, 64)
+		}
+		if len(fields) == 0 {
+			return
+		}
+		b = append(b, measurementEscaper.Replace(measurement)...)
+		b = append(b, tags...)
+		b = append(b, ' ')
+		b = append(b, fields[1:]...)
+		b = append(b, ",sample_id="...)
+		b = strconv.AppendUint(b, uint64(s.ID), 10)
+		b = append(b, 'i', ' ')
+		b = append(b, timestamp...)
+		b = append(b, '\n')
+	}
+
+	if s.Measurements != nil {
+		line(MeasurementsMeasurement, f.MeasurementParameters())
+	}
+	if s.Indices != nil {
+		line(IndicesMeasurement, f.IndexParameters())
+	}
+	return b
+}
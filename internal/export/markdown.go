@@ -0,0 +1,481 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// AppendicesDir holds Markdown files appended to every Markdown export
+const AppendicesDir = "appendices"
+
+// MarkdownContentType is the MIME type of Markdown exports
+const MarkdownContentType = "text/markdown; charset=utf-8"
+
+// MarkdownFilename returns the export file name for a time, e.g. WL20240714_143022.md
+func MarkdownFilename(t time.Time) string {
+	return fmt.Sprintf("WL%s.md", t.Format("20060102_150405"))
+}
+
+// WriteMarkdown writes a Markdown report with a table of contents, sections
+// for pools and kits, the samples selected by the filter grouped by pool
+// with summary statistics and data tables, and the appendices
+func WriteMarkdown(db *gorm.DB, w io.Writer, f Filter) error {
+	var pools []models.Pool
+	if err := db.Scopes(f.Pools).Find(&pools).Error; err != nil {
+		return fmt.Errorf("failed to fetch pools: %w", err)
+	}
+	var kits []models.Kit
+	if err := db.Order("name ASC").Order("id ASC").Find(&kits).Error; err != nil {
+		return fmt.Errorf("failed to fetch kits: %w", err)
+	}
+	var samples []models.Sample
+	if err := db.Preload("Kit").Preload("Measurements").Preload("Indices").Preload("Additions", orderByID).
+		Scopes(f.Samples).Find(&samples).Error; err != nil {
+		return fmt.Errorf("failed to fetch samples: %w", err)
+	}
+
+	byPool := make(map[uint][]*models.Sample)
+	for i := range samples {
+		byPool[samples[i].PoolID] = append(byPool[samples[i].PoolID], &samples[i])
+	}
+
+	r := &markdownReport{filter: f, slugs: make(map[string]int)}
+	r.slug("Waterlogger Report")
+	r.slug("Contents")
+
+	r.writePools(pools, byPool)
+	r.writeKits(kits)
+	r.writeSamples(pools, byPool, len(samples))
+	r.writeAppendices(AppendicesDir)
+
+	var md strings.Builder
+	md.WriteString("# Waterlogger Report\n\n")
+	fmt.Fprintf(&md, "Generated on %s UTC. %s\n\n", time.Now().UTC().Format("2006-01-02 15:04:05"), f.describe())
+	md.WriteString("## Contents\n\n")
+	for _, entry := range r.toc {
+		fmt.Fprintf(&md, "%s- [%s](#%s)\n", strings.Repeat("  ", entry.depth), escapeLinkText(entry.title), entry.anchor)
+	}
+	md.WriteString("\n")
+	md.WriteString(r.body.String())
+
+	_, err := io.WriteString(w, md.String())
+	return err
+}
+
+// markdownReport collects the report body and its table of contents
+type markdownReport struct {
+	filter Filter
+	body   strings.Builder
+	toc    []tocEntry
+	slugs  map[string]int
+}
+
+type tocEntry struct {
+	title  string
+	anchor string
+	depth  int
+}
+
+// heading writes a heading, adding it to the table of contents when depth
+// is not negative
+func (r *markdownReport) heading(level int, title string, depth int) {
+	anchor := r.slug(title)
+	fmt.Fprintf(&r.body, "%s %s\n\n", strings.Repeat("#", level), title)
+	if depth >= 0 {
+		r.toc = append(r.toc, tocEntry{title: title, anchor: anchor, depth: depth})
+	}
+}
+
+// slug returns the anchor GitHub generates for a heading, numbering
+// repeated headings the same way
+func (r *markdownReport) slug(title string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(strings.TrimSpace(title)) {
+		switch {
+		case unicode.IsLetter(c), unicode.IsDigit(c), c == '-', c == '_':
+			b.WriteRune(c)
+		case c == ' ':
+			b.WriteRune('-')
+		}
+	}
+	slug := b.String()
+	n := r.slugs[slug]
+	r.slugs[slug] = n + 1
+	if n > 0 {
+		slug = fmt.Sprintf("%s-%d", slug, n)
+	}
+	return slug
+}
+
+func (r *markdownReport) writePools(pools []models.Pool, byPool map[uint][]*models.Sample) {
+	r.heading(2, "Pools", 0)
+	r.body.WriteString("Pools and hot tubs included in this report, with the number of samples and the dates of the first and last sample in the selected range.\n\n")
+	if len(pools) == 0 {
+		r.body.WriteString("No pools found.\n\n")
+		return
+	}
+
+	t := newMarkdownTable("Name", "Type", fmt.Sprintf("Volume (%s)", r.filter.Unit("volume")), "System", "Samples", "First Sample (UTC)", "Last Sample (UTC)")
+	for _, p := range pools {
+		volume := ""
+		if p.VolumeGallons != nil {
+			volume = FormatNumber(r.filter.Value("volume", *p.VolumeGallons))
+		}
+		first, last := "", ""
+		if samples := byPool[p.ID]; len(samples) > 0 {
+			first, last = formatDateTime(oldest(samples).SampleDateTime), formatDateTime(newest(samples).SampleDateTime)
+		}
+		t.row(p.Name, poolType(p.Type), volume, optionalString(p.SystemDescription),
+			strconv.Itoa(len(byPool[p.ID])), first, last)
+	}
+	t.write(&r.body)
+}
+
+func (r *markdownReport) writeKits(kits []models.Kit) {
+	r.heading(2, "Kits", 0)
+	r.body.WriteString("Test kits used to take samples.\n\n")
+	if len(kits) == 0 {
+		r.body.WriteString("No kits found.\n\n")
+		return
+	}
+
+	t := newMarkdownTable("Name", "Description", "Purchased", "Replenished")
+	for _, k := range kits {
+		t.row(k.Name, optionalString(k.Description), formatDate(k.PurchasedDate), formatDate(k.ReplenishedDate))
+	}
+	t.write(&r.body)
+}
+
+func (r *markdownReport) writeSamples(pools []models.Pool, byPool map[uint][]*models.Sample, total int) {
+	order := "oldest first"
+	if r.filter.Descending {
+		order = "newest first"
+	}
+	r.heading(2, "Samples", 0)
+	fmt.Fprintf(&r.body, "%d samples, grouped by pool and sorted by sample date, %s. Empty cells are parameters that were not recorded.\n\n", total, order)
+
+	for _, p := range pools {
+		samples := byPool[p.ID]
+		r.heading(3, p.Name, 1)
+		if len(samples) == 0 {
+			r.body.WriteString("No samples in the selected range.\n\n")
+			continue
+		}
+		r.writeSummary(samples)
+		r.writeMeasurements(samples)
+		r.writeIndices(samples)
+		r.writeAdditions(samples)
+		r.writeNotes(samples)
+	}
+}
+
+// writeSummary writes the count, range, average and latest value of each
+// parameter recorded for a pool
+func (r *markdownReport) writeSummary(samples []*models.Sample) {
+	r.heading(4, "Summary", -1)
+	latest := newest(samples)
+
+	t := newMarkdownTable("Parameter", "Samples", "Min", "Max", "Average", "Latest")
+	for _, p := range append(r.filter.MeasurementParameters(), r.filter.IndexParameters()...) {
+		var count int
+		var sum float64
+		minimum, maximum := math.Inf(1), math.Inf(-1)
+		for _, s := range samples {
+			if value, ok := s.ParameterValue(p); ok {
+				value = r.filter.Value(p, value)
+				count++
+				sum += value
+				minimum = math.Min(minimum, value)
+				maximum = math.Max(maximum, value)
+			}
+		}
+		if count == 0 {
+			continue
+		}
+		t.row(r.filter.Label(p), strconv.Itoa(count), FormatNumber(minimum), FormatNumber(maximum),
+			FormatNumber(sum/float64(count)), r.value(latest, p))
+	}
+	if len(t.rows) == 0 {
+		r.body.WriteString("No measurements recorded.\n\n")
+		return
+	}
+	t.write(&r.body)
+}
+
+func (r *markdownReport) writeMeasurements(samples []*models.Sample) {
+	r.heading(4, "Measurements", -1)
+	parameters := r.filter.MeasurementParameters()
+	headers := []string{"Date (UTC)"}
+	for _, p := range parameters {
+		headers = append(headers, r.filter.Label(p))
+	}
+	headers = append(headers, "Appearance", "Maintenance", "Kit")
+
+	t := newMarkdownTable(headers...)
+	for _, s := range samples {
+		row := []string{formatDateTime(s.SampleDateTime)}
+		for _, p := range parameters {
+			row = append(row, r.value(s, p))
+		}
+		var appearance, maintenance *string
+		if s.Measurements != nil {
+			appearance, maintenance = s.Measurements.Appearance, s.Measurements.Maintenance
+		}
+		row = append(row, optionalString(appearance), optionalString(maintenance), kitName(*s))
+		t.row(row...)
+	}
+	t.write(&r.body)
+}
+
+func (r *markdownReport) writeIndices(samples []*models.Sample) {
+	parameters := r.filter.IndexParameters()
+	if len(parameters) == 0 {
+		return
+	}
+	r.heading(4, "Water Balance Indices", -1)
+	headers := []string{"Date (UTC)"}
+	for _, p := range parameters {
+		headers = append(headers, r.filter.Label(p))
+	}
+	headers = append(headers, "Comment")
+
+	t := newMarkdownTable(headers...)
+	for _, s := range samples {
+		if s.Indices == nil {
+			continue
+		}
+		row := []string{formatDateTime(s.SampleDateTime)}
+		for _, p := range parameters {
+			row = append(row, r.value(s, p))
+		}
+		t.row(append(row, optionalString(s.Indices.Comment))...)
+	}
+	if len(t.rows) == 0 {
+		r.body.WriteString("No indices calculated.\n\n")
+		return
+	}
+	t.write(&r.body)
+}
+
+func (r *markdownReport) writeAdditions(samples []*models.Sample) {
+	t := newMarkdownTable("Date (UTC)", "Chemical", "Amount", "Unit", "Notes")
+	for _, s := range samples {
+		for _, a := range s.Additions {
+			t.row(formatDateTime(s.SampleDateTime), a.Chemical, FormatNumber(a.Amount), a.Unit, optionalString(a.Notes))
+		}
+	}
+	if len(t.rows) == 0 {
+		return
+	}
+	r.heading(4, "Additions", -1)
+	t.write(&r.body)
+}
+
+func (r *markdownReport) writeNotes(samples []*models.Sample) {
+	t := newMarkdownTable("Date (UTC)", "Notes")
+	for _, s := range samples {
+		if strings.TrimSpace(s.Notes) != "" {
+			t.row(formatDateTime(s.SampleDateTime), s.Notes)
+		}
+	}
+	if len(t.rows) == 0 {
+		return
+	}
+	r.heading(4, "Notes", -1)
+	t.write(&r.body)
+}
+
+// value formats a parameter of a sample in the filter's unit system, or
+// returns "" when it was not recorded
+func (r *markdownReport) value(s *models.Sample, parameter string) string {
+	if value, ok := s.ParameterValue(parameter); ok {
+		return FormatNumber(r.filter.Value(parameter, value))
+	}
+	return ""
+}
+
+// writeAppendices appends every .md file in dir, sorted by name, listing the
+// headings of each file in the table of contents. A missing or unreadable
+// appendix is logged and skipped.
+func (r *markdownReport) writeAppendices(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Warning: Failed to read appendices directory: %v", err)
+		return
+	}
+
+	// Sort files by name for consistent ordering
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	var appendices []string
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".md" {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("Warning: Failed to read appendix file %s: %v", path, err)
+			continue
+		}
+		appendices = append(appendices, strings.TrimSpace(string(content)))
+	}
+	if len(appendices) == 0 {
+		return
+	}
+
+	r.body.WriteString("---\n\n")
+	r.heading(1, "Appendices", 0)
+	for _, content := range appendices {
+		// Register every heading so that anchors are numbered as GitHub
+		// numbers them, but only list the first heading of each appendix
+		for i, title := range markdownHeadings(content) {
+			anchor := r.slug(title)
+			if i == 0 {
+				r.toc = append(r.toc, tocEntry{title: title, anchor: anchor, depth: 1})
+			}
+		}
+		r.body.WriteString(content)
+		r.body.WriteString("\n\n")
+	}
+}
+
+// markdownHeadings returns the ATX headings of a document, skipping fenced code
+func markdownHeadings(content string) []string {
+	var headings []string
+	fenced := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if fenced || !strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+		title := trimmed[level:]
+		if level > 6 || (title != "" && title[0] != ' ' && title[0] != '\t') {
+			continue
+		}
+		title = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(title), "#"))
+		if title != "" {
+			headings = append(headings, title)
+		}
+	}
+	return headings
+}
+
+// markdownTable builds a GitHub-flavored Markdown table
+type markdownTable struct {
+	headers []string
+	rows    [][]string
+}
+
+func newMarkdownTable(headers ...string) *markdownTable {
+	return &markdownTable{headers: headers}
+}
+
+func (t *markdownTable) row(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+func (t *markdownTable) write(b *strings.Builder) {
+	writeTableRow(b, t.headers)
+	b.WriteString("|")
+	for range t.headers {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for _, row := range t.rows {
+		writeTableRow(b, row)
+	}
+	b.WriteString("\n")
+}
+
+func writeTableRow(b *strings.Builder, cells []string) {
+	b.WriteString("|")
+	for _, cell := range cells {
+		b.WriteString(" ")
+		b.WriteString(EscapeTableCell(cell))
+		b.WriteString(" |")
+	}
+	b.WriteString("\n")
+}
+
+// EscapeTableCell keeps a value inside its table cell: backslashes and
+// pipes are escaped and line breaks become <br>. Report templates use it
+// as cell.
+func EscapeTableCell(value string) string {
+	value = strings.TrimSpace(value)
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "|", "\\|")
+	value = strings.ReplaceAll(value, "\r\n", "\n")
+	return strings.ReplaceAll(value, "\n", "<br>")
+}
+
+func escapeLinkText(value string) string {
+	value = strings.ReplaceAll(value, "[", "\\[")
+	return strings.ReplaceAll(value, "]", "\\]")
+}
+
+// FormatNumber rounds to two decimals and drops trailing zeros, as in
+// Markdown exports and reports
+func FormatNumber(value float64) string {
+	return strconv.FormatFloat(math.Round(value*100)/100, 'f', -1, 64)
+}
+
+func formatDateTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04")
+}
+
+func formatDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+func poolType(typ string) string {
+	switch typ {
+	case "pool":
+		return "Pool"
+	case "hot_tub":
+		return "Hot Tub"
+	}
+	return typ
+}
+
+func oldest(samples []*models.Sample) *models.Sample {
+	first := samples[0]
+	for _, s := range samples[1:] {
+		if s.SampleDateTime.Before(first.SampleDateTime) {
+			first = s
+		}
+	}
+	return first
+}
+
+func newest(samples []*models.Sample) *models.Sample {
+	last := samples[0]
+	for _, s := range samples[1:] {
+		if !s.SampleDateTime.Before(last.SampleDateTime) {
+			last = s
+		}
+	}
+	return last
+}
@@ -0,0 +1,133 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// NDJSONContentType is the MIME type of NDJSON exports
+const NDJSONContentType = "application/x-ndjson"
+
+// NDJSONFilename returns the export file name for a time, e.g. WL20240714_143022.ndjson
+func NDJSONFilename(t time.Time) string {
+	return fmt.Sprintf("WL%s.ndjson", t.Format("20060102_150405"))
+}
+
+// ndjsonSample is one line of the NDJSON export
+type ndjsonSample struct {
+	ID             uint                   `json:"id"`
+	SampleDateTime time.Time              `json:"sample_datetime"`
+	Pool           ndjsonRef              `json:"pool"`
+	User           ndjsonRef              `json:"user"`
+	Kit            *ndjsonRef             `json:"kit,omitempty"`
+	Units          chemistry.UnitSystem   `json:"units"`
+	Measurements   map[string]interface{} `json:"measurements,omitempty"`
+	Indices        map[string]interface{} `json:"indices,omitempty"`
+	Additions      []ndjsonAddition       `json:"additions,omitempty"`
+	Notes          string                 `json:"notes,omitempty"`
+}
+
+// ndjsonRef names the pool, user or kit of a sample
+type ndjsonRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type ndjsonAddition struct {
+	Chemical string  `json:"chemical"`
+	Amount   float64 `json:"amount"`
+	Unit     string  `json:"unit,omitempty"`
+	Notes    *string `json:"notes,omitempty"`
+}
+
+// WriteNDJSON streams one JSON object per line for each sample selected by
+// the filter, with its pool, user and kit, the recorded measurements and
+// indices in the filter's unit system and the additions. Samples are read
+// and flushed a page at a time like WriteCSV, so nothing is written before
+// the first page is read.
+func WriteNDJSON(db *gorm.DB, w io.Writer, f Filter) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	flusher, _ := w.(interface{ Flush() })
+
+	units := f.Units
+	if units == "" {
+		units = chemistry.Imperial
+	}
+
+	for offset := 0; ; offset += samplePageSize {
+		var samples []models.Sample
+		err := db.Preload("Pool").Preload("User").Preload("Kit").
+			Preload("Measurements").Preload("Indices").Preload("Additions", orderByID).
+			Scopes(f.Samples).Limit(samplePageSize).Offset(offset).Find(&samples).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch samples: %w", err)
+		}
+
+		for i := range samples {
+			if err := enc.Encode(ndjsonLine(&samples[i], f, units)); err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(samples) < samplePageSize {
+			return nil
+		}
+	}
+}
+
+// ndjsonLine converts a sample to a line of the NDJSON export
+func ndjsonLine(s *models.Sample, f Filter, units chemistry.UnitSystem) ndjsonSample {
+	line := ndjsonSample{
+		ID:             s.ID,
+		SampleDateTime: s.SampleDateTime.UTC(),
+		Pool:           ndjsonRef{ID: s.PoolID, Name: poolName(*s)},
+		User:           ndjsonRef{ID: s.UserID, Name: userName(*s)},
+		Units:          units,
+		Notes:          s.Notes,
+	}
+	if s.KitID != 0 {
+		line.Kit = &ndjsonRef{ID: s.KitID, Name: kitName(*s)}
+	}
+
+	if s.Measurements != nil {
+		line.Measurements = make(map[string]interface{})
+		for _, p := range f.MeasurementParameters() {
+			if value, ok := s.ParameterValue(p); ok {
+				line.Measurements[p] = f.Value(p, value)
+			}
+		}
+		if s.Measurements.Appearance != nil {
+			line.Measurements["appearance"] = *s.Measurements.Appearance
+		}
+		if s.Measurements.Maintenance != nil {
+			line.Measurements["maintenance"] = *s.Measurements.Maintenance
+		}
+	}
+
+	if s.Indices != nil {
+		line.Indices = make(map[string]interface{})
+		for _, p := range f.IndexParameters() {
+			if value, ok := s.ParameterValue(p); ok {
+				line.Indices[p] = value
+			}
+		}
+		if s.Indices.Comment != nil {
+			line.Indices["comment"] = *s.Indices.Comment
+		}
+	}
+
+	for _, a := range s.Additions {
+		line.Additions = append(line.Additions, ndjsonAddition{Chemical: a.Chemical, Amount: a.Amount, Unit: a.Unit, Notes: a.Notes})
+	}
+	return line
+}
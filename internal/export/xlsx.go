@@ -0,0 +1,391 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Cell styles defined in styles.xml
+const (
+	styleDefault  = 0
+	styleHeader   = 1
+	styleDateTime = 2
+	styleDate     = 3
+)
+
+// maxSheetNameLength is the longest worksheet name Excel accepts
+const maxSheetNameLength = 31
+
+// excelEpoch is day zero of the 1900 date system, adjusted for the
+// fictitious 29 February 1900 that Excel counts
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+type cellKind int
+
+const (
+	cellEmpty cellKind = iota
+	cellString
+	cellNumber
+	cellDateTime
+	cellDate
+)
+
+// Cell is a single typed worksheet value
+type Cell struct {
+	kind   cellKind
+	text   string
+	number float64
+	time   time.Time
+}
+
+// Empty returns a blank cell
+func Empty() Cell {
+	return Cell{}
+}
+
+// Text returns a string cell
+func Text(s string) Cell {
+	return Cell{kind: cellString, text: s}
+}
+
+// OptionalText returns a string cell, or a blank cell for nil
+func OptionalText(s *string) Cell {
+	if s == nil {
+		return Empty()
+	}
+	return Text(*s)
+}
+
+// Number returns a numeric cell
+func Number(f float64) Cell {
+	return Cell{kind: cellNumber, number: f}
+}
+
+// OptionalNumber returns a numeric cell, or a blank cell for nil
+func OptionalNumber(f *float64) Cell {
+	if f == nil {
+		return Empty()
+	}
+	return Number(*f)
+}
+
+// DateTime returns a date and time cell, in UTC
+func DateTime(t time.Time) Cell {
+	return Cell{kind: cellDateTime, time: t.UTC()}
+}
+
+// Date returns a date cell, or a blank cell for nil
+func Date(t *time.Time) Cell {
+	if t == nil {
+		return Empty()
+	}
+	return Cell{kind: cellDate, time: t.UTC()}
+}
+
+// Sheet is a worksheet with a frozen header row
+type Sheet struct {
+	name    string
+	headers []string
+	widths  []int
+	rows    [][]Cell
+}
+
+// AddRow appends a row of cells; missing trailing cells are left blank
+func (s *Sheet) AddRow(cells ...Cell) {
+	for i, cell := range cells {
+		if i < len(s.widths) {
+			if w := cell.width(); w > s.widths[i] {
+				s.widths[i] = w
+			}
+		}
+	}
+	s.rows = append(s.rows, cells)
+}
+
+// Workbook is an in-memory XLSX workbook
+type Workbook struct {
+	sheets []*Sheet
+}
+
+// NewWorkbook creates an empty workbook
+func NewWorkbook() *Workbook {
+	return &Workbook{}
+}
+
+// AddSheet adds a worksheet with the given header row
+func (wb *Workbook) AddSheet(name string, headers ...string) *Sheet {
+	sheet := &Sheet{
+		name:    sheetName(name),
+		headers: headers,
+		widths:  make([]int, len(headers)),
+	}
+	for i, h := range headers {
+		sheet.widths[i] = utf8.RuneCountInString(h)
+	}
+	wb.sheets = append(wb.sheets, sheet)
+	return sheet
+}
+
+// Write encodes the workbook as an Office Open XML spreadsheet
+func (wb *Workbook) Write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+
+	parts := []struct {
+		name    string
+		content func(io.Writer) error
+	}{
+		{"[Content_Types].xml", wb.writeContentTypes},
+		{"_rels/.rels", writeStatic(rootRelsXML)},
+		{"docProps/app.xml", writeStatic(appXML)},
+		{"xl/workbook.xml", wb.writeWorkbook},
+		{"xl/_rels/workbook.xml.rels", wb.writeWorkbookRels},
+		{"xl/styles.xml", writeStatic(stylesXML)},
+	}
+	for i := range wb.sheets {
+		sheet := wb.sheets[i]
+		parts = append(parts, struct {
+			name    string
+			content func(io.Writer) error
+		}{fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1), sheet.write})
+	}
+
+	for _, part := range parts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if err := part.content(pw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", part.name, err)
+		}
+	}
+
+	return zw.Close()
+}
+
+func (wb *Workbook) writeContentTypes(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">`)
+	sb.WriteString(`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>`)
+	sb.WriteString(`<Default Extension="xml" ContentType="application/xml"/>`)
+	sb.WriteString(`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>`)
+	sb.WriteString(`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>`)
+	sb.WriteString(`<Override PartName="/docProps/app.xml" ContentType="application/vnd.openxmlformats-officedocument.extended-properties+xml"/>`)
+	for i := range wb.sheets {
+		fmt.Fprintf(&sb, `<Override PartName="/xl/worksheets/sheet%d.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`, i+1)
+	}
+	sb.WriteString(`</Types>`)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (wb *Workbook) writeWorkbook(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`)
+	sb.WriteString(`<sheets>`)
+	for i, sheet := range wb.sheets {
+		fmt.Fprintf(&sb, `<sheet name="%s" sheetId="%d" r:id="rId%d"/>`, escape(sheet.name), i+1, i+1)
+	}
+	sb.WriteString(`</sheets>`)
+	sb.WriteString(`</workbook>`)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (wb *Workbook) writeWorkbookRels(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`)
+	for i := range wb.sheets {
+		fmt.Fprintf(&sb, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%d.xml"/>`, i+1, i+1)
+	}
+	fmt.Fprintf(&sb, `<Relationship Id="rId%d" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>`, len(wb.sheets)+1)
+	sb.WriteString(`</Relationships>`)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// write encodes the worksheet. The header row is frozen and filterable.
+func (s *Sheet) write(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`)
+
+	lastColumn := columnName(max(len(s.headers), 1) - 1)
+	fmt.Fprintf(&sb, `<dimension ref="A1:%s%d"/>`, lastColumn, len(s.rows)+1)
+	sb.WriteString(`<sheetViews><sheetView workbookViewId="0">`)
+	sb.WriteString(`<pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/>`)
+	sb.WriteString(`<selection pane="bottomLeft" activeCell="A2" sqref="A2"/>`)
+	sb.WriteString(`</sheetView></sheetViews>`)
+
+	if len(s.widths) > 0 {
+		sb.WriteString(`<cols>`)
+		for i, width := range s.widths {
+			fmt.Fprintf(&sb, `<col min="%d" max="%d" width="%d" customWidth="1"/>`, i+1, i+1, min(width+2, 60))
+		}
+		sb.WriteString(`</cols>`)
+	}
+
+	sb.WriteString(`<sheetData>`)
+	sb.WriteString(`<row r="1">`)
+	for i, header := range s.headers {
+		writeInlineString(&sb, cellRef(i, 1), header, styleHeader)
+	}
+	sb.WriteString(`</row>`)
+	for r, row := range s.rows {
+		fmt.Fprintf(&sb, `<row r="%d">`, r+2)
+		for c, cell := range row {
+			cell.write(&sb, cellRef(c, r+2))
+		}
+		sb.WriteString(`</row>`)
+	}
+	sb.WriteString(`</sheetData>`)
+
+	if len(s.headers) > 0 {
+		fmt.Fprintf(&sb, `<autoFilter ref="A1:%s%d"/>`, lastColumn, len(s.rows)+1)
+	}
+	sb.WriteString(`</worksheet>`)
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (c Cell) write(sb *strings.Builder, ref string) {
+	switch c.kind {
+	case cellString:
+		writeInlineString(sb, ref, c.text, styleDefault)
+	case cellNumber:
+		fmt.Fprintf(sb, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(c.number, 'f', -1, 64))
+	case cellDateTime:
+		fmt.Fprintf(sb, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDateTime, strconv.FormatFloat(excelSerial(c.time), 'f', -1, 64))
+	case cellDate:
+		fmt.Fprintf(sb, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleDate, strconv.FormatFloat(excelSerial(c.time), 'f', -1, 64))
+	}
+}
+
+// width estimates the column width needed to show the cell
+func (c Cell) width() int {
+	switch c.kind {
+	case cellString:
+		if i := strings.IndexByte(c.text, '\n'); i >= 0 {
+			return utf8.RuneCountInString(c.text[:i])
+		}
+		return utf8.RuneCountInString(c.text)
+	case cellNumber:
+		return len(strconv.FormatFloat(c.number, 'f', -1, 64))
+	case cellDateTime:
+		return len("2006-01-02 15:04")
+	case cellDate:
+		return len("2006-01-02")
+	}
+	return 0
+}
+
+func writeInlineString(sb *strings.Builder, ref, text string, style int) {
+	fmt.Fprintf(sb, `<c r="%s" t="inlineStr"`, ref)
+	if style != styleDefault {
+		fmt.Fprintf(sb, ` s="%d"`, style)
+	}
+	fmt.Fprintf(sb, `><is><t xml:space="preserve">%s</t></is></c>`, escape(text))
+}
+
+// excelSerial converts a time to an Excel serial date: days since the epoch
+// with the time of day as the fraction
+func excelSerial(t time.Time) float64 {
+	serial := t.Sub(excelEpoch).Seconds() / 86400
+	// Round to the millisecond to avoid long binary fractions
+	return float64(int64(serial*86400000+0.5)) / 86400000
+}
+
+// cellRef returns the A1-style reference of a zero-based column and one-based row
+func cellRef(col, row int) string {
+	return columnName(col) + strconv.Itoa(row)
+}
+
+// columnName converts a zero-based column index to letters (0 = A, 26 = AA)
+func columnName(col int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name
+}
+
+// sheetName removes the characters Excel forbids in worksheet names and
+// truncates the name to the allowed length
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if utf8.RuneCountInString(name) > maxSheetNameLength {
+		name = string([]rune(name)[:maxSheetNameLength])
+	}
+	return name
+}
+
+// escape escapes text for XML, dropping characters XML cannot represent
+func escape(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' || (r >= 0x20 && r != 0xFFFE && r != 0xFFFF) {
+			return r
+		}
+		return -1
+	}, s)
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+func writeStatic(content string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}
+}
+
+const rootRelsXML = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/extended-properties" Target="docProps/app.xml"/>` +
+	`</Relationships>`
+
+const appXML = xml.Header + `<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties">` +
+	`<Application>Waterlogger</Application>` +
+	`</Properties>`
+
+// stylesXML defines the cell formats referenced by the style constants:
+// default, bold header, date and time, and date
+const stylesXML = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="2">` +
+	`<numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm"/>` +
+	`<numFmt numFmtId="165" formatCode="yyyy-mm-dd"/>` +
+	`</numFmts>` +
+	`<fonts count="2">` +
+	`<font><sz val="11"/><name val="Calibri"/></font>` +
+	`<font><b/><sz val="11"/><name val="Calibri"/></font>` +
+	`</fonts>` +
+	`<fills count="3">` +
+	`<fill><patternFill patternType="none"/></fill>` +
+	`<fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFDCE6F1"/><bgColor indexed="64"/></patternFill></fill>` +
+	`</fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="4">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
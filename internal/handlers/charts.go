@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+	"waterlogger/internal/sensors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chartParameters lists the parameters that can be charted, in display order
+var chartParameters = []string{"ph", "fc", "tc", "ta", "ch", "cya", "temperature", "salinity", "tds", "orp", "lsi", "rsi"}
+
+// maxChartReadingPoints is the most buckets sensor readings of a parameter
+// are averaged into for a chart
+const maxChartReadingPoints = 500
+
+// chartColors assigns each parameter a stable line color
+var chartColors = map[string]string{
+	"ph":          "#3b82f6",
+	"fc":          "#10b981",
+	"tc":          "#f59e0b",
+	"ta":          "#8b5cf6",
+	"ch":          "#ef4444",
+	"cya":         "#06b6d4",
+	"temperature": "#f97316",
+	"salinity":    "#64748b",
+	"tds":         "#a16207",
+	"orp":         "#0d9488",
+	"lsi":         "#db2777",
+	"rsi":         "#4f46e5",
+}
+
+// GetChartData returns time-series data for the dashboard charts
+func (h *Handlers) GetChartData(c *gin.Context) {
+	query := h.db.Model(&models.Sample{}).Preload("Measurements").Preload("Indices")
+	var readingFilter sensors.ReadingFilter
+
+	if poolID := c.Query("pool_id"); poolID != "" {
+		id, err := strconv.ParseUint(poolID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool ID"})
+			return
+		}
+		query = query.Where("pool_id = ?", uint(id))
+		readingFilter.PoolID = uint(id)
+	}
+
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, _, err := parseChartDate(startDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date"})
+			return
+		}
+		query = query.Where("sample_date_time >= ?", start)
+		readingFilter.From = start
+	}
+
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := parseChartEndDate(endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date"})
+			return
+		}
+		query = query.Where("sample_date_time < ?", end)
+		readingFilter.To = end
+	}
+
+	parameters := chartParameters
+	if requested := c.Query("parameters"); requested != "" {
+		parameters = nil
+		for _, p := range strings.Split(requested, ",") {
+			p = strings.ToLower(strings.TrimSpace(p))
+			if _, ok := chartColors[p]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown parameter: " + p})
+				return
+			}
+			parameters = append(parameters, p)
+		}
+	}
+
+	// Order explicitly so every database engine returns the same series
+	var samples []models.Sample
+	if err := query.Order("sample_date_time ASC").Order("id ASC").Find(&samples).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data"})
+		return
+	}
+
+	// Sensor readings of measured parameters and the indices calculated
+	// from them are separate datasets, as is free chlorine estimated from
+	// the ORP readings of a calibrated pool
+	var series map[string][]sensors.Point
+	var estimates []sensors.Point
+	if c.Query("readings") != "false" {
+		for _, parameter := range parameters {
+			if _, _, ok := chemistry.ValidRange(parameter); ok || models.IndexParameter(parameter) {
+				readingFilter.Parameters = append(readingFilter.Parameters, parameter)
+			}
+		}
+		if len(readingFilter.Parameters) > 0 {
+			var err error
+			if series, err = sensors.Series(h.db, readingFilter, maxChartReadingPoints); err != nil {
+				log.Printf("Failed to fetch chart readings: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data"})
+				return
+			}
+		}
+		if slices.Contains(parameters, "fc") {
+			var err error
+			if estimates, err = sensors.EstimateFCSeries(h.db, readingFilter, maxChartReadingPoints); err != nil {
+				log.Printf("Failed to estimate chart FC: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chart data"})
+				return
+			}
+		}
+	}
+
+	// Samples and reading buckets share one sorted time axis of whole
+	// minutes, with one label per minute. Datasets are null at the minutes
+	// they have no value for; of samples taken in the same minute, the
+	// latest is charted.
+	columns := make(map[time.Time]int)
+	addTime := func(t time.Time) {
+		columns[t.UTC().Truncate(time.Minute)] = 0
+	}
+	for _, sample := range samples {
+		addTime(sample.SampleDateTime)
+	}
+	for _, points := range series {
+		for _, point := range points {
+			addTime(point.Time)
+		}
+	}
+	for _, point := range estimates {
+		addTime(point.Time)
+	}
+	times := make([]time.Time, 0, len(columns))
+	for t := range columns {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	labels := make([]string, len(times))
+	for i, t := range times {
+		labels[i] = t.Format("2006-01-02 15:04")
+		columns[t] = i
+	}
+	// column returns the column of a time
+	column := func(t time.Time) int {
+		return columns[t.UTC().Truncate(time.Minute)]
+	}
+
+	names := chemistry.GetParameterNames()
+	datasets := make([]gin.H, 0, len(parameters))
+	for _, parameter := range parameters {
+		data := make([]*float64, len(labels))
+		for i := range samples {
+			if value, ok := samples[i].ParameterValue(parameter); ok {
+				data[column(samples[i].SampleDateTime)] = &value
+			}
+		}
+		datasets = append(datasets, gin.H{
+			"parameter":       parameter,
+			"source":          "samples",
+			"label":           names[parameter],
+			"data":            data,
+			"borderColor":     chartColors[parameter],
+			"backgroundColor": chartColors[parameter] + "1a",
+			"spanGaps":        true,
+		})
+	}
+	for _, parameter := range parameters {
+		points, ok := series[parameter]
+		if !ok {
+			continue
+		}
+		data := make([]*float64, len(labels))
+		for _, point := range points {
+			value := point.Value
+			data[column(point.Time)] = &value
+		}
+		datasets = append(datasets, gin.H{
+			"parameter":       parameter,
+			"source":          "readings",
+			"label":           names[parameter] + " (sensor)",
+			"data":            data,
+			"borderColor":     chartColors[parameter],
+			"backgroundColor": chartColors[parameter] + "1a",
+			"borderDash":      []int{4, 4},
+			"pointRadius":     0,
+			"spanGaps":        true,
+		})
+	}
+	if len(estimates) > 0 {
+		data := make([]*float64, len(labels))
+		for _, point := range estimates {
+			value := point.Value
+			data[column(point.Time)] = &value
+		}
+		datasets = append(datasets, gin.H{
+			"parameter":       "fc",
+			"source":          "estimate",
+			"label":           names["fc"] + " (from ORP)",
+			"data":            data,
+			"borderColor":     chartColors["fc"],
+			"backgroundColor": chartColors["fc"] + "1a",
+			"borderDash":      []int{2, 6},
+			"pointRadius":     0,
+			"spanGaps":        true,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"labels":   labels,
+		"datasets": datasets,
+	})
+}
+
+// parseChartDate parses a date (YYYY-MM-DD) or RFC 3339 timestamp as UTC,
+// reporting whether only a date was given
+func parseChartDate(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t.UTC(), false, err
+}
+
+// parseChartEndDate parses an end date as an exclusive bound: a date
+// includes the whole day and a timestamp includes that instant
+func parseChartEndDate(value string) (time.Time, error) {
+	end, dateOnly, err := parseChartDate(value)
+	if err != nil {
+		return end, err
+	}
+	if dateOnly {
+		return end.AddDate(0, 0, 1), nil
+	}
+	return end.Add(time.Nanosecond), nil
+}
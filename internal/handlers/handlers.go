@@ -1,21 +1,25 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
-	"path/filepath"
-	"sort"
 	"strconv"
 	"time"
 
 	"waterlogger/internal/chemistry"
 	"waterlogger/internal/config"
+	"waterlogger/internal/database"
+	"waterlogger/internal/export"
+	"waterlogger/internal/homeassistant"
+	"waterlogger/internal/influx"
 	"waterlogger/internal/middleware"
 	"waterlogger/internal/models"
+	"waterlogger/internal/notify"
+	"waterlogger/internal/webhooks"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -56,6 +60,8 @@ func (h *Handlers) SetupWizardAPI(c *gin.Context) {
 		DBUsername   string `json:"db_username"`
 		DBPassword   string `json:"db_password"`
 		DBName       string `json:"db_name"`
+		DBSSLMode    string `json:"db_sslmode"`
+		DBSchema     string `json:"db_schema"`
 		
 		ServerPort int `json:"server_port"`
 	}
@@ -86,6 +92,7 @@ func (h *Handlers) SetupWizardAPI(c *gin.Context) {
 		Username: req.Username,
 		Email:    req.Email,
 		Password: hashedPassword,
+		IsAdmin:  true,
 	}
 
 	if err := h.db.Create(&user).Error; err != nil {
@@ -110,6 +117,31 @@ func (h *Handlers) SetupWizardAPI(c *gin.Context) {
 	if req.DatabaseType != "" {
 		h.cfg.Database.Type = req.DatabaseType
 		
+		// Update PostgreSQL configuration if provided
+		if req.DatabaseType == "postgres" {
+			if req.DBHost != "" {
+				h.cfg.Database.Postgres.Host = req.DBHost
+			}
+			if req.DBPort > 0 {
+				h.cfg.Database.Postgres.Port = req.DBPort
+			}
+			if req.DBUsername != "" {
+				h.cfg.Database.Postgres.Username = req.DBUsername
+			}
+			if req.DBPassword != "" {
+				h.cfg.Database.Postgres.Password = req.DBPassword
+			}
+			if req.DBName != "" {
+				h.cfg.Database.Postgres.Database = req.DBName
+			}
+			if req.DBSSLMode != "" {
+				h.cfg.Database.Postgres.SSLMode = req.DBSSLMode
+			}
+			if req.DBSchema != "" {
+				h.cfg.Database.Postgres.Schema = req.DBSchema
+			}
+		}
+		
 		// Update MariaDB configuration if provided
 		if req.DatabaseType == "mariadb" {
 			if req.DBHost != "" {
@@ -229,6 +261,17 @@ func (h *Handlers) CreatePool(c *gin.Context) {
 		return
 	}
 
+	// Trashed pools keep their unique name until they are purged
+	var trashed int64
+	if err := h.db.Unscoped().Model(&models.Pool{}).Where("name = ? AND deleted_at IS NOT NULL", pool.Name).Count(&trashed).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check pool name"})
+		return
+	}
+	if trashed > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A deleted pool with this name is in the trash; restore or purge it first"})
+		return
+	}
+
 	if err := h.db.Create(&pool).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pool"})
 		return
@@ -270,12 +313,100 @@ func (h *Handlers) DeletePool(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&models.Pool{}, uint(id)).Error; err != nil {
+	var pool models.Pool
+	if err := h.db.First(&pool, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pool not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pool"})
+		}
+		return
+	}
+
+	// Samples are only trashed with the pool when the caller asks for it
+	preview, err := database.PreviewPoolDelete(h.db, pool.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check pool usage"})
+		return
+	}
+
+	if preview.HasDependents() && c.Query("cascade") != "true" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":        "Cannot delete pool that has samples",
+			"would_delete": preview,
+		})
+		return
+	}
+
+	deleted, err := database.DeletePool(h.db, pool.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete pool"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Pool deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Pool moved to trash", "deleted": deleted})
+}
+
+// PreviewPoolDelete reports what deleting a pool would remove
+func (h *Handlers) PreviewPoolDelete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool ID"})
+		return
+	}
+
+	var pool models.Pool
+	if err := h.db.First(&pool, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pool not found"})
+		return
+	}
+
+	preview, err := database.PreviewPoolDelete(h.db, pool.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check pool usage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
+}
+
+// GetPoolState returns the latest values, units and statuses of a pool in
+// a compact form for Home Assistant REST sensors
+func (h *Handlers) GetPoolState(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	state, err := homeassistant.State(h.db, *pool, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to build state of pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pool state"})
+		return
+	}
+	c.JSON(http.StatusOK, state)
+}
+
+// loadPool loads the pool given by the id parameter, writing the error
+// response when it cannot
+func (h *Handlers) loadPool(c *gin.Context) (*models.Pool, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool ID"})
+		return nil, false
+	}
+
+	var pool models.Pool
+	if err := h.db.First(&pool, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Pool not found"})
+		} else {
+			log.Printf("Failed to fetch pool %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pool"})
+		}
+		return nil, false
+	}
+	return &pool, true
 }
 
 // Samples
@@ -290,7 +421,7 @@ func (h *Handlers) SamplesPage(c *gin.Context) {
 func (h *Handlers) GetSamples(c *gin.Context) {
 	var samples []models.Sample
 	if err := h.db.Preload("Pool").Preload("User").Preload("Kit").
-		Preload("Measurements").Preload("Indices").Find(&samples).Error; err != nil {
+		Preload("Measurements").Preload("Indices").Preload("Additions").Find(&samples).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch samples"})
 		return
 	}
@@ -334,11 +465,18 @@ func (h *Handlers) CreateSample(c *gin.Context) {
 	}
 
 	// Load the complete sample with all relationships
-	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").First(&sample, sample.ID).Error; err != nil {
+	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").Preload("Additions").First(&sample, sample.ID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load complete sample"})
 		return
 	}
 
+	if err := webhooks.SampleSaved(h.db, &sample, true, nil, sample.Additions); err != nil {
+		log.Printf("Failed to queue webhooks for sample %d: %v", sample.ID, err)
+	}
+	if err := notify.SampleSaved(h.db, h.cfg.Email, &sample, nil); err != nil {
+		log.Printf("Failed to send alert emails for sample %d: %v", sample.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, sample)
 }
 
@@ -355,15 +493,25 @@ func (h *Handlers) UpdateSample(c *gin.Context) {
 		return
 	}
 
+	// Webhooks report alerts and additions that are new with this update
+	alertsBefore := chemistry.CheckSample(&sample)
+	var additionsBefore []models.Addition
+	if err := h.db.Where("sample_id = ?", sample.ID).Find(&additionsBefore).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch additions"})
+		return
+	}
+
 	if err := c.ShouldBindJSON(&sample); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Store measurements separately to avoid GORM auto-save conflicts
+	// Store measurements and additions separately to avoid GORM auto-save conflicts
 	measurementsData := sample.Measurements
+	additionsData := sample.Additions
 	sample.Measurements = nil
 	sample.Indices = nil
+	sample.Additions = nil
 
 	// Update sample
 	if err := h.db.Save(&sample).Error; err != nil {
@@ -371,6 +519,23 @@ func (h *Handlers) UpdateSample(c *gin.Context) {
 		return
 	}
 
+	// Replace the additions if the request included them
+	if additionsData != nil {
+		if err := h.db.Unscoped().Where("sample_id = ?", sample.ID).Delete(&models.Addition{}).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update additions"})
+			return
+		}
+		for i := range additionsData {
+			additionsData[i].SampleID = sample.ID
+		}
+		if len(additionsData) > 0 {
+			if err := h.db.Create(&additionsData).Error; err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update additions"})
+				return
+			}
+		}
+	}
+
 	// Restore measurements data for processing
 	sample.Measurements = measurementsData
 
@@ -400,8 +565,9 @@ func (h *Handlers) UpdateSample(c *gin.Context) {
 			}
 		}
 
-		// Always delete existing indices first
-		h.db.Where("sample_id = ?", sample.ID).Delete(&models.Indices{})
+		// Always delete existing indices first; they are recalculated, not
+		// trashed, and the unique sample_id index would reject the new row
+		h.db.Unscoped().Where("sample_id = ?", sample.ID).Delete(&models.Indices{})
 
 		// Recalculate indices if we have the minimum required data
 		if sample.Measurements.PH != 0 {
@@ -423,11 +589,22 @@ func (h *Handlers) UpdateSample(c *gin.Context) {
 	}
 
 	// Load the complete updated sample with all relationships
-	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").First(&sample, sample.ID).Error; err != nil {
+	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").Preload("Additions").First(&sample, sample.ID).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load updated sample"})
 		return
 	}
 
+	var additions []models.Addition
+	if additionsData != nil {
+		additions = webhooks.NewAdditions(sample.Additions, additionsBefore)
+	}
+	if err := webhooks.SampleSaved(h.db, &sample, false, alertsBefore, additions); err != nil {
+		log.Printf("Failed to queue webhooks for sample %d: %v", sample.ID, err)
+	}
+	if err := notify.SampleSaved(h.db, h.cfg.Email, &sample, alertsBefore); err != nil {
+		log.Printf("Failed to send alert emails for sample %d: %v", sample.ID, err)
+	}
+
 	c.JSON(http.StatusOK, sample)
 }
 
@@ -438,12 +615,47 @@ func (h *Handlers) DeleteSample(c *gin.Context) {
 		return
 	}
 
-	if err := h.db.Delete(&models.Sample{}, uint(id)).Error; err != nil {
+	var sample models.Sample
+	if err := h.db.First(&sample, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sample not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sample"})
+		}
+		return
+	}
+
+	// Measurements and indices belong to the sample and are always trashed with it
+	deleted, err := database.DeleteSample(h.db, sample.ID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete sample"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Sample deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Sample moved to trash", "deleted": deleted})
+}
+
+// PreviewSampleDelete reports what deleting a sample would remove
+func (h *Handlers) PreviewSampleDelete(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sample ID"})
+		return
+	}
+
+	var sample models.Sample
+	if err := h.db.First(&sample, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sample not found"})
+		return
+	}
+
+	preview, err := database.PreviewSampleDelete(h.db, sample.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check sample data"})
+		return
+	}
+
+	c.JSON(http.StatusOK, preview)
 }
 
 // Placeholder handlers for remaining endpoints
@@ -505,6 +717,19 @@ func (h *Handlers) CreateUser(c *gin.Context) {
 		return
 	}
 
+	// Trashed users keep their unique username and email until they are purged
+	var trashed int64
+	if err := h.db.Unscoped().Model(&models.User{}).
+		Where("(username = ? OR email = ?) AND deleted_at IS NOT NULL", createData.Username, createData.Email).
+		Count(&trashed).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check username"})
+		return
+	}
+	if trashed > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A deleted user with this username or email is in the trash; restore or purge it first"})
+		return
+	}
+
 	// Create user model
 	user := models.User{
 		Username: createData.Username,
@@ -600,13 +825,27 @@ func (h *Handlers) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	// Delete user
-	if err := h.db.Delete(&user).Error; err != nil {
+	// Prevent deletion of the last administrator
+	if user.IsAdmin {
+		var adminCount int64
+		if err := h.db.Model(&models.User{}).Where("is_admin = ?", true).Count(&adminCount).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check administrator count"})
+			return
+		}
+
+		if adminCount <= 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete the last administrator"})
+			return
+		}
+	}
+
+	// Move user to the trash
+	if err := database.MoveToTrash(h.db, "users", user.ID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "User moved to trash"})
 }
 
 func (h *Handlers) GetKits(c *gin.Context) {
@@ -725,96 +964,136 @@ func (h *Handlers) DeleteKit(c *gin.Context) {
 		return
 	}
 
-	// Delete the kit
-	if err := h.db.Delete(&kit).Error; err != nil {
+	// Move the kit to the trash
+	if err := database.MoveToTrash(h.db, "kits", kit.ID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete kit"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Kit deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Kit moved to trash"})
 }
 
 
 func (h *Handlers) ExportExcel(c *gin.Context) {
-	// Get samples with related data
-	var samples []models.Sample
-	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").Find(&samples).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch samples"})
+	filter, ok := h.exportFilter(c)
+	if !ok {
 		return
 	}
-	
-	// Generate CSV content (simplified Excel export)
-	csvContent := "Sample Date,Pool Name,pH,Free Chlorine (ppm),Total Chlorine (ppm),Total Alkalinity (ppm),Calcium Hardness (ppm),Cyanuric Acid (ppm),Temperature (°F),Salinity (ppm),LSI,RSI,Notes\n"
-	
-	for _, sample := range samples {
-		poolName := ""
-		if sample.Pool != nil {
-			poolName = sample.Pool.Name
+
+	// Build the workbook first so that a failure can still be reported as JSON
+	var buf bytes.Buffer
+	if err := export.WriteExcel(h.db, &buf, filter); err != nil {
+		log.Printf("Excel export failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		return
+	}
+
+	filename := export.ExcelFilename(time.Now())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Data(http.StatusOK, export.ExcelContentType, buf.Bytes())
+}
+
+// ExportCSV streams one CSV row per sample
+func (h *Handlers) ExportCSV(c *gin.Context) {
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+	opts, err := export.ParseCSVOptions(c.Request.URL.Query())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := export.CSVFilename(time.Now())
+	c.Header("Content-Type", export.CSVContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	if err := export.WriteCSV(h.db, c.Writer, filter, opts); err != nil {
+		log.Printf("CSV export failed: %v", err)
+		// Once rows have been streamed the response can only be cut short
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
 		}
-		
-		// Format date
-		date := sample.SampleDateTime.Format("2006-01-02 15:04:05")
-		
-		// Get measurement values
-		ph := ""
-		fc := ""
-		tc := ""
-		ta := ""
-		ch := ""
-		cya := ""
-		temp := ""
-		salinity := ""
-		lsi := ""
-		rsi := ""
-		
-		if sample.Measurements != nil {
-			if sample.Measurements.PH != 0 {
-				ph = fmt.Sprintf("%.2f", sample.Measurements.PH)
-			}
-			if sample.Measurements.FC != 0 {
-				fc = fmt.Sprintf("%.2f", sample.Measurements.FC)
-			}
-			if sample.Measurements.TC != 0 {
-				tc = fmt.Sprintf("%.2f", sample.Measurements.TC)
-			}
-			if sample.Measurements.TA != 0 {
-				ta = fmt.Sprintf("%.2f", sample.Measurements.TA)
-			}
-			if sample.Measurements.CH != 0 {
-				ch = fmt.Sprintf("%.2f", sample.Measurements.CH)
-			}
-			if sample.Measurements.CYA != nil {
-				cya = fmt.Sprintf("%.2f", *sample.Measurements.CYA)
-			}
-			if sample.Measurements.Temperature != 0 {
-				temp = fmt.Sprintf("%.1f", sample.Measurements.Temperature)
-			}
-			if sample.Measurements.Salinity != nil {
-				salinity = fmt.Sprintf("%.2f", *sample.Measurements.Salinity)
-			}
+	}
+}
+
+// ExportNDJSON streams one JSON object per sample and line
+func (h *Handlers) ExportNDJSON(c *gin.Context) {
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+
+	filename := export.NDJSONFilename(time.Now())
+	c.Header("Content-Type", export.NDJSONContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	if err := export.WriteNDJSON(h.db, c.Writer, filter); err != nil {
+		log.Printf("NDJSON export failed: %v", err)
+		// Once lines have been streamed the response can only be cut short
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
 		}
-		
-		if sample.Indices != nil {
-			if sample.Indices.LSI != nil {
-				lsi = fmt.Sprintf("%.2f", *sample.Indices.LSI)
-			}
-			if sample.Indices.RSI != nil {
-				rsi = fmt.Sprintf("%.2f", *sample.Indices.RSI)
-			}
+	}
+}
+
+// ExportLineProtocol streams samples as InfluxDB line protocol
+func (h *Handlers) ExportLineProtocol(c *gin.Context) {
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+
+	filename := export.LineProtocolFilename(time.Now())
+	c.Header("Content-Type", export.LineProtocolContentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+
+	if err := export.WriteLineProtocol(h.db, c.Writer, filter); err != nil {
+		log.Printf("Line protocol export failed: %v", err)
+		// Once lines have been streamed the response can only be cut short
+		if !c.Writer.Written() {
+			c.Header("Content-Type", "")
+			c.Header("Content-Disposition", "")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
 		}
-		
-		// Create CSV row
-		csvContent += fmt.Sprintf("%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,%s,\"%s\"\n",
-			date, poolName, ph, fc, tc, ta, ch, cya, temp, salinity, lsi, rsi, sample.Notes)
 	}
-	
-	// Set headers for file download
-	c.Header("Content-Type", "text/csv")
-	c.Header("Content-Disposition", "attachment; filename=waterlogger_export.csv")
-	c.String(http.StatusOK, csvContent)
+}
+
+// PushInflux writes the samples selected by the export filters to the
+// configured InfluxDB write endpoint, e.g. to backfill history
+func (h *Handlers) PushInflux(c *gin.Context) {
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+
+	writer, err := influx.NewWriter(h.cfg.Influx)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lines, err := writer.PushSamples(h.db, filter)
+	if err != nil {
+		log.Printf("InfluxDB push failed after %d lines: %v", lines, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "lines": lines})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"lines": lines})
 }
 
 func (h *Handlers) ExportBackup(c *gin.Context) {
+	// Backups keep stored values, so only the pool, date and sort filters apply
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+
 	// Get all data for backup
 	var users []models.User
 	var pools []models.Pool
@@ -826,7 +1105,7 @@ func (h *Handlers) ExportBackup(c *gin.Context) {
 		return
 	}
 	
-	if err := h.db.Find(&pools).Error; err != nil {
+	if err := h.db.Scopes(filter.Pools).Find(&pools).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pools"})
 		return
 	}
@@ -836,7 +1115,8 @@ func (h *Handlers) ExportBackup(c *gin.Context) {
 		return
 	}
 	
-	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").Find(&samples).Error; err != nil {
+	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").Preload("Additions").
+		Scopes(filter.Samples).Find(&samples).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch samples"})
 		return
 	}
@@ -858,120 +1138,72 @@ func (h *Handlers) ExportBackup(c *gin.Context) {
 		return
 	}
 	
+	// Compress and encrypt according to the backup configuration
+	archiveOpts := database.ArchiveOptionsFromConfig(h.cfg.Backup)
+	if c.Query("compress") == "true" {
+		archiveOpts.Compress = true
+	}
+	if h.cfg.Backup.Encrypt && !archiveOpts.Encrypted() {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Backup encryption is enabled but no passphrase is configured"})
+		return
+	}
+	
+	archive, err := database.EncodeArchive(jsonData, archiveOpts)
+	if err != nil {
+		log.Printf("Failed to encode backup archive: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate backup"})
+		return
+	}
+	
 	// Generate filename
-	filename := fmt.Sprintf("WL_backup_%s.json", time.Now().Format("20060102_150405"))
+	filename := fmt.Sprintf("WL_backup_%s%s", time.Now().Format("20060102_150405"), archiveOpts.Extension())
 	
 	// Set headers for file download
-	c.Header("Content-Type", "application/json")
+	c.Header("Content-Type", archiveOpts.ContentType())
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Header("Content-Length", fmt.Sprintf("%d", len(jsonData)))
+	c.Header("Content-Length", fmt.Sprintf("%d", len(archive)))
 	
 	// Send the backup file
-	c.Data(http.StatusOK, "application/json", jsonData)
+	c.Data(http.StatusOK, archiveOpts.ContentType(), archive)
 }
 
 func (h *Handlers) ExportMarkdown(c *gin.Context) {
-	// Get samples with related data
-	var samples []models.Sample
-	if err := h.db.Preload("Pool").Preload("Measurements").Preload("Indices").Find(&samples).Error; err != nil {
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := export.WriteMarkdown(h.db, &buf, filter); err != nil {
+		log.Printf("Markdown export failed: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch samples"})
 		return
 	}
-	
-	// Generate Markdown content
-	mdContent := "# Waterlogger Export\n\n"
-	mdContent += fmt.Sprintf("Generated on: %s\n\n", time.Now().Format("2006-01-02 15:04:05"))
-	
-	if len(samples) == 0 {
-		mdContent += "No samples found.\n"
-	} else {
-		mdContent += fmt.Sprintf("## Water Test Results (%d samples)\n\n", len(samples))
-		
-		for _, sample := range samples {
-			poolName := "Unknown Pool"
-			if sample.Pool != nil {
-				poolName = sample.Pool.Name
-			}
-			
-			mdContent += fmt.Sprintf("### %s - %s\n\n", poolName, sample.SampleDateTime.Format("2006-01-02 15:04:05"))
-			
-			if sample.Measurements != nil {
-				mdContent += "**Chemical Measurements:**\n"
-				if sample.Measurements.PH != 0 {
-					mdContent += fmt.Sprintf("- pH: %.2f\n", sample.Measurements.PH)
-				}
-				if sample.Measurements.FC != 0 {
-					mdContent += fmt.Sprintf("- Free Chlorine: %.2f ppm\n", sample.Measurements.FC)
-				}
-				if sample.Measurements.TC != 0 {
-					mdContent += fmt.Sprintf("- Total Chlorine: %.2f ppm\n", sample.Measurements.TC)
-				}
-				if sample.Measurements.TA != 0 {
-					mdContent += fmt.Sprintf("- Total Alkalinity: %.2f ppm\n", sample.Measurements.TA)
-				}
-				if sample.Measurements.CH != 0 {
-					mdContent += fmt.Sprintf("- Calcium Hardness: %.2f ppm\n", sample.Measurements.CH)
-				}
-				if sample.Measurements.CYA != nil {
-					mdContent += fmt.Sprintf("- Cyanuric Acid: %.2f ppm\n", *sample.Measurements.CYA)
-				}
-				if sample.Measurements.Temperature != 0 {
-					mdContent += fmt.Sprintf("- Temperature: %.1f°F\n", sample.Measurements.Temperature)
-				}
-				if sample.Measurements.Salinity != nil {
-					mdContent += fmt.Sprintf("- Salinity: %.2f ppm\n", *sample.Measurements.Salinity)
-				}
-				mdContent += "\n"
-			}
-			
-			if sample.Indices != nil {
-				mdContent += "**Water Balance Indices:**\n"
-				if sample.Indices.LSI != nil {
-					mdContent += fmt.Sprintf("- LSI (Langelier Saturation Index): %.2f\n", *sample.Indices.LSI)
-				}
-				if sample.Indices.RSI != nil {
-					mdContent += fmt.Sprintf("- RSI (Ryznar Stability Index): %.2f\n", *sample.Indices.RSI)
-				}
-				mdContent += "\n"
-			}
-			
-			if sample.Notes != "" {
-				mdContent += fmt.Sprintf("**Notes:** %s\n\n", sample.Notes)
-			}
-			
-			mdContent += "---\n\n"
-		}
+
+	filename := export.MarkdownFilename(time.Now())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Data(http.StatusOK, export.MarkdownContentType, buf.Bytes())
+}
+
+// exportFilter parses the export filter from the query string, writing a
+// 400 response when it is invalid. Without ?units= the user's preferred
+// unit system is used.
+func (h *Handlers) exportFilter(c *gin.Context) (export.Filter, bool) {
+	filter, err := export.ParseFilter(c.Request.URL.Query(), time.Now())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return export.Filter{}, false
 	}
-	
-	// Add appendices at the bottom
-	mdContent += "\n\n---\n\n# Appendices\n\n"
-	
-	// Read all markdown files from the appendices directory
-	appendicesDir := "appendices"
-	if files, err := ioutil.ReadDir(appendicesDir); err == nil {
-		// Sort files by name for consistent ordering
-		sort.Slice(files, func(i, j int) bool {
-			return files[i].Name() < files[j].Name()
-		})
-		
-		for _, file := range files {
-			if filepath.Ext(file.Name()) == ".md" {
-				filePath := filepath.Join(appendicesDir, file.Name())
-				if content, err := ioutil.ReadFile(filePath); err == nil {
-					mdContent += string(content) + "\n\n"
-				} else {
-					log.Printf("Warning: Failed to read appendix file %s: %v", filePath, err)
-				}
-			}
+
+	if filter.Units == "" {
+		var preferences models.UserPreferences
+		if userID, exists := c.Get("user_id"); exists &&
+			h.db.Where("user_id = ?", userID).First(&preferences).Error == nil {
+			filter.Units = chemistry.UnitSystem(preferences.UnitSystem)
 		}
-	} else {
-		log.Printf("Warning: Failed to read appendices directory: %v", err)
 	}
-	
-	// Set headers for file download
-	c.Header("Content-Type", "application/octet-stream")
-	c.Header("Content-Disposition", "attachment; filename=\"waterlogger_markdown.md\"")
-	c.String(http.StatusOK, mdContent)
+
+	return filter, true
 }
 
 func (h *Handlers) GetSettings(c *gin.Context) {
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"waterlogger/internal/importer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize is the largest file accepted by the import endpoints
+const maxImportSize = 20 << 20
+
+// GetImportFormats lists the file formats samples can be imported from
+func (h *Handlers) GetImportFormats(c *gin.Context) {
+	formats := make([]gin.H, 0)
+	for _, imp := range importer.Importers() {
+		formats = append(formats, gin.H{"name": imp.Name(), "description": imp.Description()})
+	}
+	c.JSON(http.StatusOK, gin.H{"formats": formats})
+}
+
+// ImportSamples imports samples from an uploaded file. The multipart form
+// holds the file, its format and an optional JSON column mapping;
+// ?dry_run=true only validates it and previews the samples it would import.
+// Any row error rejects the whole file with 422 and a row-by-row report.
+func (h *Handlers) ImportSamples(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A CSV file is required"})
+		return
+	}
+
+	imp, ok := importer.Lookup(c.PostForm("format"))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown import format: " + c.PostForm("format")})
+		return
+	}
+
+	var mapping importer.Mapping
+	if raw := c.PostForm("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid column mapping: " + err.Error()})
+			return
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read the uploaded file"})
+		return
+	}
+	defer file.Close()
+
+	result, err := importer.ImportFile(h.db, imp, file, mapping, importer.Options{
+		UserID: getUserID(c),
+		DryRun: c.Query("dry_run") == "true",
+	})
+	if err != nil {
+		// Row errors here are problems with the file or mapping as a whole
+		var fileErr importer.RowError
+		if errors.As(err, &fileErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fileErr.Error()})
+			return
+		}
+		log.Printf("Sample import failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import samples"})
+		return
+	}
+
+	if len(result.Errors) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
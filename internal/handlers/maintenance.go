@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"waterlogger/internal/maintenance"
+	"waterlogger/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func (h *Handlers) MaintenancePage(c *gin.Context) {
+	c.HTML(http.StatusOK, "maintenance.html", gin.H{
+		"title":     "Maintenance - Waterlogger",
+		"BuildTime": c.MustGet("BuildTime"),
+		"BuildDate": c.MustGet("BuildDate"),
+	})
+}
+
+// GetMaintenance returns the maintenance feed: every task with its last
+// completion and next due time, soonest due first. The pool_id query
+// parameter selects one pool and overdue=true only the overdue tasks.
+func (h *Handlers) GetMaintenance(c *gin.Context) {
+	var filter maintenance.Filter
+	if value := c.Query("pool_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool ID"})
+			return
+		}
+		filter.PoolID = uint(id)
+	}
+	filter.OverdueOnly = c.Query("overdue") == "true"
+
+	statuses, err := maintenance.Feed(h.db, filter, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to build maintenance feed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch maintenance tasks"})
+		return
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+// maintenanceTaskRequest is the body of maintenance task create and update
+// requests
+type maintenanceTaskRequest struct {
+	PoolID uint    `json:"pool_id"`
+	Kind   string  `json:"kind"`
+	Name   string  `json:"name"`
+	Every  int     `json:"every"`
+	Unit   string  `json:"unit"`
+	Notes  *string `json:"notes"`
+}
+
+// applyMaintenanceTaskRequest validates a request and copies it onto a task,
+// writing the error response when it is invalid. The name defaults to that
+// of the kind.
+func (h *Handlers) applyMaintenanceTaskRequest(c *gin.Context, req maintenanceTaskRequest, task *models.MaintenanceTask) bool {
+	var pools int64
+	if err := h.db.Model(&models.Pool{}).Where("id = ?", req.PoolID).Count(&pools).Error; err != nil {
+		log.Printf("Failed to check pool %d: %v", req.PoolID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check pool"})
+		return false
+	}
+	if pools == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Pool not found"})
+		return false
+	}
+
+	task.PoolID = req.PoolID
+	task.Kind = req.Kind
+	task.Name = strings.TrimSpace(req.Name)
+	if task.Name == "" {
+		task.Name = maintenance.KindName(req.Kind)
+	}
+	task.Every = req.Every
+	task.Unit = req.Unit
+	task.Notes = req.Notes
+	if err := maintenance.Check(*task); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// CreateMaintenanceTask adds a recurring maintenance task to a pool
+func (h *Handlers) CreateMaintenanceTask(c *gin.Context) {
+	var req maintenanceTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	var task models.MaintenanceTask
+	if !h.applyMaintenanceTaskRequest(c, req, &task) {
+		return
+	}
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Create(&task).Error; err != nil {
+		log.Printf("Failed to create maintenance task: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create maintenance task"})
+		return
+	}
+	c.JSON(http.StatusCreated, task)
+}
+
+// loadMaintenanceTask loads the maintenance task given by the id parameter,
+// writing the error response when it cannot
+func (h *Handlers) loadMaintenanceTask(c *gin.Context) (*models.MaintenanceTask, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid task ID"})
+		return nil, false
+	}
+
+	var task models.MaintenanceTask
+	if err := h.db.First(&task, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance task not found"})
+		} else {
+			log.Printf("Failed to fetch maintenance task %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch maintenance task"})
+		}
+		return nil, false
+	}
+	return &task, true
+}
+
+// UpdateMaintenanceTask replaces the settings of a maintenance task. Its
+// completions are kept.
+func (h *Handlers) UpdateMaintenanceTask(c *gin.Context) {
+	task, ok := h.loadMaintenanceTask(c)
+	if !ok {
+		return
+	}
+
+	var req maintenanceTaskRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if !h.applyMaintenanceTaskRequest(c, req, task) {
+		return
+	}
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Save(task).Error; err != nil {
+		log.Printf("Failed to update maintenance task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update maintenance task"})
+		return
+	}
+	c.JSON(http.StatusOK, task)
+}
+
+// DeleteMaintenanceTask permanently deletes a maintenance task and its
+// completions
+func (h *Handlers) DeleteMaintenanceTask(c *gin.Context) {
+	task, ok := h.loadMaintenanceTask(c)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("task_id = ?", task.ID).Delete(&models.MaintenanceCompletion{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(task).Error
+	})
+	if err != nil {
+		log.Printf("Failed to delete maintenance task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete maintenance task"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Maintenance task deleted successfully"})
+}
+
+// GetMaintenanceCompletions returns the completions of a maintenance task,
+// most recent first
+func (h *Handlers) GetMaintenanceCompletions(c *gin.Context) {
+	task, ok := h.loadMaintenanceTask(c)
+	if !ok {
+		return
+	}
+
+	var completions []models.MaintenanceCompletion
+	err := h.db.Where("task_id = ?", task.ID).
+		Order("completed_at DESC").Order("id DESC").Find(&completions).Error
+	if err != nil {
+		log.Printf("Failed to fetch completions of maintenance task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch maintenance completions"})
+		return
+	}
+	c.JSON(http.StatusOK, completions)
+}
+
+// CompleteMaintenanceTask records a maintenance task as done, at the given
+// time, the time of the linked sample or now
+func (h *Handlers) CompleteMaintenanceTask(c *gin.Context) {
+	task, ok := h.loadMaintenanceTask(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CompletedAt string  `json:"completed_at"`
+		SampleID    *uint   `json:"sample_id"`
+		Notes       *string `json:"notes"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	completion := models.MaintenanceCompletion{TaskID: task.ID, CompletedAt: now, SampleID: req.SampleID, Notes: req.Notes}
+	if req.SampleID != nil {
+		var sample models.Sample
+		if err := h.db.First(&sample, *req.SampleID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Sample not found"})
+			} else {
+				log.Printf("Failed to fetch sample %d: %v", *req.SampleID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sample"})
+			}
+			return
+		}
+		if sample.PoolID != task.PoolID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Sample is of a different pool than the task"})
+			return
+		}
+		completion.CompletedAt = sample.SampleDateTime
+	}
+	if req.CompletedAt != "" {
+		completedAt, err := models.ParseSampleDateTime(req.CompletedAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid completed_at. Use YYYY-MM-DDTHH:MM or RFC3339"})
+			return
+		}
+		completion.CompletedAt = completedAt
+	}
+	if completion.CompletedAt.After(now.Add(time.Minute)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "completed_at cannot be in the future"})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Create(&completion).Error; err != nil {
+		log.Printf("Failed to complete maintenance task %d: %v", task.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record maintenance completion"})
+		return
+	}
+	c.JSON(http.StatusCreated, completion)
+}
+
+// DeleteMaintenanceCompletion permanently deletes a completion recorded by
+// mistake
+func (h *Handlers) DeleteMaintenanceCompletion(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid completion ID"})
+		return
+	}
+
+	result := h.db.Unscoped().Delete(&models.MaintenanceCompletion{}, uint(id))
+	if result.Error != nil {
+		log.Printf("Failed to delete maintenance completion %d: %v", id, result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete maintenance completion"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance completion not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Maintenance completion deleted successfully"})
+}
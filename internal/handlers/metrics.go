@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"waterlogger/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics serves pool chemistry and application health in the Prometheus
+// text format. When metrics.token is set, requests must send it as a
+// bearer token.
+func (h *Handlers) Metrics(c *gin.Context) {
+	if token := h.cfg.Metrics.Token; token != "" {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="metrics"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "A valid metrics token is required"})
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf, h.db, time.Now()); err != nil {
+		log.Printf("Metrics failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to collect metrics"})
+		return
+	}
+	c.Data(http.StatusOK, metrics.ContentType, buf.Bytes())
+}
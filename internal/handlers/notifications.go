@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"waterlogger/internal/middleware"
+	"waterlogger/internal/models"
+	"waterlogger/internal/notify"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// loadNotificationPreferences returns the notification preferences of a
+// user, or the defaults when none are stored
+func (h *Handlers) loadNotificationPreferences(userID uint) (models.NotificationPreferences, error) {
+	var prefs models.NotificationPreferences
+	err := h.db.Where("user_id = ?", userID).First(&prefs).Error
+	if err == gorm.ErrRecordNotFound {
+		return models.NotificationPreferences{UserID: userID, AlertSeverity: notify.SeverityWarning}, nil
+	}
+	return prefs, err
+}
+
+// channelRequest is the body of notification channel create and update
+// requests. An empty token keeps the current one on update.
+type channelRequest struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	URL           string `json:"url"`
+	Token         string `json:"token"`
+	AlertSeverity string `json:"alert_severity"`
+	Active        *bool  `json:"active"`
+}
+
+// channelResponse is a notification channel as returned by the API, without
+// its token
+type channelResponse struct {
+	ID            uint      `json:"id"`
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	URL           string    `json:"url"`
+	HasToken      bool      `json:"has_token"`
+	AlertSeverity string    `json:"alert_severity"`
+	Active        bool      `json:"active"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func newChannelResponse(ch models.NotificationChannel) channelResponse {
+	return channelResponse{
+		ID:            ch.ID,
+		Name:          ch.Name,
+		Type:          ch.Type,
+		URL:           ch.URL,
+		HasToken:      ch.Token != "",
+		AlertSeverity: ch.AlertSeverity,
+		Active:        ch.Active,
+		CreatedAt:     ch.CreatedAt,
+		UpdatedAt:     ch.UpdatedAt,
+	}
+}
+
+// GetNotificationSettings returns the notification preferences and push
+// channels of the current user and whether email is configured
+func (h *Handlers) GetNotificationSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	prefs, err := h.loadNotificationPreferences(userID.(uint))
+	if err != nil {
+		log.Printf("Failed to load notification preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notification preferences"})
+		return
+	}
+
+	var channels []models.NotificationChannel
+	if err := h.db.Where("user_id = ?", userID).Order("name ASC").Find(&channels).Error; err != nil {
+		log.Printf("Failed to load notification channels: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notification channels"})
+		return
+	}
+	resp := make([]channelResponse, len(channels))
+	for i, ch := range channels {
+		resp[i] = newChannelResponse(ch)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"preferences":   prefs,
+		"channels":      resp,
+		"channel_types": notify.ChannelTypes,
+		"email_enabled": h.cfg.Email.Enabled,
+		"can_manage":    middleware.IsAdmin(c, h.db),
+	})
+}
+
+// UpdateNotificationSettings changes the notification preferences of the
+// current user. Fields left out keep their value.
+func (h *Handlers) UpdateNotificationSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req struct {
+		EmailAlerts   *bool  `json:"email_alerts"`
+		EmailDigest   *bool  `json:"email_digest"`
+		AlertSeverity string `json:"alert_severity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if req.AlertSeverity != "" && req.AlertSeverity != notify.SeverityWarning && req.AlertSeverity != notify.SeverityCritical {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert severity. Must be 'warning' or 'critical'"})
+		return
+	}
+
+	prefs, err := h.loadNotificationPreferences(userID.(uint))
+	if err != nil {
+		log.Printf("Failed to load notification preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load notification preferences"})
+		return
+	}
+	if req.EmailAlerts != nil {
+		prefs.EmailAlerts = *req.EmailAlerts
+	}
+	if req.EmailDigest != nil {
+		if *req.EmailDigest && !prefs.EmailDigest {
+			// Marking the digest as sent now, which is at or after the latest
+			// scheduled time, holds the first one back until the next
+			// scheduled time. Resubscribing also resets an old marker.
+			now := time.Now().UTC()
+			prefs.LastDigestAt = &now
+		}
+		prefs.EmailDigest = *req.EmailDigest
+	}
+	if req.AlertSeverity != "" {
+		prefs.AlertSeverity = req.AlertSeverity
+	}
+	if prefs.ID == 0 {
+		prefs.CreatedBy = userID.(uint)
+	}
+	prefs.UpdatedBy = userID.(uint)
+
+	if err := h.db.Save(&prefs).Error; err != nil {
+		log.Printf("Failed to save notification preferences: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification preferences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Notification settings updated successfully",
+		"preferences": prefs,
+	})
+}
+
+// SendDigestNow emails the weekly digest to the current user at once, to
+// check the email settings
+func (h *Handlers) SendDigestNow(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	if !h.cfg.Email.Enabled {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email is not enabled in config.yaml"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Preferences").First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return
+	}
+
+	digests, err := notify.NewDigests(h.db, h.cfg.Email)
+	if err != nil {
+		log.Printf("Invalid email configuration: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err := digests.SendTo(user, time.Now()); err != nil {
+		log.Printf("Failed to send digest to %s: %v", user.Email, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send digest: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Digest sent to " + user.Email})
+}
+
+// loadChannel loads the current user's notification channel given by the id
+// parameter, writing the error response when it cannot
+func (h *Handlers) loadChannel(c *gin.Context) (*models.NotificationChannel, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel ID"})
+		return nil, false
+	}
+
+	var ch models.NotificationChannel
+	if err := h.db.Where("user_id = ?", userID).First(&ch, uint(id)).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Notification channel not found"})
+		} else {
+			log.Printf("Failed to fetch notification channel %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification channel"})
+		}
+		return nil, false
+	}
+	return &ch, true
+}
+
+// applyChannelRequest validates a request and copies it onto a channel,
+// writing the error response when it is invalid
+func applyChannelRequest(c *gin.Context, req channelRequest, ch *models.NotificationChannel) bool {
+	ch.Name = strings.TrimSpace(req.Name)
+	ch.Type = strings.ToLower(strings.TrimSpace(req.Type))
+	ch.URL = strings.TrimSpace(req.URL)
+	if req.Token != "" {
+		ch.Token = req.Token
+	}
+	if req.AlertSeverity != "" {
+		ch.AlertSeverity = req.AlertSeverity
+	}
+	if req.Active != nil {
+		ch.Active = *req.Active
+	}
+
+	if ch.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Channel name is required"})
+		return false
+	}
+	if err := notify.CheckChannel(*ch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// CreateNotificationChannel adds a push channel for the current user
+func (h *Handlers) CreateNotificationChannel(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req channelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	ch := models.NotificationChannel{UserID: userID.(uint), AlertSeverity: notify.SeverityWarning, Active: true}
+	if !applyChannelRequest(c, req, &ch) {
+		return
+	}
+	ch.CreatedBy = userID.(uint)
+	ch.UpdatedBy = userID.(uint)
+	if err := h.db.Create(&ch).Error; err != nil {
+		log.Printf("Failed to create notification channel: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create notification channel"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newChannelResponse(ch))
+}
+
+// UpdateNotificationChannel replaces the settings of a push channel
+func (h *Handlers) UpdateNotificationChannel(c *gin.Context) {
+	ch, ok := h.loadChannel(c)
+	if !ok {
+		return
+	}
+
+	var req channelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+	if !applyChannelRequest(c, req, ch) {
+		return
+	}
+	ch.UpdatedBy = ch.UserID
+	if err := h.db.Save(ch).Error; err != nil {
+		log.Printf("Failed to update notification channel %d: %v", ch.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update notification channel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newChannelResponse(*ch))
+}
+
+// DeleteNotificationChannel permanently deletes a push channel
+func (h *Handlers) DeleteNotificationChannel(c *gin.Context) {
+	ch, ok := h.loadChannel(c)
+	if !ok {
+		return
+	}
+
+	// Channels do not go through the trash
+	if err := h.db.Unscoped().Delete(ch).Error; err != nil {
+		log.Printf("Failed to delete notification channel %d: %v", ch.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notification channel"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification channel deleted successfully"})
+}
+
+// TestNotificationChannel sends a test notification over a push channel,
+// whether or not it is active
+func (h *Handlers) TestNotificationChannel(c *gin.Context) {
+	ch, ok := h.loadChannel(c)
+	if !ok {
+		return
+	}
+
+	notifier, err := notify.ChannelNotifier(*ch)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = notifier.Notify(notify.Notification{
+		Title:    "Waterlogger test",
+		Text:     "Notifications from Waterlogger will arrive here.",
+		Severity: ch.AlertSeverity,
+	})
+	if err != nil {
+		log.Printf("Test notification over channel %d failed: %v", ch.ID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to send test notification: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Test notification sent to " + ch.Name})
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+	"waterlogger/internal/sensors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCalibrationDays is the longest history an ORP calibration may be
+// fitted from
+const maxCalibrationDays = 3650
+
+// GetORPCalibration returns the ORP calibration of a pool
+func (h *Handlers) GetORPCalibration(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	calibration, err := sensors.GetCalibration(h.db, pool.ID)
+	if errors.Is(err, sensors.ErrNotCalibrated) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pool has no ORP calibration"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to fetch ORP calibration: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch ORP calibration"})
+		return
+	}
+	c.JSON(http.StatusOK, calibration)
+}
+
+// CalibrateORP fits the ORP calibration of a pool from its manual FC tests
+// of the last days (90 by default) and the ORP recorded with or read near
+// them
+func (h *Handlers) CalibrateORP(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Days int `json:"days"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Days == 0 {
+		req.Days = sensors.DefaultCalibrationDays
+	}
+	if req.Days < 1 || req.Days > maxCalibrationDays {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 3650"})
+		return
+	}
+
+	now := time.Now().UTC()
+	calibration, points, err := sensors.Calibrate(h.db, pool.ID, now.AddDate(0, 0, -req.Days), now, getUserID(c))
+	if errors.Is(err, chemistry.ErrORPFit) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "points": points})
+		return
+	} else if err != nil {
+		log.Printf("Failed to calibrate ORP for pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calibrate ORP"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"calibration": calibration, "points": points})
+}
+
+// DeleteORPCalibration removes the ORP calibration of a pool
+func (h *Handlers) DeleteORPCalibration(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	result := h.db.Unscoped().Where("pool_id = ?", pool.ID).Delete(&models.ORPCalibration{})
+	if result.Error != nil {
+		log.Printf("Failed to delete ORP calibration: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete ORP calibration"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pool has no ORP calibration"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "ORP calibration deleted"})
+}
+
+// EstimateFC estimates the free chlorine of a pool from its latest ORP
+// reading, or from the orp and ph query parameters
+func (h *Handlers) EstimateFC(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	var orp, ph *float64
+	for name, target := range map[string]**float64{"orp": &orp, "ph": &ph} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+			return
+		}
+		if err := sensors.CheckValue(name, v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		*target = &v
+	}
+
+	estimate, err := sensors.EstimateFC(h.db, pool.ID, orp, ph, time.Now().UTC())
+	switch {
+	case errors.Is(err, sensors.ErrNotCalibrated):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pool has no ORP calibration"})
+	case errors.Is(err, sensors.ErrNoORP), errors.Is(err, sensors.ErrNoPH):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case err != nil:
+		log.Printf("Failed to estimate FC for pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to estimate free chlorine"})
+	default:
+		c.JSON(http.StatusOK, estimate)
+	}
+}
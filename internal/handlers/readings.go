@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"waterlogger/internal/models"
+	"waterlogger/internal/sensors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReadingsSize is the largest request body accepted by CreateReadings
+const maxReadingsSize = 4 << 20
+
+// Limits of GetReadings
+const (
+	defaultReadingsLimit = 1000
+	maxReadingsLimit     = 10000
+)
+
+// readingInput is a reading as sent by a probe or controller. The pool is
+// given by ID or name.
+type readingInput struct {
+	PoolID    uint       `json:"pool_id"`
+	Pool      string     `json:"pool"`
+	Parameter string     `json:"parameter"`
+	Value     *float64   `json:"value"`
+	Source    string     `json:"source"`
+	TakenAt   *time.Time `json:"taken_at"`
+}
+
+// CreateReadings stores a batch of sensor readings, sent as a JSON array or
+// as {"readings": [...]}. Any invalid reading rejects the whole batch with
+// 422 and the errors of every invalid reading.
+func (h *Handlers) CreateReadings(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxReadingsSize)
+
+	var body json.RawMessage
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var inputs []readingInput
+	var err error
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &inputs)
+	} else {
+		var batch struct {
+			Readings []readingInput `json:"readings"`
+		}
+		err = json.Unmarshal(trimmed, &batch)
+		inputs = batch.Readings
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(inputs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No readings to store"})
+		return
+	}
+	if len(inputs) > sensors.MaxBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d readings can be sent at once", sensors.MaxBatch)})
+		return
+	}
+
+	// Resolve pool names
+	poolIDs := make(map[string]uint)
+	for _, in := range inputs {
+		if in.PoolID == 0 && in.Pool != "" {
+			poolIDs[in.Pool] = 0
+		}
+	}
+	if len(poolIDs) > 0 {
+		names := make([]string, 0, len(poolIDs))
+		for name := range poolIDs {
+			names = append(names, name)
+		}
+		var pools []models.Pool
+		if err := h.db.Where("name IN ?", names).Find(&pools).Error; err != nil {
+			log.Printf("Failed to fetch pools for readings: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store readings"})
+			return
+		}
+		for _, pool := range pools {
+			poolIDs[pool.Name] = pool.ID
+		}
+	}
+
+	// Readings that cannot be built are left out of the batch check, and
+	// the errors of both are reported together
+	var invalid []sensors.ReadingError
+	readings := make([]models.SensorReading, 0, len(inputs))
+	indices := make([]int, 0, len(inputs))
+	for i, in := range inputs {
+		if in.Value == nil {
+			invalid = append(invalid, sensors.ReadingError{Index: i, Message: "value is required"})
+			continue
+		}
+		r := models.SensorReading{
+			PoolID:    in.PoolID,
+			Parameter: strings.ToLower(strings.TrimSpace(in.Parameter)),
+			Value:     *in.Value,
+			Source:    strings.TrimSpace(in.Source),
+		}
+		if r.PoolID == 0 && in.Pool != "" {
+			if r.PoolID = poolIDs[in.Pool]; r.PoolID == 0 {
+				invalid = append(invalid, sensors.ReadingError{Index: i, Message: fmt.Sprintf("pool %q not found", in.Pool)})
+				continue
+			}
+		}
+		if in.TakenAt != nil {
+			r.TakenAt = *in.TakenAt
+		}
+		readings = append(readings, r)
+		indices = append(indices, i)
+	}
+
+	checked, err := sensors.PrepareReadings(h.db, readings, time.Now())
+	if err != nil {
+		log.Printf("Failed to check readings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store readings"})
+		return
+	}
+	for _, e := range checked {
+		invalid = append(invalid, sensors.ReadingError{Index: indices[e.Index], Message: e.Message})
+	}
+	if len(invalid) > 0 {
+		sort.SliceStable(invalid, func(i, j int) bool { return invalid[i].Index < invalid[j].Index })
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid readings", "errors": invalid})
+		return
+	}
+
+	if err := sensors.StoreReadings(h.db, readings); err != nil {
+		log.Printf("Failed to store readings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store readings"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"stored": len(readings)})
+}
+
+// GetReadings lists sensor readings, newest first
+func (h *Handlers) GetReadings(c *gin.Context) {
+	var filter sensors.ReadingFilter
+	if poolID := c.Query("pool_id"); poolID != "" {
+		id, err := strconv.ParseUint(poolID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pool ID"})
+			return
+		}
+		filter.PoolID = uint(id)
+	}
+	if parameters := c.Query("parameters"); parameters != "" {
+		for _, p := range strings.Split(parameters, ",") {
+			filter.Parameters = append(filter.Parameters, strings.ToLower(strings.TrimSpace(p)))
+		}
+	}
+
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, _, err := parseChartDate(startDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date"})
+			return
+		}
+		filter.From = start
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := parseChartEndDate(endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date"})
+			return
+		}
+		filter.To = end
+	}
+
+	limit := defaultReadingsLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxReadingsLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxReadingsLimit)})
+			return
+		}
+		limit = n
+	}
+
+	readings, err := sensors.ListReadings(h.db, filter, limit)
+	if err != nil {
+		log.Printf("Failed to list readings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch readings"})
+		return
+	}
+	c.JSON(http.StatusOK, readings)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"waterlogger/internal/middleware"
+	"waterlogger/internal/models"
+	"waterlogger/internal/pdf"
+	"waterlogger/internal/report"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// reportTemplateRequest is the body of template create and update requests
+type reportTemplateRequest struct {
+	Name        string  `json:"name"`
+	Format      string  `json:"format"`
+	Description *string `json:"description"`
+	Content     string  `json:"content"`
+}
+
+// GetReportTemplates lists the built-in and custom report templates
+func (h *Handlers) GetReportTemplates(c *gin.Context) {
+	var custom []models.ReportTemplate
+	if err := h.db.Order("name ASC").Find(&custom).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report templates"})
+		return
+	}
+
+	templates := report.BuiltinTemplates()
+	for _, t := range custom {
+		templates = append(templates, report.FromModel(t))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"templates":  templates,
+		"can_manage": middleware.IsAdmin(c, h.db),
+	})
+}
+
+// CreateReportTemplate stores a custom report template
+func (h *Handlers) CreateReportTemplate(c *gin.Context) {
+	var req reportTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.validReportTemplate(c, req, 0) {
+		return
+	}
+
+	tmpl := models.ReportTemplate{
+		Name:        req.Name,
+		Format:      req.Format,
+		Description: req.Description,
+		Content:     req.Content,
+	}
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Create(&tmpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create report template"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, report.FromModel(tmpl))
+}
+
+// UpdateReportTemplate replaces a custom report template
+func (h *Handlers) UpdateReportTemplate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	var tmpl models.ReportTemplate
+	if err := h.db.First(&tmpl, uint(id)).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report template not found"})
+		return
+	}
+
+	var req reportTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.validReportTemplate(c, req, tmpl.ID) {
+		return
+	}
+
+	tmpl.Name = req.Name
+	tmpl.Format = req.Format
+	tmpl.Description = req.Description
+	tmpl.Content = req.Content
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Save(&tmpl).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update report template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report.FromModel(tmpl))
+}
+
+// DeleteReportTemplate permanently deletes a custom report template
+func (h *Handlers) DeleteReportTemplate(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	// Templates do not go through the trash, so the name can be reused at once
+	result := h.db.Unscoped().Delete(&models.ReportTemplate{}, uint(id))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete report template"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Report template not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Report template deleted successfully"})
+}
+
+// RenderReport renders a built-in or custom template against the report
+// data selected by the export filter parameters. ?download=true returns
+// the report as an attachment.
+func (h *Handlers) RenderReport(c *gin.Context) {
+	tmpl, ok := report.BuiltinTemplate(c.Param("template"))
+	if !ok {
+		var custom models.ReportTemplate
+		if err := h.db.Where("name = ?", c.Param("template")).First(&custom).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Report template not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report template"})
+			}
+			return
+		}
+		tmpl = report.FromModel(custom)
+	}
+
+	filter, ok := h.exportFilter(c)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	data, err := report.Build(h.db, filter, now)
+	if err != nil {
+		log.Printf("Report data failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build report"})
+		return
+	}
+
+	// Render fully first so that template errors are reported as JSON
+	var buf bytes.Buffer
+	if err := tmpl.Render(&buf, data); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("download") == "true" {
+		filename := fmt.Sprintf("WL%s_%s%s", now.Format("20060102_150405"), tmpl.Name, tmpl.Extension())
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	}
+	c.Data(http.StatusOK, tmpl.ContentType(), buf.Bytes())
+}
+
+// SampleReportPDF renders a one-page PDF service report of a sample.
+// ?paper=a4 selects A4 instead of US Letter, and ?download=true returns the
+// report as an attachment.
+func (h *Handlers) SampleReportPDF(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sample ID"})
+		return
+	}
+
+	size := pdf.Letter
+	switch c.DefaultQuery("paper", "letter") {
+	case "letter":
+	case "a4":
+		size = pdf.A4
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "paper must be letter or a4"})
+		return
+	}
+
+	var sample models.Sample
+	err = h.db.Preload("Pool").Preload("User").Preload("Kit").Preload("Measurements").Preload("Indices").
+		Preload("Additions", func(db *gorm.DB) *gorm.DB {
+			return db.Order("id ASC")
+		}).First(&sample, uint(id)).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Sample not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sample"})
+		}
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteServiceReport(&buf, &sample, size, time.Now()); err != nil {
+		log.Printf("Service report failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render report"})
+		return
+	}
+
+	disposition := "inline"
+	if c.Query("download") == "true" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("%s; filename=\"%s\"", disposition, report.ServiceReportFilename(&sample)))
+	c.Data(http.StatusOK, report.ServiceReportContentType, buf.Bytes())
+}
+
+// validReportTemplate validates a template request, writing an error
+// response when it is invalid. id is the template being updated, or 0.
+func (h *Handlers) validReportTemplate(c *gin.Context, req reportTemplateRequest, id uint) bool {
+	if !slices.Contains(report.Formats, req.Format) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": report.ErrUnknownFormat.Error()})
+		return false
+	}
+	if err := report.Validate(req.Name, req.Format, req.Content); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if _, builtin := report.BuiltinTemplate(req.Name); builtin {
+		c.JSON(http.StatusConflict, gin.H{"error": "A built-in template with this name already exists"})
+		return false
+	}
+	var count int64
+	if err := h.db.Model(&models.ReportTemplate{}).Where("name = ? AND id <> ?", req.Name, id).Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check report template name"})
+		return false
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A report template with this name already exists"})
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"waterlogger/internal/models"
+	"waterlogger/internal/schedule"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSchedules returns the schedule status of every pool with a test
+// schedule
+func (h *Handlers) GetSchedules(c *gin.Context) {
+	statuses, err := schedule.All(h.db, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to fetch test schedules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test schedules"})
+		return
+	}
+	if statuses == nil {
+		statuses = []schedule.Status{}
+	}
+	c.JSON(http.StatusOK, statuses)
+}
+
+// GetPoolSchedule returns the test schedule of a pool with its next due
+// time and whether it is overdue
+func (h *Handlers) GetPoolSchedule(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	status, err := schedule.ForPool(h.db, *pool, time.Now().UTC())
+	if errors.Is(err, schedule.ErrNoSchedule) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pool has no test schedule"})
+		return
+	} else if err != nil {
+		log.Printf("Failed to fetch test schedule of pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// SetPoolSchedule creates or replaces the test schedule of a pool
+func (h *Handlers) SetPoolSchedule(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Times      int    `json:"times"`
+		Period     string `json:"period"`
+		GraceHours int    `json:"grace_hours"`
+		Reminders  *bool  `json:"reminders"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format", "details": err.Error()})
+		return
+	}
+
+	var existing []models.TestSchedule
+	if err := h.db.Where("pool_id = ?", pool.ID).Limit(1).Find(&existing).Error; err != nil {
+		log.Printf("Failed to fetch test schedule of pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test schedule"})
+		return
+	}
+	s := models.TestSchedule{PoolID: pool.ID, Reminders: true}
+	if len(existing) > 0 {
+		s = existing[0]
+	}
+	s.Times = req.Times
+	s.Period = req.Period
+	s.GraceHours = req.GraceHours
+	if req.Reminders != nil {
+		s.Reminders = *req.Reminders
+	}
+	if err := schedule.Check(s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Save(&s).Error; err != nil {
+		log.Printf("Failed to save test schedule of pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save test schedule"})
+		return
+	}
+
+	status, err := schedule.Compute(h.db, *pool, s, time.Now().UTC())
+	if err != nil {
+		log.Printf("Failed to compute test schedule of pool %d: %v", pool.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch test schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// DeletePoolSchedule removes the test schedule of a pool
+func (h *Handlers) DeletePoolSchedule(c *gin.Context) {
+	pool, ok := h.loadPool(c)
+	if !ok {
+		return
+	}
+
+	result := h.db.Unscoped().Where("pool_id = ?", pool.ID).Delete(&models.TestSchedule{})
+	if result.Error != nil {
+		log.Printf("Failed to delete test schedule: %v", result.Error)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete test schedule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pool has no test schedule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Test schedule deleted"})
+}
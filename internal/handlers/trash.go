@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"waterlogger/internal/database"
+	"waterlogger/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// GetTrash lists deleted records, optionally filtered by ?type=
+func (h *Handlers) GetTrash(c *gin.Context) {
+	items, err := database.ListTrash(h.db, c.Query("type"))
+	if err != nil {
+		if errors.Is(err, database.ErrUnknownTrashType) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown trash type", "types": database.TrashTypes})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":          items,
+		"retention_days": h.cfg.Trash.RetentionDays,
+		"can_purge":      middleware.IsAdmin(c, h.db),
+	})
+}
+
+// RestoreTrashItem restores a deleted record and the rows deleted with it
+func (h *Handlers) RestoreTrashItem(c *gin.Context) {
+	typ, id, ok := trashItemParams(c)
+	if !ok {
+		return
+	}
+
+	if err := database.RestoreFromTrash(h.db, typ, id); err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotInTrash), errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+		case errors.Is(err, database.ErrParentInTrash):
+			c.JSON(http.StatusConflict, gin.H{"error": "The pool of this sample is in the trash; restore the pool first"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore item"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item restored successfully"})
+}
+
+// PurgeTrashItem permanently deletes a single record from the trash
+func (h *Handlers) PurgeTrashItem(c *gin.Context) {
+	typ, id, ok := trashItemParams(c)
+	if !ok {
+		return
+	}
+
+	if err := database.PurgeFromTrash(h.db, typ, id); err != nil {
+		switch {
+		case errors.Is(err, database.ErrNotInTrash):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in trash"})
+		case errors.Is(err, database.ErrStillReferenced):
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot purge an item that is still used by samples"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge item"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Item permanently deleted"})
+}
+
+// EmptyTrash permanently deletes everything in the trash, optionally
+// filtered by ?type=
+func (h *Handlers) EmptyTrash(c *gin.Context) {
+	typ := c.Query("type")
+	if typ != "" && !database.IsTrashType(typ) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown trash type", "types": database.TrashTypes})
+		return
+	}
+
+	purged, err := database.PurgeTrash(h.db, typ, time.Now().UTC())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to empty trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Trash emptied", "purged": purged})
+}
+
+// trashItemParams parses the :type and :id route parameters, writing an
+// error response when either is invalid
+func trashItemParams(c *gin.Context) (string, uint, bool) {
+	typ := c.Param("type")
+	if !database.IsTrashType(typ) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown trash type", "types": database.TrashTypes})
+		return "", 0, false
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return "", 0, false
+	}
+
+	return typ, uint(id), true
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"waterlogger/internal/models"
+	"waterlogger/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Limits of GetWebhookDeliveries
+const (
+	defaultDeliveriesLimit = 100
+	maxDeliveriesLimit     = 1000
+)
+
+// webhookRequest is the body of webhook create and update requests. An
+// empty secret is generated on create and kept on update.
+type webhookRequest struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// webhookResponse is a webhook as returned by the API. The secret is only
+// included when it is created or changed.
+type webhookResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newWebhookResponse(hook models.Webhook, withSecret bool) webhookResponse {
+	resp := webhookResponse{
+		ID:        hook.ID,
+		Name:      hook.Name,
+		URL:       hook.URL,
+		Events:    webhooks.EventList(hook.Events),
+		Active:    hook.Active,
+		CreatedAt: hook.CreatedAt,
+		UpdatedAt: hook.UpdatedAt,
+	}
+	if withSecret {
+		resp.Secret = hook.Secret
+	}
+	return resp
+}
+
+// applyWebhookRequest validates a request and copies it onto a webhook,
+// writing the error response when it is invalid
+func applyWebhookRequest(c *gin.Context, req webhookRequest, hook *models.Webhook) bool {
+	req.Name = strings.TrimSpace(req.Name)
+	req.URL = strings.TrimSpace(req.URL)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Webhook name is required"})
+		return false
+	}
+	if err := webhooks.CheckURL(req.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	events, err := webhooks.ParseEvents(req.Events)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	hook.Name = req.Name
+	hook.URL = req.URL
+	hook.Events = events
+	if req.Secret != "" {
+		hook.Secret = req.Secret
+	}
+	if req.Active != nil {
+		hook.Active = *req.Active
+	}
+	return true
+}
+
+// loadWebhook loads the webhook given by the id parameter, writing the
+// error response when it cannot
+func (h *Handlers) loadWebhook(c *gin.Context) (*models.Webhook, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return nil, false
+	}
+
+	var hook models.Webhook
+	if err := h.db.First(&hook, uint(id)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		} else {
+			log.Printf("Failed to fetch webhook %d: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook"})
+		}
+		return nil, false
+	}
+	return &hook, true
+}
+
+// GetWebhooks lists the webhooks and the events they can subscribe to
+func (h *Handlers) GetWebhooks(c *gin.Context) {
+	var hooks []models.Webhook
+	if err := h.db.Order("name ASC").Find(&hooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+		return
+	}
+
+	resp := make([]webhookResponse, len(hooks))
+	for i, hook := range hooks {
+		resp[i] = newWebhookResponse(hook, false)
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": resp, "events": webhooks.Events})
+}
+
+// CreateWebhook registers a webhook and returns it with its secret
+func (h *Handlers) CreateWebhook(c *gin.Context) {
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hook := models.Webhook{Active: true}
+	if !applyWebhookRequest(c, req, &hook) {
+		return
+	}
+	if hook.Secret == "" {
+		secret, err := webhooks.NewSecret()
+		if err != nil {
+			log.Printf("Failed to generate webhook secret: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+			return
+		}
+		hook.Secret = secret
+	}
+
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Create(&hook).Error; err != nil {
+		log.Printf("Failed to create webhook: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newWebhookResponse(hook, true))
+}
+
+// UpdateWebhook replaces the settings of a webhook
+func (h *Handlers) UpdateWebhook(c *gin.Context) {
+	hook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req webhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !applyWebhookRequest(c, req, hook) {
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), "user_id", getUserID(c))
+	if err := h.db.WithContext(ctx).Save(hook).Error; err != nil {
+		log.Printf("Failed to update webhook %d: %v", hook.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newWebhookResponse(*hook, req.Secret != ""))
+}
+
+// DeleteWebhook permanently deletes a webhook and its delivery log
+func (h *Handlers) DeleteWebhook(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return
+	}
+
+	deleted, err := webhooks.DeleteWebhook(h.db, uint(id))
+	if err != nil {
+		log.Printf("Failed to delete webhook %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted"})
+}
+
+// TestWebhook queues a ping event for a webhook, whether or not it is
+// active
+func (h *Handlers) TestWebhook(c *gin.Context) {
+	hook, ok := h.loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := webhooks.EnqueueFor(h.db, *hook, webhooks.EventPing, gin.H{
+		"webhook_id": hook.ID,
+		"message":    "Test delivery from Waterlogger",
+	})
+	if err != nil {
+		log.Printf("Failed to queue test delivery: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue test delivery"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// GetWebhookDeliveries lists the delivery log, newest first
+func (h *Handlers) GetWebhookDeliveries(c *gin.Context) {
+	query := h.db.Model(&models.WebhookDelivery{})
+	if webhookID := c.Query("webhook_id"); webhookID != "" {
+		id, err := strconv.ParseUint(webhookID, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+			return
+		}
+		query = query.Where("webhook_id = ?", uint(id))
+	}
+	if status := c.Query("status"); status != "" {
+		if status != webhooks.StatusPending && status != webhooks.StatusDelivered && status != webhooks.StatusFailed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, delivered or failed"})
+			return
+		}
+		query = query.Where("status = ?", status)
+	}
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+
+	limit := defaultDeliveriesLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveriesLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDeliveriesLimit)})
+			return
+		}
+		limit = n
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	if err := query.Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		log.Printf("Failed to list webhook deliveries: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook deliveries"})
+		return
+	}
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// RetryWebhookDelivery queues a delivered or failed delivery to be sent
+// again
+func (h *Handlers) RetryWebhookDelivery(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	if err := h.db.First(&delivery, uint(id)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery"})
+		}
+		return
+	}
+	if delivery.Status == webhooks.StatusPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Delivery is already queued"})
+		return
+	}
+
+	if err := webhooks.Retry(h.db, &delivery); err != nil {
+		log.Printf("Failed to retry webhook delivery %d: %v", delivery.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry delivery"})
+		return
+	}
+	c.JSON(http.StatusAccepted, delivery)
+}
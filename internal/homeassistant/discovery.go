@@ -0,0 +1,250 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/config"
+	"waterlogger/internal/models"
+	"waterlogger/internal/mqtt"
+)
+
+// deviceClasses are the Home Assistant device classes of parameters that
+// have one
+var deviceClasses = map[string]string{
+	"temperature": "temperature",
+	"orp":         "voltage",
+}
+
+// Publisher publishes the state of every pool to MQTT, with discovery
+// configs that make each parameter a Home Assistant sensor
+type Publisher struct {
+	db  *gorm.DB
+	cfg config.MQTTConfig
+
+	// configs are the discovery configs published so far by topic, so
+	// unchanged configs are not sent again and sensors of removed pools and
+	// parameters can be cleared
+	configs map[string]string
+}
+
+// NewPublisher checks the configuration and fills in defaults
+func NewPublisher(db *gorm.DB, cfg config.MQTTConfig) (*Publisher, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("no MQTT broker is configured")
+	}
+	ha := &cfg.HomeAssistant
+	if ha.DiscoveryPrefix == "" {
+		ha.DiscoveryPrefix = "homeassistant"
+	}
+	if ha.StatePrefix == "" {
+		ha.StatePrefix = "waterlogger"
+	}
+	if ha.IntervalSeconds <= 0 {
+		ha.IntervalSeconds = 60
+	}
+	ha.DiscoveryPrefix = strings.TrimSuffix(ha.DiscoveryPrefix, "/")
+	ha.StatePrefix = strings.TrimSuffix(ha.StatePrefix, "/")
+	if strings.ContainsAny(ha.DiscoveryPrefix+ha.StatePrefix, "+#") {
+		return nil, errors.New("MQTT topic prefixes cannot contain wildcards")
+	}
+	if cfg.ClientID == "" {
+		cfg.ClientID = "waterlogger"
+	}
+	return &Publisher{db: db, cfg: cfg, configs: make(map[string]string)}, nil
+}
+
+// availabilityTopic is retained as online while connected, and set to
+// offline by the broker when the connection is lost
+func (p *Publisher) availabilityTopic() string {
+	return p.cfg.HomeAssistant.StatePrefix + "/status"
+}
+
+func (p *Publisher) stateTopic(poolID uint, key string) string {
+	return fmt.Sprintf("%s/pool/%d/%s", p.cfg.HomeAssistant.StatePrefix, poolID, key)
+}
+
+func (p *Publisher) configTopic(poolID uint, key string) string {
+	return fmt.Sprintf("%s/sensor/waterlogger_pool_%d/%s/config", p.cfg.HomeAssistant.DiscoveryPrefix, poolID, key)
+}
+
+// Messages returns the retained messages describing every pool: the states
+// of each recorded parameter, the pool status and the last test time, with
+// discovery configs that are new or changed. Sensors published before whose
+// pool or parameter is gone get an empty config, which removes them from
+// Home Assistant.
+func (p *Publisher) Messages(now time.Time) ([]mqtt.Message, error) {
+	var pools []models.Pool
+	if err := p.db.Order("id ASC").Find(&pools).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch pools: %w", err)
+	}
+
+	var messages []mqtt.Message
+	current := make(map[string]bool)
+	add := func(pool models.Pool, key string, sensor map[string]interface{}, state string) error {
+		sensor["unique_id"] = fmt.Sprintf("waterlogger_pool_%d_%s", pool.ID, key)
+		sensor["state_topic"] = p.stateTopic(pool.ID, key)
+		sensor["availability_topic"] = p.availabilityTopic()
+		sensor["device"] = map[string]interface{}{
+			"identifiers":  []string{"waterlogger_pool_" + strconv.FormatUint(uint64(pool.ID), 10)},
+			"name":         pool.Name,
+			"manufacturer": "Waterlogger",
+			"model":        poolModel(pool.Type),
+		}
+		payload, err := json.Marshal(sensor)
+		if err != nil {
+			return err
+		}
+		topic := p.configTopic(pool.ID, key)
+		current[topic] = true
+		if p.configs[topic] != string(payload) {
+			messages = append(messages, mqtt.Message{Topic: topic, Payload: payload, Retain: true})
+		}
+		messages = append(messages, mqtt.Message{Topic: p.stateTopic(pool.ID, key), Payload: []byte(state), Retain: true})
+		return nil
+	}
+
+	names := chemistry.GetParameterNames()
+	for _, pool := range pools {
+		state, err := State(p.db, pool, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, parameter := range Parameters {
+			value, ok := state.Values[parameter]
+			if !ok {
+				continue
+			}
+			sensor := map[string]interface{}{
+				"name":        names[parameter],
+				"state_class": "measurement",
+			}
+			if value.Unit != "" {
+				sensor["unit_of_measurement"] = value.Unit
+			}
+			if class, ok := deviceClasses[parameter]; ok {
+				sensor["device_class"] = class
+			}
+			if err := add(pool, parameter, sensor, strconv.FormatFloat(value.Value, 'f', -1, 64)); err != nil {
+				return nil, err
+			}
+		}
+
+		status := map[string]interface{}{
+			"name":         "Status",
+			"device_class": "enum",
+			"options":      []string{StatusOK, StatusWarning, StatusCritical, StatusUnknown},
+			"icon":         "mdi:pool",
+		}
+		if err := add(pool, "status", status, state.Status); err != nil {
+			return nil, err
+		}
+		if state.LastTested != nil {
+			lastTested := map[string]interface{}{"name": "Last Tested", "device_class": "timestamp"}
+			if err := add(pool, "last_tested", lastTested, state.LastTested.Format(time.RFC3339)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for topic := range p.configs {
+		if !current[topic] {
+			messages = append(messages, mqtt.Message{Topic: topic, Payload: []byte{}, Retain: true})
+		}
+	}
+	return messages, nil
+}
+
+// poolModel names the kind of pool as the Home Assistant device model
+func poolModel(poolType string) string {
+	if poolType == "hot_tub" {
+		return "Hot Tub"
+	}
+	return "Pool"
+}
+
+// publish publishes messages and records the discovery topics
+func (p *Publisher) publish(client *mqtt.Client, messages []mqtt.Message) error {
+	for _, msg := range messages {
+		if err := client.Publish(msg.Topic, msg.Payload, msg.Retain); err != nil {
+			return err
+		}
+		if strings.HasSuffix(msg.Topic, "/config") {
+			if len(msg.Payload) == 0 {
+				delete(p.configs, msg.Topic)
+			} else {
+				p.configs[msg.Topic] = string(msg.Payload)
+			}
+		}
+	}
+	return nil
+}
+
+// Run connects to the broker and publishes every interval, reconnecting
+// with increasing delays when the connection fails. It never returns.
+func (p *Publisher) Run() {
+	interval := time.Duration(p.cfg.HomeAssistant.IntervalSeconds) * time.Second
+	opts := mqtt.Options{
+		Broker:   p.cfg.Broker,
+		ClientID: p.cfg.ClientID + "-homeassistant",
+		Username: p.cfg.Username,
+		Password: p.cfg.Password,
+		Will:     &mqtt.Message{Topic: p.availabilityTopic(), Payload: []byte("offline"), Retain: true},
+	}
+	mqtt.RunWithReconnect(opts, "Home Assistant discovery", func(client *mqtt.Client) error {
+		return p.serve(client, interval)
+	})
+}
+
+// serve publishes on a connection until it fails. Listen runs alongside
+// to keep the connection alive and notice when it drops.
+func (p *Publisher) serve(client *mqtt.Client, interval time.Duration) error {
+	listening := make(chan error, 1)
+	go func() { listening <- client.Listen(func(mqtt.Message) {}) }()
+
+	if err := client.Publish(p.availabilityTopic(), []byte("online"), true); err != nil {
+		return err
+	}
+	// The broker may have lost retained configs while disconnected, so
+	// they are sent again, and remain known for clearing
+	for topic := range p.configs {
+		p.configs[topic] = ""
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Database errors skip an update, and publish errors mean the
+		// connection is lost
+		if messages, err := p.Messages(time.Now()); err != nil {
+			log.Printf("Home Assistant state update failed: %v", err)
+		} else if err := p.publish(client, messages); err != nil {
+			return err
+		}
+		select {
+		case err := <-listening:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// StartDiscovery publishes pools to Home Assistant in the background when
+// it is enabled
+func StartDiscovery(db *gorm.DB, cfg config.MQTTConfig) error {
+	if !cfg.HomeAssistant.Enabled {
+		return nil
+	}
+	p, err := NewPublisher(db, cfg)
+	if err != nil {
+		return err
+	}
+	go p.Run()
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package homeassistant summarizes the state of each pool for Home
+// Assistant, through a REST endpoint and MQTT discovery.
+package homeassistant
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+	"waterlogger/internal/sensors"
+)
+
+// Parameters are the parameters reported in a pool state, in display order
+var Parameters = []string{"fc", "tc", "ph", "ta", "ch", "cya", "temperature", "salinity", "tds", "orp", "lsi", "rsi"}
+
+// Pool statuses, from the worst parameter
+const (
+	StatusOK       = "ok"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+	StatusUnknown  = "unknown" // nothing recorded yet
+)
+
+// Value is the latest value of a parameter of a pool
+type Value struct {
+	Value     float64   `json:"value"`
+	Unit      string    `json:"unit"`
+	Status    string    `json:"status"` // ok, low or high
+	Severity  string    `json:"severity,omitempty"`
+	Source    string    `json:"source"` // sample or reading
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PoolState is the latest chemistry of a pool
+type PoolState struct {
+	PoolID         uint              `json:"pool_id"`
+	Pool           string            `json:"pool"`
+	Type           string            `json:"type"`
+	Status         string            `json:"status"`
+	LastTested     *time.Time        `json:"last_tested"`
+	HoursSinceTest *float64          `json:"hours_since_test"`
+	Values         map[string]Value  `json:"values"`
+	Alerts         []chemistry.Alert `json:"alerts"`
+}
+
+// State returns the latest value of every recorded parameter of a pool,
+// from samples or sensor readings, with its status against the ideal
+// ranges
+func State(db *gorm.DB, pool models.Pool, now time.Time) (*PoolState, error) {
+	state := &PoolState{
+		PoolID: pool.ID,
+		Pool:   pool.Name,
+		Type:   pool.Type,
+		Status: StatusUnknown,
+		Values: make(map[string]Value),
+		Alerts: []chemistry.Alert{},
+	}
+
+	var times []time.Time
+	err := db.Model(&models.Sample{}).Where("pool_id = ?", pool.ID).
+		Order("sample_date_time DESC").Limit(1).Pluck("sample_date_time", &times).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the latest sample: %w", err)
+	}
+	if len(times) > 0 {
+		tested := times[0].UTC()
+		hours := math.Round(now.Sub(tested).Hours()*10) / 10
+		state.LastTested = &tested
+		state.HoursSinceTest = &hours
+	}
+
+	for _, parameter := range Parameters {
+		latest, ok, err := sensors.LatestValue(db, pool.ID, parameter, now)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		value := Value{
+			Value:     latest.Value,
+			Unit:      chemistry.ParameterUnit(parameter),
+			Status:    StatusOK,
+			Source:    latest.Source,
+			UpdatedAt: latest.At.UTC(),
+		}
+		if state.Status == StatusUnknown {
+			state.Status = StatusOK
+		}
+		if alert, ok := chemistry.CheckValue(parameter, latest.Value); ok {
+			value.Status = alert.Status
+			value.Severity = alert.Severity
+			state.Alerts = append(state.Alerts, alert)
+			if alert.Severity == chemistry.SeverityCritical {
+				state.Status = StatusCritical
+			} else if state.Status != StatusCritical {
+				state.Status = StatusWarning
+			}
+		}
+		state.Values[parameter] = value
+	}
+	return state, nil
+}
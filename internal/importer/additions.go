@@ -0,0 +1,90 @@
+package importer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Addition is a chemical added to the water, as read from an import file
+type Addition struct {
+	Chemical string  `json:"chemical"`
+	Amount   float64 `json:"amount"`
+	Unit     string  `json:"unit,omitempty"`
+}
+
+// additionUnits are the units that mark a column as a chemical addition,
+// e.g. "Liquid Chlorine (gal)"
+var additionUnits = map[string]string{
+	"gal": "gal", "gallon": "gal", "gallons": "gal",
+	"qt": "qt", "quart": "qt", "quarts": "qt",
+	"fl oz": "fl oz", "floz": "fl oz", "oz": "oz", "ounces": "oz",
+	"lb": "lb", "lbs": "lb", "pounds": "lb",
+	"l": "L", "liter": "L", "liters": "L", "ml": "mL",
+	"kg": "kg", "g": "g", "cups": "cups", "cup": "cups",
+	"tabs": "tabs", "tablets": "tabs",
+}
+
+var (
+	// additionPattern splits "Liquid chlorine 32 fl oz" at its last number
+	additionPattern = regexp.MustCompile(`^(.*\S)\s+([0-9][0-9.,]*)\s*([^0-9]*)$`)
+	// amountPattern splits "32 fl oz" into the amount and the unit
+	amountPattern = regexp.MustCompile(`^([0-9][0-9.,]*)\s*([^0-9]*)$`)
+	// unitColumn finds the unit of a column header, e.g. "(gal)"
+	unitColumn = regexp.MustCompile(`^(.*\S)\s*\(([^)]+)\)\s*$`)
+)
+
+// parseAdditions parses additions in the form of the CSV export, e.g.
+// "Liquid chlorine 32 fl oz; Muriatic acid 8 fl oz"
+func parseAdditions(s string, decimalComma bool) ([]Addition, error) {
+	var additions []Addition
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		match := additionPattern.FindStringSubmatch(part)
+		if match == nil {
+			return nil, fmt.Errorf("%q has no amount", part)
+		}
+		amount, err := parseNumber(match[2], decimalComma)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", match[2])
+		}
+		additions = append(additions, Addition{Chemical: match[1], Amount: amount, Unit: normalizeUnit(match[3])})
+	}
+	return additions, nil
+}
+
+// parseAmount parses an amount with an optional unit, e.g. "2 lb"
+func parseAmount(s string, decimalComma bool) (float64, string, error) {
+	match := amountPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if match == nil {
+		return 0, "", fmt.Errorf("%q is not an amount", s)
+	}
+	amount, err := parseNumber(match[1], decimalComma)
+	if err != nil {
+		return 0, "", fmt.Errorf("%q is not a number", match[1])
+	}
+	return amount, normalizeUnit(match[2]), nil
+}
+
+// chemicalColumn returns the chemical and unit of a header such as
+// "Liquid Chlorine (gal)", or false when the unit is not an amount
+func chemicalColumn(header string) (string, string, bool) {
+	match := unitColumn.FindStringSubmatch(strings.TrimSpace(header))
+	if match == nil {
+		return "", "", false
+	}
+	unit, ok := additionUnits[strings.ToLower(strings.TrimSpace(match[2]))]
+	return match[1], unit, ok
+}
+
+// normalizeUnit spells common units the way the app does, and keeps others
+func normalizeUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if u, ok := additionUnits[strings.ToLower(unit)]; ok {
+		return u
+	}
+	return unit
+}
@@ -0,0 +1,505 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/export"
+	"waterlogger/internal/models"
+)
+
+// Fields that CSV columns can be mapped to
+const (
+	FieldDateTime    = "sample_datetime"
+	FieldPool        = "pool"
+	FieldPoolVolume  = "pool_volume"
+	FieldKit         = "kit"
+	FieldUser        = "user"
+	FieldAppearance  = "appearance"
+	FieldMaintenance = "maintenance"
+	FieldNotes       = "notes"
+	// FieldTime is a time of day for files with separate date and time
+	// columns
+	FieldTime = "time"
+	// FieldCombinedChlorine sets total chlorine to FC + CC when no total
+	// chlorine is given
+	FieldCombinedChlorine = "cc"
+	// FieldAdditions lists chemicals added, e.g. "Liquid chlorine 32 fl oz;
+	// Muriatic acid 8 fl oz", or names a single chemical whose amount is in
+	// FieldAdditionAmount
+	FieldAdditions      = "additions"
+	FieldAdditionAmount = "addition_amount"
+)
+
+// measurementFields are the numeric fields, which are also the parameter keys
+var measurementFields = []string{"fc", "tc", "ph", "ta", "ch", "cya", "temperature", "salinity", "tds", "orp"}
+
+// Fields lists every field CSV columns can be mapped to
+var Fields = []string{
+	FieldDateTime, FieldTime, FieldPool, FieldPoolVolume, FieldKit, FieldUser,
+	"fc", "tc", FieldCombinedChlorine, "ph", "ta", "ch", "cya", "temperature", "salinity", "tds", "orp",
+	FieldAppearance, FieldMaintenance, FieldAdditions, FieldAdditionAmount, FieldNotes,
+}
+
+// fieldAliases maps normalized column headers to fields, so that common
+// headers, including those of the CSV export, need no mapping
+var fieldAliases = map[string]string{
+	"sample datetime": FieldDateTime, "sample date": FieldDateTime, "sample time": FieldDateTime,
+	"datetime": FieldDateTime, "date time": FieldDateTime, "date": FieldDateTime, "timestamp": FieldDateTime,
+	"pool name": FieldPool, "pool volume": FieldPoolVolume, "volume": FieldPoolVolume,
+	"test kit": FieldKit, "username": FieldUser, "tester": FieldUser, "technician": FieldUser,
+	"comments": FieldNotes, "comment": FieldNotes,
+	"free chlorine": "fc", "total chlorine": "tc", "total alkalinity": "ta", "alkalinity": "ta",
+	"calcium hardness": "ch", "calcium": "ch", "cyanuric acid": "cya", "stabilizer": "cya",
+	"temp": "temperature", "water temperature": "temperature", "salt": "salinity",
+	"total dissolved solids": "tds", "oxidation reduction potential": "orp", "combined chlorine": FieldCombinedChlorine,
+	"time of day": FieldTime, "chemicals added": FieldAdditions, "chemical added": FieldAdditions,
+	"chemicals": FieldAdditions, "amount added": FieldAdditionAmount, "amount": FieldAdditionAmount,
+}
+
+// dateLayouts are tried after models.SampleDateTimeLayouts. Layouts with
+// slashes are month first unless the mapping sets DayFirst.
+var (
+	dateLayouts = []string{
+		"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+		"2006/1/2 15:04:05", "2006/1/2 15:04", "2006/1/2",
+	}
+	monthFirstLayouts = []string{"1/2/2006 15:04:05", "1/2/2006 15:04", "1/2/2006 3:04 PM", "1/2/2006 3:04PM", "1/2/2006"}
+	dayFirstLayouts   = []string{"2/1/2006 15:04:05", "2/1/2006 15:04", "2/1/2006", "2.1.2006 15:04", "2.1.2006"}
+)
+
+// Mapping describes how the columns of a CSV file map to sample fields
+type Mapping struct {
+	// Columns maps fields to column headers. Columns whose header matches a
+	// field name or a common alias are mapped automatically.
+	Columns map[string]string `json:"columns,omitempty" yaml:"columns"`
+	// Pool, Kit and User apply to rows without a value in their column
+	Pool string `json:"pool,omitempty" yaml:"pool"`
+	Kit  string `json:"kit,omitempty" yaml:"kit"`
+	User string `json:"user,omitempty" yaml:"user"`
+	// DateFormat is a Go time layout for the date column, e.g.
+	// "02.01.2006 15:04". Without it common formats are recognized.
+	DateFormat string `json:"date_format,omitempty" yaml:"date_format"`
+	// DayFirst reads 03/04/2024 as 3 April rather than March 4
+	DayFirst bool `json:"day_first,omitempty" yaml:"day_first"`
+	// Celsius and Liters convert temperatures and pool volumes to stored
+	// units. They are also set by °C and (L) in the column header.
+	Celsius bool `json:"celsius,omitempty" yaml:"celsius"`
+	Liters  bool `json:"liters,omitempty" yaml:"liters"`
+	// CreatePools creates pools named in the file that do not exist
+	CreatePools bool `json:"create_pools,omitempty" yaml:"create_pools"`
+	// Delimiter and Decimal select the CSV dialect as for the CSV export
+	Delimiter string `json:"delimiter,omitempty" yaml:"delimiter"`
+	Decimal   string `json:"decimal,omitempty" yaml:"decimal"`
+}
+
+// csvOptions returns the CSV dialect of the mapping
+func (m Mapping) csvOptions() (export.CSVOptions, error) {
+	return export.ParseCSVOptions(url.Values{"delimiter": {m.Delimiter}, "decimal": {m.Decimal}})
+}
+
+var (
+	headerNoise   = regexp.MustCompile(`\([^)]*\)`)
+	headerSpacing = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// normalizeHeader lowercases a header and drops its unit, e.g.
+// "Free Chlorine (ppm)" becomes "free chlorine"
+func normalizeHeader(header string) string {
+	header = headerNoise.ReplaceAllString(strings.ToLower(header), " ")
+	return strings.TrimSpace(headerSpacing.ReplaceAllString(header, " "))
+}
+
+// columns returns the column index of each mapped field. Headers are
+// looked up in aliases before fieldAliases.
+func (m Mapping) columns(header []string, aliases map[string]string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, h := range header {
+		name := normalizeHeader(h)
+		field, ok := aliases[name]
+		if !ok {
+			field, ok = fieldAliases[name]
+		}
+		if !ok {
+			field = strings.ReplaceAll(name, " ", "_")
+		}
+		if _, taken := columns[field]; !taken && isField(field) {
+			columns[field] = i
+		}
+	}
+
+	for field, column := range m.Columns {
+		if !isField(field) {
+			return nil, fileError(fmt.Sprintf("unknown field %q in the column mapping", field))
+		}
+		found := false
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(column)) {
+				columns[field], found = i, true
+				break
+			}
+		}
+		if !found {
+			return nil, fileError(fmt.Sprintf("column %q mapped to %s is not in the file", column, field))
+		}
+	}
+
+	if _, ok := columns[FieldDateTime]; !ok {
+		return nil, fileError("no column is mapped to sample_datetime")
+	}
+	if _, ok := columns[FieldPool]; !ok && m.Pool == "" {
+		return nil, fileError("no column is mapped to pool and no default pool is set")
+	}
+	return columns, nil
+}
+
+func isField(field string) bool {
+	for _, f := range Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// csvFormat is an importer for CSV files with a header row. Formats differ
+// in the headers they recognize and in how they record chemical additions.
+type csvFormat struct {
+	name        string
+	description string
+	// aliases maps normalized headers to fields, on top of fieldAliases
+	aliases map[string]string
+	// chemicalColumns reads unmapped columns with an amount unit in the
+	// header, e.g. "Liquid Chlorine (gal)", as additions of that chemical
+	chemicalColumns bool
+	// mergeAdditions adds rows that only record additions to the test of
+	// the same pool before them
+	mergeAdditions bool
+}
+
+// chemical is a column of additions of one chemical
+type chemical struct {
+	column   int
+	chemical string
+	unit     string
+}
+
+// mergeWindow is how long after a test additions-only rows are merged into it
+const mergeWindow = 24 * time.Hour
+
+var genericCSV = csvFormat{
+	name:        DefaultFormat,
+	description: "Waterlogger CSV export or any spreadsheet with a header row",
+}
+
+func init() {
+	Register(genericCSV)
+}
+
+func (f csvFormat) Name() string        { return f.name }
+func (f csvFormat) Description() string { return f.description }
+
+// ReadCSV reads records from a CSV file in the generic format
+func ReadCSV(r io.Reader, m Mapping) ([]Record, []RowError, error) {
+	return genericCSV.Read(r, m)
+}
+
+// Read reads records from a CSV file with a header row
+func (f csvFormat) Read(r io.Reader, m Mapping) ([]Record, []RowError, error) {
+	opts, err := m.csvOptions()
+	if err != nil {
+		return nil, nil, fileError(err.Error())
+	}
+
+	// Spreadsheets often save UTF-8 with a byte order mark
+	br := bufio.NewReader(r)
+	if bom, err := br.Peek(3); err == nil && string(bom) == "\xef\xbb\xbf" {
+		br.Discard(3)
+	}
+
+	cr := csv.NewReader(br)
+	if opts.Delimiter != 0 {
+		cr.Comma = opts.Delimiter
+	}
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, fileError("the file is empty")
+	}
+	if err != nil {
+		return nil, nil, fileError("failed to read the header: " + err.Error())
+	}
+	columns, err := m.columns(header, f.aliases)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var chemicals []chemical
+	if f.chemicalColumns {
+		mapped := make(map[int]bool)
+		for _, i := range columns {
+			mapped[i] = true
+		}
+		for i, h := range header {
+			if name, unit, ok := chemicalColumn(h); ok && !mapped[i] {
+				chemicals = append(chemicals, chemical{column: i, chemical: name, unit: unit})
+			}
+		}
+	}
+
+	celsius := m.Celsius
+	if i, ok := columns["temperature"]; ok && strings.Contains(header[i], "°C") {
+		celsius = true
+	}
+	liters := m.Liters
+	if i, ok := columns[FieldPoolVolume]; ok && strings.Contains(strings.ToLower(header[i]), "(l)") {
+		liters = true
+	}
+
+	var records []Record
+	var errs []RowError
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				errs = append(errs, RowError{Row: parseErr.StartLine, Message: parseErr.Err.Error()})
+				continue
+			}
+			return nil, nil, fileError("failed to read the file: " + err.Error())
+		}
+		if blank(row) {
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+
+		value := func(field string) string {
+			if i, ok := columns[field]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		fail := func(field, format string, args ...interface{}) {
+			errs = append(errs, RowError{Row: line, Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+
+		rec := Record{
+			Row:         line,
+			Pool:        value(FieldPool),
+			Kit:         value(FieldKit),
+			User:        value(FieldUser),
+			Values:      make(map[string]float64),
+			Appearance:  value(FieldAppearance),
+			Maintenance: value(FieldMaintenance),
+			Notes:       value(FieldNotes),
+		}
+		if rec.Pool == "" {
+			rec.Pool = m.Pool
+		}
+		if rec.Kit == "" {
+			rec.Kit = m.Kit
+		}
+		if rec.User == "" {
+			rec.User = m.User
+		}
+
+		ok := true
+		if s := value(FieldDateTime); s != "" {
+			if rec.Time, err = m.parseTime(s); err != nil {
+				fail(FieldDateTime, "unrecognized date %q", s)
+				ok = false
+			}
+		}
+		if s := value(FieldTime); s != "" && !rec.Time.IsZero() {
+			if rec.Time, err = withTimeOfDay(rec.Time, s); err != nil {
+				fail(FieldTime, "unrecognized time %q", s)
+				ok = false
+			}
+		}
+
+		for _, parameter := range measurementFields {
+			s := value(parameter)
+			if s == "" {
+				continue
+			}
+			v, err := parseNumber(s, opts.DecimalComma)
+			if err != nil {
+				fail(parameter, "%q is not a number", s)
+				ok = false
+				continue
+			}
+			if parameter == "temperature" && celsius {
+				v = chemistry.CelsiusToFahrenheit(v)
+			}
+			// Zero means not recorded for the required measurements
+			if v != 0 || !models.RequiredMeasurement(parameter) {
+				rec.Values[parameter] = v
+			}
+		}
+		if s := value(FieldCombinedChlorine); s != "" {
+			cc, err := parseNumber(s, opts.DecimalComma)
+			if err != nil {
+				fail(FieldCombinedChlorine, "%q is not a number", s)
+				ok = false
+			} else if fc, hasFC := rec.Values["fc"]; hasFC && rec.Values["tc"] == 0 {
+				rec.Values["tc"] = fc + cc
+			}
+		}
+		if s := value(FieldPoolVolume); s != "" {
+			v, err := parseNumber(s, opts.DecimalComma)
+			if err != nil {
+				fail(FieldPoolVolume, "%q is not a number", s)
+				ok = false
+			} else {
+				if liters {
+					v = chemistry.ConvertVolume(v, chemistry.Metric).Converted
+				}
+				rec.PoolVolume = &v
+			}
+		}
+
+		if s := value(FieldAdditions); s != "" {
+			if amount := value(FieldAdditionAmount); amount != "" {
+				v, unit, err := parseAmount(amount, opts.DecimalComma)
+				if err != nil {
+					fail(FieldAdditionAmount, "%v", err)
+					ok = false
+				} else {
+					rec.Additions = append(rec.Additions, Addition{Chemical: s, Amount: v, Unit: unit})
+				}
+			} else if additions, err := parseAdditions(s, opts.DecimalComma); err != nil {
+				fail(FieldAdditions, "%v", err)
+				ok = false
+			} else {
+				rec.Additions = append(rec.Additions, additions...)
+			}
+		}
+		for _, c := range chemicals {
+			if c.column >= len(row) || strings.TrimSpace(row[c.column]) == "" {
+				continue
+			}
+			s := strings.TrimSpace(row[c.column])
+			v, err := parseNumber(s, opts.DecimalComma)
+			if err != nil {
+				fail(header[c.column], "%q is not a number", s)
+				ok = false
+				continue
+			}
+			if v != 0 {
+				rec.Additions = append(rec.Additions, Addition{Chemical: c.chemical, Amount: v, Unit: c.unit})
+			}
+		}
+
+		if ok {
+			records = append(records, rec)
+		}
+	}
+
+	if f.mergeAdditions {
+		records = mergeAdditions(records)
+	}
+	return records, errs, nil
+}
+
+// mergeAdditions adds records that only hold additions and notes to the
+// latest record of the same pool up to mergeWindow before them. Apps that
+// log each chemical as its own entry export them as rows without tests.
+func mergeAdditions(records []Record) []Record {
+	merged := make([]Record, 0, len(records))
+	for _, rec := range records {
+		if rec.hasTest() || len(rec.Additions) == 0 || rec.Time.IsZero() {
+			merged = append(merged, rec)
+			continue
+		}
+
+		target := -1
+		for i := len(merged) - 1; i >= 0; i-- {
+			prev := merged[i]
+			if prev.Pool == rec.Pool && prev.hasTest() && !prev.Time.After(rec.Time) && rec.Time.Sub(prev.Time) <= mergeWindow {
+				if target < 0 || prev.Time.After(merged[target].Time) {
+					target = i
+				}
+			}
+		}
+		if target < 0 {
+			merged = append(merged, rec)
+			continue
+		}
+		merged[target].Additions = append(merged[target].Additions, rec.Additions...)
+		merged[target].merged += 1 + rec.merged
+		if rec.Notes != "" {
+			merged[target].Notes = strings.TrimSpace(merged[target].Notes + "\n" + rec.Notes)
+		}
+	}
+	return merged
+}
+
+// timeOfDayLayouts are the layouts of FieldTime
+var timeOfDayLayouts = []string{"15:04:05", "15:04", "3:04:05 PM", "3:04 PM", "3:04PM", "3PM", "3 PM"}
+
+// withTimeOfDay sets the time of day of a date
+func withTimeOfDay(date time.Time, s string) (time.Time, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	var err error
+	for _, layout := range timeOfDayLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			y, mo, d := date.Date()
+			return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), 0, date.Location()), nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseTime parses a date in the mapping's format, or in any recognized format
+func (m Mapping) parseTime(s string) (time.Time, error) {
+	if m.DateFormat != "" {
+		return time.Parse(m.DateFormat, s)
+	}
+	if t, err := models.ParseSampleDateTime(s); err == nil {
+		return t, nil
+	}
+	layouts := append(append([]string(nil), dateLayouts...), monthFirstLayouts...)
+	if m.DayFirst {
+		layouts = append(append([]string(nil), dateLayouts...), dayFirstLayouts...)
+	}
+	var err error
+	for _, layout := range layouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// parseNumber parses a decimal number, with a decimal comma if requested.
+// Thousands separators are not accepted.
+func parseNumber(s string, decimalComma bool) (float64, error) {
+	if decimalComma {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func blank(row []string) bool {
+	for _, field := range row {
+		if strings.TrimSpace(field) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"gorm.io/gorm"
+)
+
+// Importer reads the export format of a pool log, such as the spreadsheet
+// of another app, into records
+type Importer interface {
+	// Name identifies the format in the API and on the command line
+	Name() string
+	// Description says which files the format reads
+	Description() string
+	// Read reads the records of a file. Values that cannot be read are
+	// reported as row errors; when the file or the mapping cannot be used
+	// at all the error is a RowError for row 0.
+	Read(r io.Reader, m Mapping) ([]Record, []RowError, error)
+}
+
+// DefaultFormat is the format used when none is given
+const DefaultFormat = "csv"
+
+var importers = make(map[string]Importer)
+
+// Register makes an importer available by name. It panics when the name is
+// taken, as registration happens at startup.
+func Register(imp Importer) {
+	if _, taken := importers[imp.Name()]; taken {
+		panic(fmt.Sprintf("importer: format %q registered twice", imp.Name()))
+	}
+	importers[imp.Name()] = imp
+}
+
+// Lookup returns the importer of a format, or DefaultFormat when the name
+// is empty
+func Lookup(name string) (Importer, bool) {
+	if name == "" {
+		name = DefaultFormat
+	}
+	imp, ok := importers[name]
+	return imp, ok
+}
+
+// Importers returns the registered importers, the default first and the
+// rest by name
+func Importers() []Importer {
+	list := make([]Importer, 0, len(importers))
+	for _, imp := range importers {
+		list = append(list, imp)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if (list[i].Name() == DefaultFormat) != (list[j].Name() == DefaultFormat) {
+			return list[i].Name() == DefaultFormat
+		}
+		return list[i].Name() < list[j].Name()
+	})
+	return list
+}
+
+// ImportFile reads a file with an importer and imports its records. Every
+// row is checked, and nothing is imported when any row has a problem.
+func ImportFile(db *gorm.DB, imp Importer, r io.Reader, m Mapping, opts Options) (*Result, error) {
+	records, errs, err := imp.Read(r, m)
+	if err != nil {
+		return nil, err
+	}
+
+	check := opts
+	check.CreatePools = opts.CreatePools || m.CreatePools
+	if len(errs) > 0 {
+		check.DryRun = true
+	}
+	result, err := Import(db, records, check)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows that could not be read count towards the rows of the file
+	unread := make(map[int]bool)
+	for _, e := range errs {
+		unread[e.Row] = true
+	}
+	result.Rows += len(unread)
+	result.DryRun = opts.DryRun
+	if !opts.DryRun {
+		result.Preview = nil
+	}
+	result.Errors = append(errs, result.Errors...)
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	return result, nil
+}
@@ -0,0 +1,445 @@
+// Package importer imports historical samples from files.
+//
+// Importers read the file formats of pool logs, such as the CSV export or
+// the logs of other apps, into Records with values in stored units. Import then
+// matches the records to pools, kits and users, validates them and writes
+// them in a single transaction: either every record is imported or none is.
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// DefaultKitName is the kit of imported samples that do not name one
+const DefaultKitName = "Imported"
+
+// Record is one sample read from an import file. Values are in stored units
+// (°F, gallons) and only hold the parameters that were recorded.
+type Record struct {
+	Row         int                `json:"row"` // Line of the record in the file
+	Time        time.Time          `json:"sample_datetime"`
+	Pool        string             `json:"pool"` // Pool name or ID
+	PoolVolume  *float64           `json:"pool_volume,omitempty"`
+	Kit         string             `json:"kit,omitempty"`  // Kit name; empty for DefaultKitName
+	User        string             `json:"user,omitempty"` // Username; empty for the importing user
+	Values      map[string]float64 `json:"values"`
+	Appearance  string             `json:"appearance,omitempty"`
+	Maintenance string             `json:"maintenance,omitempty"`
+	Additions   []Addition         `json:"additions,omitempty"`
+	Notes       string             `json:"notes,omitempty"`
+
+	merged int // Rows of the file merged into the record
+}
+
+// hasTest reports whether the record holds any measurement
+func (rec Record) hasTest() bool {
+	return len(rec.Values) > 0 || rec.Appearance != "" || rec.Maintenance != ""
+}
+
+// RowError reports a problem with one row of an import file. Row 0 is a
+// problem with the file as a whole.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	if e.Row == 0 {
+		return e.Message
+	}
+	if e.Field != "" {
+		return fmt.Sprintf("row %d, %s: %s", e.Row, e.Field, e.Message)
+	}
+	return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+}
+
+// fileError returns a RowError for the file as a whole
+func fileError(message string) RowError {
+	return RowError{Message: message}
+}
+
+// Result reports the outcome of an import. Nothing is imported when Errors
+// is not empty; in a dry run Imported counts the samples that would be.
+type Result struct {
+	Rows         int        `json:"rows"`
+	Imported     int        `json:"imported"`
+	Skipped      int        `json:"skipped"` // Samples that already exist
+	PoolsCreated []string   `json:"pools_created,omitempty"`
+	DryRun       bool       `json:"dry_run"`
+	Errors       []RowError `json:"errors,omitempty"`
+	// Preview holds the first PreviewLimit records a dry run would import
+	Preview []Record `json:"preview,omitempty"`
+}
+
+// PreviewLimit is the number of records in the preview of a dry run
+const PreviewLimit = 100
+
+// Options controls how records are imported
+type Options struct {
+	// UserID is the importing user, who owns records without a user
+	UserID uint
+	// CreatePools creates pools that do not exist instead of rejecting
+	// their rows
+	CreatePools bool
+	// DryRun validates the records without writing anything
+	DryRun bool
+}
+
+// Import validates the records and writes them as samples, with their
+// measurements and calculated indices. Samples that already exist for the
+// same pool and time are skipped. Problems with the records are reported in
+// the result; the error is only set when the database fails.
+func Import(db *gorm.DB, records []Record, opts Options) (*Result, error) {
+	result := &Result{Rows: len(records), DryRun: opts.DryRun}
+	for _, rec := range records {
+		result.Rows += rec.merged
+	}
+	r := &resolver{db: db, opts: opts, pools: map[string]*models.Pool{}, kits: map[string]uint{}, users: map[string]uint{}}
+
+	samples := make([]*models.Sample, 0, len(records))
+	var preview []Record
+	seen := make(map[string]bool)
+	for _, rec := range records {
+		sample, errs, err := r.sample(rec)
+		if err != nil {
+			return nil, err
+		}
+		if len(errs) > 0 {
+			result.Errors = append(result.Errors, errs...)
+			continue
+		}
+
+		// Skip samples already in the database or earlier in the file
+		key := fmt.Sprintf("%s@%d", sample.Pool.Name, sample.SampleDateTime.Unix())
+		exists := seen[key]
+		if !exists && sample.PoolID != 0 {
+			var count int64
+			if err := db.Model(&models.Sample{}).Where("pool_id = ? AND sample_date_time = ?", sample.PoolID, sample.SampleDateTime).Count(&count).Error; err != nil {
+				return nil, fmt.Errorf("failed to check for existing samples: %w", err)
+			}
+			exists = count > 0
+		}
+		seen[key] = true
+		if exists {
+			result.Skipped++
+			continue
+		}
+		samples = append(samples, sample)
+		if opts.DryRun && len(preview) < PreviewLimit {
+			preview = append(preview, rec)
+		}
+	}
+
+	for _, pool := range r.pending() {
+		result.PoolsCreated = append(result.PoolsCreated, pool.Name)
+	}
+
+	if opts.DryRun {
+		result.Preview = preview
+	}
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+	if opts.DryRun {
+		result.Imported = len(samples)
+		return result, nil
+	}
+
+	ctx := context.WithValue(context.Background(), "user_id", opts.UserID)
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := r.createPending(tx); err != nil {
+			return err
+		}
+		defaultKit, err := r.defaultKit(tx, samples)
+		if err != nil {
+			return err
+		}
+		for _, sample := range samples {
+			if sample.PoolID == 0 {
+				sample.PoolID = sample.Pool.ID
+			}
+			if sample.KitID == 0 {
+				sample.KitID = defaultKit
+			}
+			sample.Pool = nil
+			if err := tx.Create(sample).Error; err != nil {
+				return fmt.Errorf("failed to create sample: %w", err)
+			}
+			if sample.Measurements != nil && sample.Measurements.PH != 0 {
+				if indices, err := chemistry.CalculateIndices(sample.Measurements); err == nil {
+					indices.SampleID = sample.ID
+					if err := tx.Create(indices).Error; err != nil {
+						return fmt.Errorf("failed to create indices: %w", err)
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Imported = len(samples)
+	return result, nil
+}
+
+// resolver matches records to pools, kits and users, remembering what it
+// has looked up
+type resolver struct {
+	db    *gorm.DB
+	opts  Options
+	pools map[string]*models.Pool // By record pool; nil for unknown pools
+	kits  map[string]uint
+	users map[string]uint
+}
+
+// sample converts a record to a sample, or returns its problems
+func (r *resolver) sample(rec Record) (*models.Sample, []RowError, error) {
+	var errs []RowError
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, RowError{Row: rec.Row, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if rec.Time.IsZero() {
+		fail("sample_datetime", "sample date is missing")
+	}
+
+	pool, err := r.pool(rec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pool == nil {
+		if rec.Pool == "" {
+			fail("pool", "pool is missing")
+		} else {
+			fail("pool", "unknown pool %q", rec.Pool)
+		}
+	}
+
+	// Records without a kit get the default kit, which is created if needed
+	var kitID uint
+	if rec.Kit != "" {
+		if kitID, err = r.kit(rec.Kit); err != nil {
+			return nil, nil, err
+		}
+		if kitID == 0 {
+			fail("kit", "unknown kit %q", rec.Kit)
+		}
+	}
+
+	userID := r.opts.UserID
+	if rec.User != "" {
+		if userID, err = r.user(rec.User); err != nil {
+			return nil, nil, err
+		}
+		if userID == 0 {
+			fail("user", "unknown user %q", rec.User)
+		}
+	}
+
+	for _, parameter := range measurementFields {
+		value, ok := rec.Values[parameter]
+		if !ok {
+			continue
+		}
+		if min, max, ok := chemistry.ValidRange(parameter); ok && (value < min || value > max) {
+			unit := chemistry.ParameterUnit(parameter)
+			fail(parameter, "%s %s is outside the valid range %s", chemistry.GetParameterNames()[parameter],
+				withUnit(formatFloat(value), unit), withUnit(formatFloat(min)+" - "+formatFloat(max), unit))
+		}
+	}
+	if rec.PoolVolume != nil && *rec.PoolVolume <= 0 {
+		fail("pool_volume", "pool volume must be positive")
+	}
+	for _, a := range rec.Additions {
+		if a.Amount <= 0 {
+			fail("additions", "amount of %s must be positive", a.Chemical)
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs, nil
+	}
+
+	sample := &models.Sample{
+		PoolID:         pool.ID,
+		Pool:           pool,
+		SampleDateTime: rec.Time.UTC(),
+		UserID:         userID,
+		KitID:          kitID,
+		Notes:          rec.Notes,
+	}
+	for _, a := range rec.Additions {
+		sample.Additions = append(sample.Additions, models.Addition{Chemical: a.Chemical, Amount: a.Amount, Unit: a.Unit})
+	}
+	if rec.hasTest() {
+		m := &models.Measurements{
+			FC:          rec.Values["fc"],
+			TC:          rec.Values["tc"],
+			PH:          rec.Values["ph"],
+			TA:          rec.Values["ta"],
+			CH:          rec.Values["ch"],
+			Temperature: rec.Values["temperature"],
+			CYA:         optionalValue(rec.Values, "cya"),
+			Salinity:    optionalValue(rec.Values, "salinity"),
+			TDS:         optionalValue(rec.Values, "tds"),
+			ORP:         optionalValue(rec.Values, "orp"),
+			Appearance:  optionalText(rec.Appearance),
+			Maintenance: optionalText(rec.Maintenance),
+		}
+		sample.Measurements = m
+	}
+	return sample, nil, nil
+}
+
+// pool finds the pool of a record by name or ID. Unknown pools are set up
+// for creation when CreatePools is set, and nil otherwise or when a pool
+// with the name is in the trash.
+func (r *resolver) pool(rec Record) (*models.Pool, error) {
+	if rec.Pool == "" {
+		return nil, nil
+	}
+	if pool, ok := r.pools[rec.Pool]; ok {
+		return pool, nil
+	}
+
+	var pools []models.Pool
+	query := r.db.Where("name = ?", rec.Pool)
+	if id, err := strconv.ParseUint(rec.Pool, 10, 32); err == nil {
+		query = query.Or("id = ?", id)
+	}
+	if err := query.Order("id ASC").Limit(1).Find(&pools).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch pools: %w", err)
+	}
+
+	var pool *models.Pool
+	switch {
+	case len(pools) > 0:
+		pool = &pools[0]
+	case r.opts.CreatePools:
+		// Trashed pools keep their unique name until they are purged
+		var trashed int64
+		if err := r.db.Unscoped().Model(&models.Pool{}).Where("name = ? AND deleted_at IS NOT NULL", rec.Pool).Count(&trashed).Error; err != nil {
+			return nil, fmt.Errorf("failed to check pool name: %w", err)
+		}
+		if trashed == 0 {
+			pool = &models.Pool{Name: rec.Pool, Type: "pool", VolumeGallons: rec.PoolVolume}
+		}
+	}
+	r.pools[rec.Pool] = pool
+	return pool, nil
+}
+
+// pending returns the pools set up for creation, by name
+func (r *resolver) pending() []*models.Pool {
+	var pools []*models.Pool
+	for _, pool := range r.pools {
+		if pool != nil && pool.ID == 0 {
+			pools = append(pools, pool)
+		}
+	}
+	sort.Slice(pools, func(i, j int) bool { return pools[i].Name < pools[j].Name })
+	return pools
+}
+
+// createPending creates the pools set up for creation
+func (r *resolver) createPending(tx *gorm.DB) error {
+	for _, pool := range r.pending() {
+		if err := tx.Create(pool).Error; err != nil {
+			return fmt.Errorf("failed to create pool %s: %w", pool.Name, err)
+		}
+	}
+	return nil
+}
+
+// kit finds a kit by name, returning 0 when there is none
+func (r *resolver) kit(name string) (uint, error) {
+	if id, ok := r.kits[name]; ok {
+		return id, nil
+	}
+	var kits []models.Kit
+	if err := r.db.Where("name = ?", name).Order("id ASC").Limit(1).Find(&kits).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch kits: %w", err)
+	}
+	var id uint
+	if len(kits) > 0 {
+		id = kits[0].ID
+	}
+	r.kits[name] = id
+	return id, nil
+}
+
+// defaultKit returns the ID of DefaultKitName when a sample has no kit,
+// creating the kit on first use
+func (r *resolver) defaultKit(tx *gorm.DB, samples []*models.Sample) (uint, error) {
+	needed := false
+	for _, sample := range samples {
+		needed = needed || sample.KitID == 0
+	}
+	if !needed {
+		return 0, nil
+	}
+
+	var kit models.Kit
+	err := tx.Where("name = ?", DefaultKitName).Order("id ASC").First(&kit).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		description := "Samples imported from files"
+		kit = models.Kit{Name: DefaultKitName, Description: &description}
+		err = tx.Create(&kit).Error
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to set up kit %s: %w", DefaultKitName, err)
+	}
+	return kit.ID, nil
+}
+
+// user finds a user by username, returning 0 when there is none
+func (r *resolver) user(username string) (uint, error) {
+	if id, ok := r.users[username]; ok {
+		return id, nil
+	}
+	var users []models.User
+	if err := r.db.Where("username = ?", username).Limit(1).Find(&users).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch users: %w", err)
+	}
+	var id uint
+	if len(users) > 0 {
+		id = users[0].ID
+	}
+	r.users[username] = id
+	return id, nil
+}
+
+func optionalValue(values map[string]float64, parameter string) *float64 {
+	if value, ok := values[parameter]; ok {
+		return &value
+	}
+	return nil
+}
+
+func optionalText(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func withUnit(value, unit string) string {
+	return strings.TrimSpace(value + " " + unit)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,21 @@
+package importer
+
+// poolMath reads log exports in the style of Pool Math: one column per
+// test, combined chlorine rather than total chlorine, and one column per
+// chemical with its unit in the header, e.g. "Liquid Chlorine (gal)".
+// Chemicals are logged as entries of their own, so rows that only add
+// chemicals are merged into the test before them.
+var poolMath = csvFormat{
+	name:        "poolmath",
+	description: "Pool Math style logs with FC, CC, pH, TA, CH, CYA, Salt and Temp columns and one column per chemical, e.g. \"Liquid Chlorine (gal)\"",
+	aliases: map[string]string{
+		"log date": FieldDateTime, "log time": FieldDateTime,
+		"water temp": "temperature", "note": FieldNotes,
+	},
+	chemicalColumns: true,
+	mergeAdditions:  true,
+}
+
+func init() {
+	Register(poolMath)
+}
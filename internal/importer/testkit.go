@@ -0,0 +1,22 @@
+package importer
+
+// testKitLog reads the log sheets of test kit makers such as Taylor and
+// LaMotte: separate date and time columns, tests named in full, e.g.
+// "Free Chlorine" or LaMotte's "Chlorine, Free", a "Chemicals Added" column
+// with an optional "Amount" column, and the initials of the tester.
+var testKitLog = csvFormat{
+	name:        "testkit",
+	description: "Taylor or LaMotte style log sheets with Date and Time columns, tests named in full and Chemicals Added and Amount columns",
+	aliases: map[string]string{
+		"chlorine free": "fc", "chlorine total": "tc", "chlorine combined": FieldCombinedChlorine,
+		"hardness": "ch", "water temp": "temperature",
+		"time tested": FieldTime, "tested by": FieldUser,
+		"chemical": FieldAdditions, "chemical used": FieldAdditions, "treatment": FieldAdditions,
+		"quantity": FieldAdditionAmount, "qty": FieldAdditionAmount,
+		"remarks": FieldNotes, "observations": FieldNotes,
+	},
+}
+
+func init() {
+	Register(testKitLog)
+}
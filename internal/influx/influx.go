@@ -0,0 +1,228 @@
+// Package influx writes samples to an InfluxDB write endpoint as line
+// protocol, on request or in the background as they are logged or edited.
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/config"
+	"waterlogger/internal/export"
+	"waterlogger/internal/models"
+)
+
+// ErrNotConfigured is returned when no write endpoint is configured
+var ErrNotConfigured = errors.New("no InfluxDB write endpoint is configured")
+
+const (
+	// defaultInterval is the time between background pushes when the
+	// configuration does not set one
+	defaultInterval = time.Minute
+	// settleDelay is how long after its last change a sample is pushed, as
+	// its indices are written after the sample itself
+	settleDelay = 10 * time.Second
+	// pushPageSize is the number of changed samples sent per request
+	pushPageSize = 500
+	// maxErrorBody is how much of an error response is reported
+	maxErrorBody = 512
+)
+
+// Writer sends line protocol to an InfluxDB write endpoint
+type Writer struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewWriter returns a writer for the configured endpoint. The export
+// writes timestamps in seconds, so precision=s is added to the URL unless
+// it sets a precision.
+func NewWriter(cfg config.InfluxConfig) (*Writer, error) {
+	if cfg.URL == "" {
+		return nil, ErrNotConfigured
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid InfluxDB URL: %s", cfg.URL)
+	}
+	query := u.Query()
+	if query.Get("precision") == "" {
+		query.Set("precision", "s")
+		u.RawQuery = query.Encode()
+	}
+	return &Writer{
+		url:    u.String(),
+		token:  cfg.Token,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// Write posts lines of line protocol in one request
+func (w *Writer) Write(lines []byte) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", export.LineProtocolContentType)
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("InfluxDB write failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		return fmt.Errorf("InfluxDB write failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// PushSamples writes the samples selected by the filter, one request per
+// page of samples, and returns the number of lines written
+func (w *Writer) PushSamples(db *gorm.DB, f export.Filter) (int, error) {
+	bw := &batchWriter{writer: w}
+	if err := export.WriteLineProtocol(db, bw, f); err != nil {
+		return bw.lines, err
+	}
+	return bw.lines, bw.err
+}
+
+// batchWriter collects the lines of a page and writes them when the page
+// is flushed. After a failed write it rejects further lines.
+type batchWriter struct {
+	writer *Writer
+	buf    []byte
+	lines  int
+	err    error
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *batchWriter) Flush() {
+	if b.err != nil || len(b.buf) == 0 {
+		return
+	}
+	if b.err = b.writer.Write(b.buf); b.err == nil {
+		b.lines += bytes.Count(b.buf, []byte{'\n'})
+		b.buf = b.buf[:0]
+	}
+}
+
+// Pusher sends samples logged or edited after it was created to InfluxDB,
+// following their updated_at. An edited sample is sent again and
+// overwrites its points, as long as its time, pool and kit are unchanged.
+// Samples that fail to send are retried on the next push.
+type Pusher struct {
+	db       *gorm.DB
+	writer   *Writer
+	interval time.Duration
+	// lastUpdated and lastID are the last change sent, or present at
+	// startup; samples changed at the same time are sent in ID order
+	lastUpdated time.Time
+	lastID      uint
+}
+
+// NewPusher returns a pusher for the configured endpoint that starts after
+// the most recently changed sample in the database
+func NewPusher(db *gorm.DB, cfg config.InfluxConfig) (*Pusher, error) {
+	writer, err := NewWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pusher{db: db, writer: writer, interval: time.Duration(cfg.IntervalSeconds) * time.Second}
+	if p.interval <= 0 {
+		p.interval = defaultInterval
+	}
+	var latest []models.Sample
+	if err := db.Unscoped().Select("id", "updated_at").Order("updated_at DESC, id DESC").Limit(1).Find(&latest).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch the latest sample change: %w", err)
+	}
+	if len(latest) > 0 {
+		p.lastUpdated, p.lastID = latest[0].UpdatedAt, latest[0].ID
+	}
+	return p, nil
+}
+
+// PushNew sends the samples logged or edited since the last push and
+// returns how many were sent
+func (p *Pusher) PushNew() (int, error) {
+	pushed := 0
+	for {
+		var samples []models.Sample
+		err := p.db.Preload("Pool").Preload("Kit").Preload("Measurements").Preload("Indices").
+			Where("(updated_at > ? OR (updated_at = ? AND id > ?)) AND updated_at <= ?",
+				p.lastUpdated, p.lastUpdated, p.lastID, time.Now().Add(-settleDelay)).
+			Order("updated_at ASC, id ASC").Limit(pushPageSize).Find(&samples).Error
+		if err != nil {
+			return pushed, fmt.Errorf("failed to fetch samples: %w", err)
+		}
+		if len(samples) == 0 {
+			return pushed, nil
+		}
+
+		var lines []byte
+		for i := range samples {
+			lines = export.AppendLineProtocol(lines, &samples[i], export.Filter{})
+		}
+		if err := p.writer.Write(lines); err != nil {
+			return pushed, err
+		}
+		last := samples[len(samples)-1]
+		p.lastUpdated, p.lastID = last.UpdatedAt, last.ID
+		pushed += len(samples)
+
+		if len(samples) < pushPageSize {
+			return pushed, nil
+		}
+	}
+}
+
+// Start pushes new and edited samples periodically in the background
+func (p *Pusher) Start() {
+	go func() {
+		for {
+			time.Sleep(p.interval)
+			pushed, err := p.PushNew()
+			if err != nil {
+				log.Printf("InfluxDB push failed: %v", err)
+			} else if pushed > 0 {
+				log.Printf("InfluxDB push sent %d samples", pushed)
+			}
+		}
+	}()
+}
+
+// StartPusher starts pushing new and edited samples in the background when
+// push is enabled in the configuration
+func StartPusher(db *gorm.DB, cfg config.InfluxConfig) error {
+	if !cfg.Push {
+		return nil
+	}
+	p, err := NewPusher(db, cfg)
+	if err != nil {
+		return err
+	}
+	p.Start()
+	return nil
+}
@@ -0,0 +1,177 @@
+// Package maintenance tracks recurring pool maintenance tasks and when they
+// are next due.
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// Task kinds
+const (
+	KindFilterClean        = "filter_clean"
+	KindBackwash           = "backwash"
+	KindSaltCellInspection = "salt_cell_inspection"
+	KindDrainRefill        = "drain_refill"
+	KindOther              = "other"
+)
+
+// Kinds are the task kinds in display order
+var Kinds = []string{KindFilterClean, KindBackwash, KindSaltCellInspection, KindDrainRefill, KindOther}
+
+// kindNames are the default task names of the kinds
+var kindNames = map[string]string{
+	KindFilterClean:        "Clean filter",
+	KindBackwash:           "Backwash",
+	KindSaltCellInspection: "Inspect salt cell",
+	KindDrainRefill:        "Drain and refill",
+	KindOther:              "Maintenance",
+}
+
+// Recurrence units
+const (
+	UnitDay   = "day"
+	UnitWeek  = "week"
+	UnitMonth = "month"
+	UnitYear  = "year"
+)
+
+// maxEvery is the most units a task may recur after
+const maxEvery = 365
+
+// KindName returns the default name of a task kind
+func KindName(kind string) string {
+	return kindNames[kind]
+}
+
+// Check checks the kind, name and recurrence of a task
+func Check(task models.MaintenanceTask) error {
+	if _, ok := kindNames[task.Kind]; !ok {
+		return fmt.Errorf("kind must be one of %s", strings.Join(Kinds, ", "))
+	}
+	if strings.TrimSpace(task.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch task.Unit {
+	case UnitDay, UnitWeek, UnitMonth, UnitYear:
+	default:
+		return fmt.Errorf("unit must be day, week, month or year")
+	}
+	if task.Every < 1 || task.Every > maxEvery {
+		return fmt.Errorf("every must be between 1 and %d", maxEvery)
+	}
+	return nil
+}
+
+// Next returns when a task recurs after a time. Months and years follow the
+// calendar, so a monthly task done on the 15th is due on the 15th.
+func Next(task models.MaintenanceTask, after time.Time) time.Time {
+	switch task.Unit {
+	case UnitWeek:
+		return after.AddDate(0, 0, 7*task.Every)
+	case UnitMonth:
+		return after.AddDate(0, task.Every, 0)
+	case UnitYear:
+		return after.AddDate(task.Every, 0, 0)
+	}
+	return after.AddDate(0, 0, task.Every)
+}
+
+// Describe returns the recurrence of a task in words, e.g. "every 2 weeks"
+func Describe(task models.MaintenanceTask) string {
+	if task.Every == 1 {
+		return "every " + task.Unit
+	}
+	return fmt.Sprintf("every %d %ss", task.Every, task.Unit)
+}
+
+// Status is where a task stands against its recurrence
+type Status struct {
+	Task         models.MaintenanceTask `json:"task"`
+	Pool         string                 `json:"pool"`
+	Description  string                 `json:"description"`    // e.g. every 2 weeks
+	LastDone     *time.Time             `json:"last_done"`      // UTC, nil when never done
+	LastSampleID *uint                  `json:"last_sample_id"` // Sample of the latest completion
+	NextDue      time.Time              `json:"next_due"`       // UTC
+	Overdue      bool                   `json:"overdue"`
+	OverdueDays  int                    `json:"overdue_days"` // Whole days since the task was due, 0 unless overdue
+}
+
+// Filter selects the tasks of a feed
+type Filter struct {
+	PoolID      uint // 0 for every pool
+	OverdueOnly bool
+}
+
+// Feed returns the status of the tasks a filter selects, soonest due first.
+// Tasks never done are due from when they were created.
+func Feed(db *gorm.DB, filter Filter, now time.Time) ([]Status, error) {
+	now = now.UTC()
+	query := db.Preload("Pool")
+	if filter.PoolID != 0 {
+		query = query.Where("pool_id = ?", filter.PoolID)
+	}
+	var tasks []models.MaintenanceTask
+	if err := query.Find(&tasks).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch maintenance tasks: %w", err)
+	}
+	if len(tasks) == 0 {
+		return []Status{}, nil
+	}
+
+	ids := make([]uint, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+	latest, err := latestCompletions(db, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []Status{}
+	for _, task := range tasks {
+		status := Status{Task: task, Pool: task.Pool.Name, Description: Describe(task)}
+		due := task.CreatedAt
+		if c, ok := latest[task.ID]; ok {
+			done := c.CompletedAt.UTC()
+			status.LastDone = &done
+			status.LastSampleID = c.SampleID
+			due = Next(task, done)
+		}
+		status.NextDue = due.UTC()
+		if now.After(status.NextDue) {
+			status.Overdue = true
+			status.OverdueDays = int(now.Sub(status.NextDue).Hours() / 24)
+		}
+		if filter.OverdueOnly && !status.Overdue {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].NextDue.Before(statuses[j].NextDue)
+	})
+	return statuses, nil
+}
+
+// latestCompletions returns the latest completion of each of the tasks
+func latestCompletions(db *gorm.DB, taskIDs []uint) (map[uint]models.MaintenanceCompletion, error) {
+	var completions []models.MaintenanceCompletion
+	err := db.Where("task_id IN ?", taskIDs).
+		Order("completed_at DESC").Order("id DESC").Find(&completions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch maintenance completions: %w", err)
+	}
+	latest := make(map[uint]models.MaintenanceCompletion)
+	for _, c := range completions {
+		if _, ok := latest[c.TaskID]; !ok {
+			latest[c.TaskID] = c
+		}
+	}
+	return latest, nil
+}
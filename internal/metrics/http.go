@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// durationBuckets are the upper bounds of the request latency histogram, in
+// seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// requestKey identifies a route and the outcome of requests to it
+type requestKey struct {
+	method string
+	route  string
+	status string
+}
+
+// routeKey identifies a route
+type routeKey struct {
+	method string
+	route  string
+}
+
+// histogram counts request durations per bucket
+type histogram struct {
+	buckets []uint64 // Cumulative counts are computed when written
+	sum     float64
+	count   uint64
+}
+
+// httpRecorder holds the request counters and latencies recorded by Middleware
+type httpRecorder struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	durations map[routeKey]*histogram
+}
+
+var recorder = &httpRecorder{
+	requests:  make(map[requestKey]uint64),
+	durations: make(map[routeKey]*histogram),
+}
+
+// Middleware records the count and latency of every request by method,
+// route pattern and status. Requests that match no route share the route
+// "unmatched", so that arbitrary paths cannot grow the label set.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		recorder.observe(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+func (r *httpRecorder) observe(method, route string, status int, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests[requestKey{method, route, strconv.Itoa(status)}]++
+
+	key := routeKey{method, route}
+	h, ok := r.durations[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(durationBuckets))}
+		r.durations[key] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// write writes the request counters and latency histograms
+func (r *httpRecorder) write(e *encoder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	requests := make([]requestKey, 0, len(r.requests))
+	for key := range r.requests {
+		requests = append(requests, key)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	e.family("waterlogger_http_requests_total", "counter", "HTTP requests by method, route and status")
+	for _, key := range requests {
+		e.sample("waterlogger_http_requests_total", float64(r.requests[key]),
+			"method", key.method, "route", key.route, "status", key.status)
+	}
+
+	routes := make([]routeKey, 0, len(r.durations))
+	for key := range r.durations {
+		routes = append(routes, key)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].route != routes[j].route {
+			return routes[i].route < routes[j].route
+		}
+		return routes[i].method < routes[j].method
+	})
+	const name = "waterlogger_http_request_duration_seconds"
+	e.family(name, "histogram", "HTTP request latency by method and route")
+	for _, key := range routes {
+		h := r.durations[key]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += h.buckets[i]
+			e.sample(name+"_bucket", float64(cumulative), "method", key.method, "route", key.route, "le", formatValue(bound))
+		}
+		e.sample(name+"_bucket", float64(h.count), "method", key.method, "route", key.route, "le", "+Inf")
+		e.sample(name+"_sum", h.sum, "method", key.method, "route", key.route)
+		e.sample(name+"_count", float64(h.count), "method", key.method, "route", key.route)
+	}
+}
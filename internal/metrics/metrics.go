@@ -0,0 +1,154 @@
+// Package metrics exposes pool chemistry and application health in the
+// Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+	"waterlogger/internal/sensors"
+)
+
+// ContentType is the MIME type of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// poolParameters are the parameters whose latest value is exported per pool
+var poolParameters = []string{"fc", "ph", "ta", "ch", "cya", "temperature", "orp", "lsi", "rsi"}
+
+// Write writes the pool, database and HTTP metrics. Pool values are the
+// latest recorded value of each parameter, in stored units.
+func Write(w io.Writer, db *gorm.DB, now time.Time) error {
+	e := &encoder{w: bufio.NewWriter(w)}
+
+	var pools []models.Pool
+	if err := db.Order("id ASC").Find(&pools).Error; err != nil {
+		return fmt.Errorf("failed to fetch pools: %w", err)
+	}
+
+	e.family("waterlogger_pool_parameter", "gauge", "Latest recorded value of a parameter per pool, in stored units")
+	for _, pool := range pools {
+		id := strconv.FormatUint(uint64(pool.ID), 10)
+		for _, p := range poolParameters {
+			latest, ok, err := sensors.LatestTested(db, pool.ID, p, now)
+			if err != nil {
+				return err
+			}
+			if ok {
+				e.sample("waterlogger_pool_parameter", latest.Value,
+					"pool_id", id, "pool", pool.Name, "parameter", p, "unit", chemistry.ParameterUnit(p))
+			}
+		}
+	}
+
+	e.family("waterlogger_pool_hours_since_sample", "gauge", "Hours since the latest sample of a pool")
+	for _, pool := range pools {
+		var times []time.Time
+		err := db.Model(&models.Sample{}).Where("pool_id = ?", pool.ID).
+			Order("sample_date_time DESC").Limit(1).Pluck("sample_date_time", &times).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch the latest sample: %w", err)
+		}
+		if len(times) > 0 {
+			e.sample("waterlogger_pool_hours_since_sample", now.Sub(times[0]).Hours(),
+				"pool_id", strconv.FormatUint(uint64(pool.ID), 10), "pool", pool.Name)
+		}
+	}
+
+	if err := writeDBStats(e, db); err != nil {
+		return err
+	}
+	recorder.write(e)
+	return e.w.Flush()
+}
+
+// writeDBStats writes the connection pool statistics of the database
+func writeDBStats(e *encoder, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database handle: %w", err)
+	}
+	stats := sqlDB.Stats()
+
+	gauges := []struct {
+		name, help string
+		value      float64
+	}{
+		{"waterlogger_db_max_open_connections", "Maximum number of open database connections; 0 is unlimited", float64(stats.MaxOpenConnections)},
+		{"waterlogger_db_open_connections", "Open database connections", float64(stats.OpenConnections)},
+		{"waterlogger_db_in_use_connections", "Database connections in use", float64(stats.InUse)},
+		{"waterlogger_db_idle_connections", "Idle database connections", float64(stats.Idle)},
+	}
+	for _, g := range gauges {
+		e.family(g.name, "gauge", g.help)
+		e.sample(g.name, g.value)
+	}
+
+	counters := []struct {
+		name, help string
+		value      float64
+	}{
+		{"waterlogger_db_wait_count_total", "Connections waited for", float64(stats.WaitCount)},
+		{"waterlogger_db_wait_duration_seconds_total", "Time spent waiting for connections", stats.WaitDuration.Seconds()},
+		{"waterlogger_db_max_idle_closed_total", "Connections closed because of the idle limit", float64(stats.MaxIdleClosed)},
+		{"waterlogger_db_max_idle_time_closed_total", "Connections closed because of the idle time limit", float64(stats.MaxIdleTimeClosed)},
+		{"waterlogger_db_max_lifetime_closed_total", "Connections closed because of the lifetime limit", float64(stats.MaxLifetimeClosed)},
+	}
+	for _, c := range counters {
+		e.family(c.name, "counter", c.help)
+		e.sample(c.name, c.value)
+	}
+	return nil
+}
+
+// encoder writes metric families in the Prometheus text format
+type encoder struct {
+	w *bufio.Writer
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// family writes the HELP and TYPE lines of a metric
+func (e *encoder) family(name, kind, help string) {
+	fmt.Fprintf(e.w, "# HELP %s %s\n# TYPE %s %s\n", name, helpEscaper.Replace(help), name, kind)
+}
+
+// sample writes one sample with labels given as name, value pairs
+func (e *encoder) sample(name string, value float64, labels ...string) {
+	e.w.WriteString(name)
+	if len(labels) > 0 {
+		e.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				e.w.WriteByte(',')
+			}
+			fmt.Fprintf(e.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		e.w.WriteByte('}')
+	}
+	e.w.WriteByte(' ')
+	e.w.WriteString(formatValue(value))
+	e.w.WriteByte('\n')
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
 	"strconv"
 	"strings"
@@ -19,7 +20,8 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 		   strings.HasPrefix(c.Request.URL.Path, "/static") ||
 		   strings.HasPrefix(c.Request.URL.Path, "/login") ||
 		   strings.HasPrefix(c.Request.URL.Path, "/api/setup") ||
-		   strings.HasPrefix(c.Request.URL.Path, "/api/login") {
+		   strings.HasPrefix(c.Request.URL.Path, "/api/login") ||
+		   c.Request.URL.Path == "/metrics" {
 			c.Next()
 			return
 		}
@@ -58,6 +60,67 @@ func AuthMiddleware(db *gorm.DB) gin.HandlerFunc {
 	}
 }
 
+// DeviceTokenAuth lets probes and controllers send sensor readings to
+// POST /api/readings with a bearer token instead of a login session. They
+// act as the first administrator. Requests without a token fall through to
+// the session check; a wrong token is rejected.
+func DeviceTokenAuth(db *gorm.DB, token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if token == "" || !ok || c.Request.Method != http.MethodPost || c.Request.URL.Path != "/api/readings" {
+			c.Next()
+			return
+		}
+
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="readings"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "A valid readings token is required"})
+			c.Abort()
+			return
+		}
+
+		var admin models.User
+		if err := db.Where("is_admin = ?", true).Order("id ASC").First(&admin).Error; err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "No administrator to act as"})
+			c.Abort()
+			return
+		}
+		c.Set("user_id", admin.ID)
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects requests from users who are not administrators
+func RequireAdmin(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !IsAdmin(c, db) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Administrator access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IsAdmin reports whether the authenticated user is an administrator
+func IsAdmin(c *gin.Context, db *gorm.DB) bool {
+	userID, ok := c.Get("user_id")
+	if !ok {
+		return false
+	}
+	uid, ok := userID.(uint)
+	if !ok {
+		return false
+	}
+
+	var user models.User
+	if err := db.First(&user, uid).Error; err != nil {
+		return false
+	}
+	return user.IsAdmin
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(bytes), err
@@ -16,6 +16,7 @@ type BaseModel struct {
 	UpdatedAt time.Time `json:"updated_at"`
 	CreatedBy uint      `json:"created_by"`
 	UpdatedBy uint      `json:"updated_by"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"` // Set while the record is in the trash
 }
 
 // User represents a system user
@@ -24,9 +25,12 @@ type User struct {
 	Username string `gorm:"uniqueIndex;not null" json:"username"`
 	Email    string `gorm:"uniqueIndex;not null" json:"email"`
 	Password string `gorm:"not null" json:"-"`
+	IsAdmin  bool   `gorm:"not null;default:false" json:"is_admin"`
 	
 	// Relationships
 	Preferences *UserPreferences `gorm:"foreignKey:UserID" json:"preferences,omitempty"`
+	NotificationPreferences *NotificationPreferences `gorm:"foreignKey:UserID" json:"notification_preferences,omitempty"`
+	NotificationChannels []NotificationChannel `gorm:"foreignKey:UserID" json:"-"`
 	CreatedPools []Pool          `gorm:"foreignKey:CreatedBy" json:"-"`
 	UpdatedPools []Pool          `gorm:"foreignKey:UpdatedBy" json:"-"`
 }
@@ -38,6 +42,29 @@ type UserPreferences struct {
 	UnitSystem string `gorm:"not null;default:'imperial'" json:"unit_system"` // imperial, metric
 }
 
+// NotificationPreferences stores which notifications a user receives
+type NotificationPreferences struct {
+	BaseModel
+	UserID        uint       `gorm:"not null;uniqueIndex" json:"user_id"`
+	EmailAlerts   bool       `gorm:"not null;default:false" json:"email_alerts"`
+	EmailDigest   bool       `gorm:"not null;default:false" json:"email_digest"`
+	AlertSeverity string     `gorm:"size:20;not null;default:'warning'" json:"alert_severity"` // warning, critical
+	LastDigestAt  *time.Time `json:"last_digest_at,omitempty"`
+}
+
+// NotificationChannel is a push notification endpoint of a user, such as an
+// ntfy topic or a Gotify server
+type NotificationChannel struct {
+	BaseModel
+	UserID        uint   `gorm:"not null;index" json:"user_id"`
+	Name          string `gorm:"size:100;not null" json:"name"`
+	Type          string `gorm:"size:20;not null" json:"type"` // ntfy, gotify
+	URL           string `gorm:"size:2048;not null" json:"url"`
+	Token         string `gorm:"size:255" json:"token"`
+	AlertSeverity string `gorm:"size:20;not null;default:'warning'" json:"alert_severity"` // warning, critical
+	Active        bool   `gorm:"not null" json:"active"`
+}
+
 // Pool represents a pool or hot tub
 type Pool struct {
 	BaseModel
@@ -46,8 +73,8 @@ type Pool struct {
 	Type            string  `json:"type"` // pool, hot_tub
 	SystemDescription *string `json:"system_description,omitempty"`
 	
-	// Relationships
-	Samples []Sample `gorm:"foreignKey:PoolID" json:"samples,omitempty"`
+	// Relationships - deleting a pool removes its samples
+	Samples []Sample `gorm:"foreignKey:PoolID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"samples,omitempty"`
 }
 
 // Kit represents test kits and equipment
@@ -58,8 +85,8 @@ type Kit struct {
 	PurchasedDate  *time.Time `json:"purchased_date,omitempty"`
 	ReplenishedDate *time.Time `json:"replenished_date,omitempty"`
 	
-	// Relationships
-	Samples []Sample `gorm:"foreignKey:KitID" json:"samples,omitempty"`
+	// Relationships - kits cannot be deleted while samples use them
+	Samples []Sample `gorm:"foreignKey:KitID;constraint:OnUpdate:CASCADE,OnDelete:RESTRICT" json:"samples,omitempty"`
 }
 
 // KitJSON is a helper struct for JSON unmarshaling with string dates
@@ -146,8 +173,9 @@ type Sample struct {
 	Pool         *Pool         `gorm:"foreignKey:PoolID" json:"pool,omitempty"`
 	User         *User         `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	Kit          *Kit          `gorm:"foreignKey:KitID" json:"kit,omitempty"`
-	Measurements *Measurements `gorm:"foreignKey:SampleID" json:"measurements,omitempty"`
-	Indices      *Indices      `gorm:"foreignKey:SampleID" json:"indices,omitempty"`
+	Measurements *Measurements `gorm:"foreignKey:SampleID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"measurements,omitempty"`
+	Indices      *Indices      `gorm:"foreignKey:SampleID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"indices,omitempty"`
+	Additions    []Addition    `gorm:"foreignKey:SampleID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"additions,omitempty"`
 }
 
 // SampleJSON is a helper struct for JSON unmarshaling with string datetime
@@ -163,6 +191,32 @@ type SampleJSON struct {
 	CreatedBy      uint                   `json:"created_by"`
 	UpdatedBy      uint                   `json:"updated_by"`
 	Measurements   map[string]interface{} `json:"measurements,omitempty"`
+	Additions      []AdditionJSON         `json:"additions,omitempty"`
+}
+
+// AdditionJSON is a helper struct for JSON unmarshaling of sample additions
+type AdditionJSON struct {
+	Chemical string  `json:"chemical"`
+	Amount   float64 `json:"amount"`
+	Unit     string  `json:"unit"`
+	Notes    *string `json:"notes,omitempty"`
+}
+
+// SampleDateTimeLayouts are the formats accepted for sample times:
+// datetime-local (YYYY-MM-DDTHH:MM), datetime-local with seconds and RFC3339
+var SampleDateTimeLayouts = []string{"2006-01-02T15:04", "2006-01-02T15:04:05", time.RFC3339}
+
+// ParseSampleDateTime parses a sample time in one of SampleDateTimeLayouts.
+// Times without a zone are taken as UTC.
+func ParseSampleDateTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range SampleDateTimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
 }
 
 // UnmarshalJSON custom unmarshaler for Sample to handle datetime-local format
@@ -184,20 +238,7 @@ func (s *Sample) UnmarshalJSON(data []byte) error {
 	// Parse the datetime - handle multiple formats
 	if sampleJSON.SampleDateTime != "" {
 		dateStr := strings.TrimSpace(sampleJSON.SampleDateTime)
-		var parsedTime time.Time
-		var err error
-		
-		// Try datetime-local format first (YYYY-MM-DDTHH:MM)
-		if len(dateStr) == 16 && strings.Count(dateStr, "T") == 1 {
-			parsedTime, err = time.Parse("2006-01-02T15:04", dateStr)
-		} else if len(dateStr) == 19 && strings.Count(dateStr, "T") == 1 {
-			// Try datetime-local with seconds (YYYY-MM-DDTHH:MM:SS)
-			parsedTime, err = time.Parse("2006-01-02T15:04:05", dateStr)
-		} else {
-			// Try full RFC3339 format
-			parsedTime, err = time.Parse(time.RFC3339, dateStr)
-		}
-		
+		parsedTime, err := ParseSampleDateTime(dateStr)
 		if err != nil {
 			return fmt.Errorf("failed to parse sample_datetime '%s': %v", dateStr, err)
 		}
@@ -257,15 +298,134 @@ func (s *Sample) UnmarshalJSON(data []byte) error {
 		measurements.CYA = getFloatPtr("cya")
 		measurements.Salinity = getFloatPtr("salinity")
 		measurements.TDS = getFloatPtr("tds")
+		measurements.ORP = getFloatPtr("orp")
 		measurements.Appearance = getStringPtr("appearance")
 		measurements.Maintenance = getStringPtr("maintenance")
 		
 		s.Measurements = measurements
 	}
 	
+	// Parse additions if provided; an empty list clears the sample's additions
+	if sampleJSON.Additions != nil {
+		s.Additions = make([]Addition, 0, len(sampleJSON.Additions))
+		for _, a := range sampleJSON.Additions {
+			chemical := strings.TrimSpace(a.Chemical)
+			if chemical == "" {
+				continue
+			}
+			s.Additions = append(s.Additions, Addition{
+				Chemical: chemical,
+				Amount:   a.Amount,
+				Unit:     strings.TrimSpace(a.Unit),
+				Notes:    a.Notes,
+			})
+		}
+	}
+	
+	return nil
+}
+
+// BeforeSave hook to store sample times in UTC so that date range queries
+// compare consistently on every database engine
+func (s *Sample) BeforeSave(tx *gorm.DB) error {
+	s.SampleDateTime = s.SampleDateTime.UTC()
 	return nil
 }
 
+// ParameterValue returns the value of a measured or calculated parameter
+// (fc, tc, ph, ta, ch, cya, temperature, salinity, tds, orp, lsi, rsi). Zero
+// values of required measurements are treated as not recorded.
+func (s *Sample) ParameterValue(parameter string) (float64, bool) {
+	if m := s.Measurements; m != nil {
+		switch parameter {
+		case "fc":
+			return m.FC, m.FC != 0
+		case "tc":
+			return m.TC, m.TC != 0
+		case "ph":
+			return m.PH, m.PH != 0
+		case "ta":
+			return m.TA, m.TA != 0
+		case "ch":
+			return m.CH, m.CH != 0
+		case "temperature":
+			return m.Temperature, m.Temperature != 0
+		case "cya":
+			return optionalValue(m.CYA)
+		case "salinity":
+			return optionalValue(m.Salinity)
+		case "tds":
+			return optionalValue(m.TDS)
+		case "orp":
+			return optionalValue(m.ORP)
+		}
+	}
+	
+	if idx := s.Indices; idx != nil {
+		switch parameter {
+		case "lsi":
+			return optionalValue(idx.LSI)
+		case "rsi":
+			return optionalValue(idx.RSI)
+		}
+	}
+	
+	return 0, false
+}
+
+// RequiredMeasurement reports whether a parameter is a not-null column of
+// Measurements, where zero means not recorded
+func RequiredMeasurement(parameter string) bool {
+	switch parameter {
+	case "fc", "tc", "ph", "ta", "ch", "temperature":
+		return true
+	}
+	return false
+}
+
+// IndexParameter reports whether a parameter is calculated into Indices
+// rather than measured
+func IndexParameter(parameter string) bool {
+	return parameter == "lsi" || parameter == "rsi"
+}
+
+func optionalValue(v *float64) (float64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	return *v, true
+}
+
+// SetParameterValue sets a measured parameter (fc, tc, ph, ta, ch, cya,
+// temperature, salinity, tds, orp), returning false for other parameters
+func (m *Measurements) SetParameterValue(parameter string, value float64) bool {
+	switch parameter {
+	case "fc":
+		m.FC = value
+	case "tc":
+		m.TC = value
+	case "ph":
+		m.PH = value
+	case "ta":
+		m.TA = value
+	case "ch":
+		m.CH = value
+	case "temperature":
+		m.Temperature = value
+	case "cya":
+		m.CYA = &value
+	case "salinity":
+		m.Salinity = &value
+	case "tds":
+		m.TDS = &value
+	case "orp":
+		m.ORP = &value
+	default:
+		return false
+	}
+	return true
+}
+
 // Measurements stores water chemistry measurements
 type Measurements struct {
 	BaseModel
@@ -279,6 +439,7 @@ type Measurements struct {
 	Temperature  float64  `gorm:"not null" json:"temperature"`  // Temperature (°F)
 	Salinity     *float64 `json:"salinity,omitempty"`           // Salinity (ppm)
 	TDS          *float64 `json:"tds,omitempty"`                // Total Dissolved Solids (mg/l)
+	ORP          *float64 `json:"orp,omitempty"`                // Oxidation Reduction Potential (mV)
 	Appearance   *string  `json:"appearance,omitempty"`         // Water appearance notes
 	Maintenance  *string  `json:"maintenance,omitempty"`        // Maintenance notes
 }
@@ -292,6 +453,152 @@ type Indices struct {
 	Comment  *string  `json:"comment,omitempty"` // Notes about estimation/missing parameters
 }
 
+// Addition records a chemical added to the water after a sample was taken
+type Addition struct {
+	BaseModel
+	SampleID uint    `gorm:"not null;index" json:"sample_id"`
+	Chemical string  `gorm:"not null" json:"chemical"` // e.g. liquid chlorine, muriatic acid
+	Amount   float64 `gorm:"not null" json:"amount"`
+	Unit     string  `json:"unit"`                     // e.g. gal, fl oz, lb, oz
+	Notes    *string `json:"notes,omitempty"`
+}
+
+// SensorReading is one reading of a parameter from a probe or controller,
+// in stored units. Readings are a time series apart from samples: they have
+// no kit or user and are not trashed. Old readings are downsampled into
+// averages of Count readings.
+type SensorReading struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	PoolID      uint      `gorm:"not null;index:idx_sensor_readings_series,priority:1" json:"pool_id"`
+	Parameter   string    `gorm:"size:32;not null;index:idx_sensor_readings_series,priority:2" json:"parameter"`
+	TakenAt     time.Time `gorm:"not null;index:idx_sensor_readings_series,priority:3;index" json:"taken_at"`
+	Value       float64   `gorm:"not null" json:"value"`
+	Source      string    `gorm:"size:100;not null;default:''" json:"source"` // e.g. the probe or controller name
+	Count       int       `gorm:"not null;default:1" json:"count"`
+	Downsampled bool      `gorm:"not null;default:false" json:"downsampled"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Relationships - purging a pool removes its readings
+	Pool Pool `gorm:"foreignKey:PoolID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+// ORPCalibration is a pool's fitted relationship between ORP and manually
+// tested free chlorine: ORP = Intercept + Slope × ln(FC × HOCl fraction),
+// where the HOCl fraction follows from pH and temperature. Cyanuric acid
+// shifts the relationship, so it holds for the CYA level it was fitted at.
+type ORPCalibration struct {
+	BaseModel
+	PoolID    uint      `gorm:"not null;uniqueIndex" json:"pool_id"`
+	Intercept float64   `gorm:"not null" json:"intercept"`
+	Slope     float64   `gorm:"not null" json:"slope"`
+	RSquared  float64   `gorm:"not null" json:"r_squared"`
+	Points    int       `gorm:"not null" json:"points"` // Manual tests the fit is based on
+	CYA       float64   `gorm:"not null" json:"cya"`    // CYA level of those tests (ppm)
+	FirstTest time.Time `json:"first_test"`
+	LastTest  time.Time `json:"last_test"`
+
+	// Relationships - purging a pool removes its calibration
+	Pool Pool `gorm:"foreignKey:PoolID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+// TestSchedule is how often a pool should be tested, as a number of tests a
+// day, week or month. A pool is due one interval after its latest sample and
+// overdue once the grace period has passed too.
+type TestSchedule struct {
+	BaseModel
+	PoolID         uint       `gorm:"not null;uniqueIndex" json:"pool_id"`
+	Times          int        `gorm:"not null" json:"times"`          // Tests per period
+	Period         string     `gorm:"size:10;not null" json:"period"` // day, week or month
+	GraceHours     int        `gorm:"not null;default:0" json:"grace_hours"`
+	Reminders      bool       `gorm:"not null" json:"reminders"` // Notify users when the pool is overdue
+	LastReminderAt *time.Time `json:"last_reminder_at,omitempty"`
+
+	// Relationships - purging a pool removes its schedule
+	Pool Pool `gorm:"foreignKey:PoolID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+// MaintenanceTask is recurring upkeep of a pool, such as cleaning the filter
+// every 4 weeks. It is due one recurrence after its latest completion.
+type MaintenanceTask struct {
+	BaseModel
+	PoolID uint    `gorm:"not null;index" json:"pool_id"`
+	Kind   string  `gorm:"size:30;not null" json:"kind"` // filter_clean, backwash, salt_cell_inspection, drain_refill or other
+	Name   string  `gorm:"size:100;not null" json:"name"`
+	Every  int     `gorm:"not null" json:"every"`         // Units between completions
+	Unit   string  `gorm:"size:10;not null" json:"unit"` // day, week, month or year
+	Notes  *string `gorm:"type:text" json:"notes,omitempty"`
+
+	// Relationships - purging a pool removes its tasks, and deleting a task
+	// removes its completions
+	Pool        Pool                    `gorm:"foreignKey:PoolID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+	Completions []MaintenanceCompletion `gorm:"foreignKey:TaskID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+// MaintenanceCompletion records a maintenance task being done, with the
+// sample taken at the time when there is one
+type MaintenanceCompletion struct {
+	BaseModel
+	TaskID      uint      `gorm:"not null;index" json:"task_id"`
+	CompletedAt time.Time `gorm:"not null;index" json:"completed_at"`
+	SampleID    *uint     `gorm:"index" json:"sample_id,omitempty"`
+	Notes       *string   `gorm:"type:text" json:"notes,omitempty"`
+
+	// Relationships - purging a sample clears the link
+	Sample *Sample `gorm:"foreignKey:SampleID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL" json:"-"`
+}
+
+// BeforeSave hook to store completion times in UTC, like sample times
+func (m *MaintenanceCompletion) BeforeSave(tx *gorm.DB) error {
+	m.CompletedAt = m.CompletedAt.UTC()
+	return nil
+}
+
+// Webhook is a URL that is sent events as JSON signed with its secret
+type Webhook struct {
+	BaseModel
+	Name   string `gorm:"size:100;not null" json:"name"`
+	URL    string `gorm:"size:2048;not null" json:"url"`
+	Secret string `gorm:"size:255;not null" json:"secret"` // HMAC-SHA256 key of the payload signature
+	Events string `gorm:"size:255;not null" json:"events"` // Comma-separated event types, or * for all
+	Active bool   `gorm:"not null" json:"active"`
+
+	// Relationships - deleting a webhook removes its deliveries
+	Deliveries []WebhookDelivery `gorm:"foreignKey:WebhookID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE" json:"-"`
+}
+
+// WebhookDelivery is an event queued for a webhook and the outcome of
+// sending it. Pending deliveries are retried with increasing delays until
+// they succeed or run out of attempts.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	WebhookID      uint       `gorm:"not null;index" json:"webhook_id"`
+	Event          string     `gorm:"size:50;not null" json:"event"`
+	Payload        string     `gorm:"type:text;not null" json:"payload"`
+	Status         string     `gorm:"size:20;not null;index:idx_webhook_deliveries_due,priority:1" json:"status"` // pending, delivered, failed
+	Attempts       int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"not null;index:idx_webhook_deliveries_due,priority:2" json:"next_attempt_at"`
+	ResponseStatus int        `gorm:"not null;default:0" json:"response_status"` // HTTP status of the last attempt, 0 if none was received
+	Error          string     `gorm:"size:1000;not null;default:''" json:"error"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// ReportTemplate is a custom report layout uploaded by an administrator
+type ReportTemplate struct {
+	BaseModel
+	Name        string  `gorm:"uniqueIndex;size:100;not null" json:"name"`
+	Format      string  `gorm:"not null" json:"format"` // markdown, html, text
+	Description *string `json:"description,omitempty"`
+	Content     string  `gorm:"type:text;not null" json:"content"`
+}
+
+// SchemaMigration records a one-time schema upgrade that has been applied
+type SchemaMigration struct {
+	Version   string    `gorm:"primaryKey;size:100" json:"version"`
+	AppliedAt time.Time `json:"applied_at"`
+}
+
 // BeforeCreate hook to set audit fields
 func (m *BaseModel) BeforeCreate(tx *gorm.DB) error {
 	if userID, ok := tx.Statement.Context.Value("user_id").(uint); ok {
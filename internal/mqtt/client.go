@@ -0,0 +1,223 @@
+// Package mqtt connects to MQTT brokers through the Eclipse Paho client,
+// with the small API the sensor subscriber and Home Assistant discovery
+// need: subscribe with QoS 1, publish with QoS 0 and listen until the
+// connection is lost. Clients do not reconnect on their own;
+// RunWithReconnect reconnects with backoff.
+package mqtt
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// dialTimeout limits connecting and waiting for the broker to acknowledge
+const dialTimeout = 10 * time.Second
+
+// messageBuffer is the number of received messages held until Listen
+// handles them
+const messageBuffer = 256
+
+// ErrClosed is returned by Listen after Close
+var ErrClosed = errors.New("mqtt: connection closed")
+
+// Options configures a connection
+type Options struct {
+	// Broker is the broker URL: tcp://host:1883, or ssl:// or tls:// for TLS
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is the interval of pings; zero means 60 seconds
+	KeepAlive time.Duration
+	// Will is published by the broker when the connection is lost
+	Will *Message
+}
+
+// Message is a published message
+type Message struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Client is a connection to a broker
+type Client struct {
+	client   paho.Client
+	messages chan Message
+	lost     chan error
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Connect connects to the broker and waits for it to accept the connection
+func Connect(opts Options) (*Client, error) {
+	u, err := url.Parse(opts.Broker)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("mqtt: invalid broker URL %q", opts.Broker)
+	}
+	var broker string
+	var tlsConfig *tls.Config
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		broker = "tcp://" + hostPort(u, "1883")
+	case "ssl", "tls", "mqtts":
+		broker = "ssl://" + hostPort(u, "8883")
+		tlsConfig = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("mqtt: unsupported broker scheme %q", u.Scheme)
+	}
+
+	c := &Client{
+		messages: make(chan Message, messageBuffer),
+		lost:     make(chan error, 1),
+		closed:   make(chan struct{}),
+	}
+	keepAlive := opts.KeepAlive
+	if keepAlive <= 0 {
+		keepAlive = 60 * time.Second
+	}
+	options := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetCleanSession(true).
+		SetKeepAlive(keepAlive).
+		SetConnectTimeout(dialTimeout).
+		SetWriteTimeout(dialTimeout).
+		SetAutoReconnect(false).
+		SetConnectRetry(false).
+		// Handlers block until Listen takes their message, so they must
+		// not hold up the client's other work
+		SetOrderMatters(false).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			select {
+			case c.lost <- err:
+			default:
+			}
+		})
+	if tlsConfig != nil {
+		options.SetTLSConfig(tlsConfig)
+	}
+	if opts.Will != nil {
+		options.SetBinaryWill(opts.Will.Topic, opts.Will.Payload, 0, opts.Will.Retain)
+	}
+
+	c.client = paho.NewClient(options)
+	if err := wait(c.client.Connect()); err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	return c, nil
+}
+
+func hostPort(u *url.URL, defaultPort string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPort)
+}
+
+// wait waits for a token to complete, at most dialTimeout
+func wait(token paho.Token) error {
+	if !token.WaitTimeout(dialTimeout) {
+		return errors.New("timed out waiting for the broker")
+	}
+	return token.Error()
+}
+
+// Subscribe subscribes to topic filters with QoS 1 and waits for the
+// broker to acknowledge them. Messages are held for Listen.
+func (c *Client) Subscribe(filters ...string) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	qos := make(map[string]byte, len(filters))
+	for _, filter := range filters {
+		qos[filter] = 1
+	}
+	token := c.client.SubscribeMultiple(qos, func(_ paho.Client, m paho.Message) {
+		select {
+		case c.messages <- Message{Topic: m.Topic(), Payload: m.Payload(), Retain: m.Retained()}:
+		case <-c.closed:
+		}
+	})
+	if err := wait(token); err != nil {
+		return fmt.Errorf("mqtt: subscribe failed: %w", err)
+	}
+	granted := token.(*paho.SubscribeToken).Result()
+	for _, filter := range filters {
+		if granted[filter] == 0x80 {
+			return fmt.Errorf("mqtt: subscription to %q refused", filter)
+		}
+	}
+	return nil
+}
+
+// Publish publishes a message with QoS 0
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	if err := wait(c.client.Publish(topic, 0, retain, payload)); err != nil {
+		return fmt.Errorf("mqtt: publish to %s failed: %w", topic, err)
+	}
+	return nil
+}
+
+// Listen calls handle for every message received until the connection is
+// lost or closed. It returns ErrClosed after Close.
+func (c *Client) Listen(handle func(Message)) error {
+	for {
+		select {
+		case msg := <-c.messages:
+			handle(msg)
+		case err := <-c.lost:
+			c.Close()
+			return fmt.Errorf("mqtt: connection lost: %w", err)
+		case <-c.closed:
+			return ErrClosed
+		}
+	}
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.client.Disconnect(250)
+	})
+	return nil
+}
+
+// Reconnection delays after the broker connection fails
+const (
+	minReconnectDelay = 5 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+)
+
+// RunWithReconnect connects to the broker and calls run with the
+// connection until it fails, then reconnects with increasing delays. The
+// connection is closed after run returns. Log lines start with name. It
+// never returns.
+func RunWithReconnect(opts Options, name string, run func(*Client) error) {
+	delay := minReconnectDelay
+	for {
+		client, err := Connect(opts)
+		if err == nil {
+			log.Printf("%s connected to %s", name, opts.Broker)
+			delay = minReconnectDelay
+			err = run(client)
+			client.Close()
+		}
+
+		log.Printf("%s connection to %s failed: %v; retrying in %s", name, opts.Broker, err, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
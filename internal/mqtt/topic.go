@@ -0,0 +1,28 @@
+package mqtt
+
+import "strings"
+
+// Match reports whether a topic matches a topic filter with the + (one
+// level) and # (all remaining levels) wildcards
+func Match(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	// Wildcards do not match topics starting with $, such as $SYS
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
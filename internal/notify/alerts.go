@@ -0,0 +1,202 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/config"
+	"waterlogger/internal/export"
+	"waterlogger/internal/models"
+)
+
+// Alert severity preferences
+const (
+	SeverityWarning  = chemistry.SeverityWarning
+	SeverityCritical = chemistry.SeverityCritical
+)
+
+// AlertData is the data of alert templates
+type AlertData struct {
+	User     string
+	Pool     string
+	SampleID uint
+	Time     time.Time // UTC
+	Units    string    // imperial or metric
+	Alerts   []AlertLine
+	Values   []Value // Every recorded parameter of the sample
+}
+
+// SampleSaved notifies the users who receive alerts when a created or
+// updated sample has a parameter out of range that it did not have before
+// the update. Each user's email and push channels get the alerts at or above
+// their severity. The sample must be loaded with its pool, measurements and
+// indices. Notifications are sent in the background.
+func SampleSaved(db *gorm.DB, cfg config.EmailConfig, sample *models.Sample, before []chemistry.Alert) error {
+	alerts := chemistry.CheckSample(sample)
+	added := chemistry.NewAlerts(alerts, before)
+	if len(added) == 0 {
+		return nil
+	}
+
+	targets, err := alertTargets(db, cfg)
+	if err != nil {
+		return err
+	}
+	type delivery struct {
+		target       Target
+		notification Notification
+	}
+	var deliveries []delivery
+	for _, target := range targets {
+		if len(atSeverity(added, target.Severity)) == 0 {
+			continue
+		}
+		n, err := alertNotification(target.User, sample, atSeverity(alerts, target.Severity))
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery{target, n})
+	}
+
+	go func() {
+		for _, d := range deliveries {
+			if err := d.target.Notifier.Notify(d.notification); err != nil {
+				log.Printf("Failed to send alert to %s: %v", d.target.Name, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Target is a channel a user receives alerts on
+type Target struct {
+	User     models.User
+	Name     string // e.g. email ann@example.com, or ntfy channel Phone
+	Severity string // Least severity sent: warning or critical
+	Notifier Notifier
+}
+
+// alertTargets returns the email addresses and active push channels that
+// receive alerts. Email is left out unless it is enabled.
+func alertTargets(db *gorm.DB, cfg config.EmailConfig) ([]Target, error) {
+	var mailer *Mailer
+	if cfg.Enabled {
+		var err error
+		if mailer, err = NewMailer(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	var users []models.User
+	err := db.Preload("Preferences").Preload("NotificationPreferences").
+		Preload("NotificationChannels", "active = ?", true).
+		Order("id ASC").Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notification recipients: %w", err)
+	}
+
+	var targets []Target
+	for _, user := range users {
+		if prefs := user.NotificationPreferences; mailer != nil && prefs != nil && prefs.EmailAlerts {
+			targets = append(targets, Target{
+				User:     user,
+				Name:     "email " + user.Email,
+				Severity: prefs.AlertSeverity,
+				Notifier: EmailNotifier{Mailer: mailer, To: user.Email},
+			})
+		}
+		for _, ch := range user.NotificationChannels {
+			notifier, err := ChannelNotifier(ch)
+			if err != nil {
+				log.Printf("Skipping notification channel %d: %v", ch.ID, err)
+				continue
+			}
+			targets = append(targets, Target{
+				User:     user,
+				Name:     fmt.Sprintf("%s channel %s", ch.Type, ch.Name),
+				Severity: ch.AlertSeverity,
+				Notifier: notifier,
+			})
+		}
+	}
+	return targets, nil
+}
+
+// atSeverity returns the alerts a user receives at a severity preference
+func atSeverity(alerts []chemistry.Alert, severity string) []chemistry.Alert {
+	if severity != SeverityCritical {
+		return alerts
+	}
+	var critical []chemistry.Alert
+	for _, a := range alerts {
+		if a.Severity == SeverityCritical {
+			critical = append(critical, a)
+		}
+	}
+	return critical
+}
+
+// alertNotification renders the alert of a sample for a user
+func alertNotification(user models.User, sample *models.Sample, alerts []chemistry.Alert) (Notification, error) {
+	f := export.Filter{Units: userUnits(user)}
+	data := AlertData{
+		User:     user.Username,
+		SampleID: sample.ID,
+		Time:     sample.SampleDateTime.UTC(),
+		Units:    string(f.Units),
+	}
+	if sample.Pool != nil {
+		data.Pool = sample.Pool.Name
+	}
+	for _, a := range alerts {
+		data.Alerts = append(data.Alerts, newAlertLine(f, a))
+	}
+	for _, parameter := range append(f.MeasurementParameters(), f.IndexParameters()...) {
+		if value, ok := sample.ParameterValue(parameter); ok {
+			data.Values = append(data.Values, newValue(f, parameter, value))
+		}
+	}
+
+	names := make([]string, len(data.Alerts))
+	for i, a := range data.Alerts {
+		names[i] = a.Name + " " + a.Status
+	}
+	title := fmt.Sprintf("%s: %s", data.Pool, strings.Join(names, ", "))
+	severity := SeverityWarning
+	for _, a := range alerts {
+		if a.Severity == SeverityCritical {
+			title = "Critical - " + title
+			severity = SeverityCritical
+			break
+		}
+	}
+	n, err := render("alert", title, data)
+	n.Severity = severity
+	return n, err
+}
+
+// recipients returns the users whose notification preferences enable a
+// column, with their preferences loaded
+func recipients(db *gorm.DB, column string) ([]models.User, error) {
+	var users []models.User
+	err := db.Preload("Preferences").Preload("NotificationPreferences").
+		Joins("JOIN notification_preferences ON notification_preferences.user_id = users.id AND notification_preferences.deleted_at IS NULL").
+		Where("notification_preferences."+column+" = ?", true).
+		Order("users.id ASC").Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch notification recipients: %w", err)
+	}
+	return users, nil
+}
+
+// userUnits returns the unit system a user's notifications are written in
+func userUnits(user models.User) chemistry.UnitSystem {
+	if user.Preferences != nil && chemistry.UnitSystem(user.Preferences.UnitSystem) == chemistry.Metric {
+		return chemistry.Metric
+	}
+	return chemistry.Imperial
+}
@@ -0,0 +1,350 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/config"
+	"waterlogger/internal/export"
+	"waterlogger/internal/models"
+	"waterlogger/internal/report"
+	"waterlogger/internal/schedule"
+)
+
+const (
+	// digestPeriod is the time a digest covers
+	digestPeriod = 7 * 24 * time.Hour
+	// digestCheckInterval is how often due digests are looked for
+	digestCheckInterval = 5 * time.Minute
+	// digestRetryDelay is the wait after a failed digest, doubled after each
+	// further failure
+	digestRetryDelay = 15 * time.Minute
+	// maxDigestAttempts is how often a digest is tried per scheduled time
+	// before it is skipped until the next one
+	maxDigestAttempts = 4
+)
+
+// Trends of a parameter compared with the week before
+const (
+	TrendRising  = "rising"
+	TrendFalling = "falling"
+	TrendSteady  = "steady"
+)
+
+// DigestData is the data of weekly digest templates
+type DigestData struct {
+	User    string
+	Units   string    // imperial or metric
+	From    time.Time // UTC
+	To      time.Time // UTC
+	Pools   []DigestPool
+	Overdue []string // Names of overdue pools
+}
+
+// DigestPool summarizes a pool's week
+type DigestPool struct {
+	Name          string
+	Tests         int        // Samples in the digest period
+	LastTested    *time.Time // UTC, nil if never tested
+	DaysSinceTest int
+	Overdue       bool // Overdue on its test schedule, or without one not tested within email.overdue_days
+	Stats         []DigestStat
+	Alerts        []AlertLine // Out-of-range values of the latest sample of the period
+}
+
+// DigestStat summarizes a parameter over the digest period
+type DigestStat struct {
+	Name     string
+	Unit     string
+	Latest   string // "" when not recorded in the latest sample
+	Average  string
+	Min      string
+	Max      string
+	Previous string // Average of the week before, "" without values
+	Trend    string // rising, falling or steady, "" without values the week before
+}
+
+// Digests sends weekly digests on the configured day and hour
+type Digests struct {
+	db          *gorm.DB
+	mailer      *Mailer
+	weekday     time.Weekday
+	hour        int
+	overdueDays int
+	failures    map[uint]digestFailure // By user ID, for the current scheduled time
+}
+
+// digestFailure records the failed attempts to send a user a digest
+type digestFailure struct {
+	slot     time.Time // Scheduled time of the digest
+	attempts int
+	last     time.Time
+}
+
+// retryAt returns when a failed digest may be tried again, or false when it
+// is skipped until the next scheduled time
+func (f digestFailure) retryAt() (time.Time, bool) {
+	if f.attempts >= maxDigestAttempts {
+		return time.Time{}, false
+	}
+	return f.last.Add(digestRetryDelay << (f.attempts - 1)), true
+}
+
+// NewDigests checks the email configuration and returns a digest sender
+func NewDigests(db *gorm.DB, cfg config.EmailConfig) (*Digests, error) {
+	mailer, err := NewMailer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	weekday, err := parseWeekday(cfg.DigestWeekday)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DigestHour < 0 || cfg.DigestHour > 23 {
+		return nil, fmt.Errorf("email.digest_hour must be between 0 and 23")
+	}
+	d := &Digests{
+		db:          db,
+		mailer:      mailer,
+		weekday:     weekday,
+		hour:        cfg.DigestHour,
+		overdueDays: cfg.OverdueDays,
+		failures:    make(map[uint]digestFailure),
+	}
+	if d.overdueDays <= 0 {
+		d.overdueDays = 3
+	}
+	return d, nil
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	if name == "" {
+		return time.Monday, nil
+	}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) {
+			return day, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid email.digest_weekday %q", name)
+}
+
+// Slot returns the latest scheduled digest time at or before now, in server
+// local time
+func (d *Digests) Slot(now time.Time) time.Time {
+	local := now.In(time.Local)
+	slot := time.Date(local.Year(), local.Month(), local.Day(), d.hour, 0, 0, 0, time.Local)
+	slot = slot.AddDate(0, 0, -((int(local.Weekday()) - int(d.weekday) + 7) % 7))
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -7)
+	}
+	return slot
+}
+
+// SendDue sends the digests of the users who have not had one since the
+// latest scheduled time and returns how many were sent. Failed digests are
+// retried with increasing delays, up to maxDigestAttempts times per
+// scheduled time.
+func (d *Digests) SendDue(now time.Time) (int, error) {
+	users, err := recipients(d.db, "email_digest")
+	if err != nil {
+		return 0, err
+	}
+	slot := d.Slot(now)
+
+	sent := 0
+	digests := make(map[chemistry.UnitSystem]*DigestData)
+	for _, user := range users {
+		prefs := user.NotificationPreferences
+		if prefs.LastDigestAt != nil && !prefs.LastDigestAt.Before(slot) {
+			continue
+		}
+		failure, failed := d.failures[user.ID]
+		if failed && failure.slot.Equal(slot) {
+			if retryAt, ok := failure.retryAt(); !ok || now.Before(retryAt) {
+				continue
+			}
+		} else {
+			failure = digestFailure{slot: slot}
+		}
+		units := userUnits(user)
+		if digests[units] == nil {
+			digest, err := BuildDigest(d.db, units, d.overdueDays, now)
+			if err != nil {
+				return sent, err
+			}
+			digests[units] = digest
+		}
+		if err := d.send(user, *digests[units]); err != nil {
+			failure.attempts++
+			failure.last = now
+			d.failures[user.ID] = failure
+			if _, ok := failure.retryAt(); ok {
+				log.Printf("Failed to email digest to %s: %v", user.Email, err)
+			} else {
+				log.Printf("Failed to email digest to %s, skipping it until the next scheduled time: %v", user.Email, err)
+			}
+			continue
+		}
+		delete(d.failures, user.ID)
+		err := d.db.Model(&models.NotificationPreferences{}).Where("id = ?", prefs.ID).
+			Update("last_digest_at", now.UTC()).Error
+		if err != nil {
+			return sent, fmt.Errorf("failed to record digest: %w", err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// SendTo builds and sends a digest to one user at once, outside the schedule
+func (d *Digests) SendTo(user models.User, now time.Time) error {
+	digest, err := BuildDigest(d.db, userUnits(user), d.overdueDays, now)
+	if err != nil {
+		return err
+	}
+	return d.send(user, *digest)
+}
+
+func (d *Digests) send(user models.User, digest DigestData) error {
+	digest.User = user.Username
+	subject := fmt.Sprintf("Weekly pool digest, %s to %s", digest.From.Format("Jan 2"), digest.To.Format("Jan 2"))
+	if len(digest.Overdue) > 0 {
+		subject += fmt.Sprintf(" (%d overdue)", len(digest.Overdue))
+	}
+	n, err := render("digest", subject, digest)
+	if err != nil {
+		return err
+	}
+	return EmailNotifier{Mailer: d.mailer, To: user.Email}.Notify(n)
+}
+
+// BuildDigest summarizes the week before now for every pool in a unit
+// system: the tests, each parameter's latest value, range and trend against
+// the week before, the alerts of the latest test and the overdue pools
+func BuildDigest(db *gorm.DB, units chemistry.UnitSystem, overdueDays int, now time.Time) (*DigestData, error) {
+	now = now.UTC()
+	from := now.Add(-digestPeriod)
+	week, err := report.Build(db, export.Filter{From: from, To: now, Units: units}, now)
+	if err != nil {
+		return nil, err
+	}
+	before, err := report.Build(db, export.Filter{From: from.Add(-digestPeriod), To: from.Add(-time.Nanosecond), Units: units}, now)
+	if err != nil {
+		return nil, err
+	}
+	previous := make(map[uint]map[string]float64)
+	for _, pool := range before.Pools {
+		previous[pool.ID] = make(map[string]float64)
+		for _, s := range pool.Stats {
+			previous[pool.ID][s.Parameter.Key] = s.Average
+		}
+	}
+
+	digest := &DigestData{Units: week.Units, From: from, To: now}
+	for _, pool := range week.Pools {
+		p := DigestPool{Name: pool.Name, Tests: len(pool.Samples)}
+		var times []time.Time
+		err := db.Model(&models.Sample{}).Where("pool_id = ?", pool.ID).
+			Order("sample_date_time DESC").Limit(1).Pluck("sample_date_time", &times).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch the latest sample: %w", err)
+		}
+		if len(times) > 0 {
+			last := times[0].UTC()
+			p.LastTested = &last
+			p.DaysSinceTest = int(now.Sub(last).Hours() / 24)
+		}
+		scheduled := models.Pool{Name: pool.Name}
+		scheduled.ID = pool.ID
+		status, err := schedule.ForPool(db, scheduled, now)
+		switch {
+		case err == nil:
+			p.Overdue = status.Overdue
+		case errors.Is(err, schedule.ErrNoSchedule):
+			p.Overdue = p.LastTested == nil || now.Sub(*p.LastTested) > time.Duration(overdueDays)*24*time.Hour
+		default:
+			return nil, err
+		}
+		if p.Overdue {
+			digest.Overdue = append(digest.Overdue, pool.Name)
+		}
+
+		for _, s := range pool.Stats {
+			stat := DigestStat{
+				Name:    s.Parameter.Name,
+				Unit:    s.Parameter.Unit,
+				Average: formatNumber(s.Average),
+				Min:     formatNumber(s.Min),
+				Max:     formatNumber(s.Max),
+			}
+			if s.Latest != nil {
+				stat.Latest = formatNumber(*s.Latest)
+			}
+			if avg, ok := previous[pool.ID][s.Parameter.Key]; ok {
+				stat.Previous = formatNumber(avg)
+				stat.Trend = trend(s.Parameter.Key, s.Average, avg)
+			}
+			p.Stats = append(p.Stats, stat)
+		}
+		for _, a := range pool.Alerts {
+			p.Alerts = append(p.Alerts, AlertLine{
+				Value:      Value{Name: a.Parameter.Name, Value: formatNumber(a.Value), Unit: a.Parameter.Unit},
+				Status:     a.Status,
+				Severity:   a.Severity,
+				IdealRange: a.Parameter.IdealRange,
+			})
+		}
+		digest.Pools = append(digest.Pools, p)
+	}
+	return digest, nil
+}
+
+// trend compares a parameter's average with the week before. Changes under
+// 0.1, or under 5% for values with a unit, are steady.
+func trend(parameter string, average, previous float64) string {
+	threshold := 0.1
+	if chemistry.ParameterUnit(parameter) != "" {
+		threshold = math.Max(math.Abs(previous)*0.05, threshold)
+	}
+	switch {
+	case average-previous > threshold:
+		return TrendRising
+	case previous-average > threshold:
+		return TrendFalling
+	}
+	return TrendSteady
+}
+
+// Run sends digests as they fall due. It never returns.
+func (d *Digests) Run() {
+	ticker := time.NewTicker(digestCheckInterval)
+	defer ticker.Stop()
+	for {
+		if sent, err := d.SendDue(time.Now()); err != nil {
+			log.Printf("Digest emails failed: %v", err)
+		} else if sent > 0 {
+			log.Printf("Sent %d digest emails", sent)
+		}
+		<-ticker.C
+	}
+}
+
+// StartDigests sends weekly digests in the background when email is enabled
+func StartDigests(db *gorm.DB, cfg config.EmailConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	d, err := NewDigests(db, cfg)
+	if err != nil {
+		return err
+	}
+	go d.Run()
+	return nil
+}
@@ -0,0 +1,178 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"waterlogger/internal/models"
+)
+
+// Channel types
+const (
+	ChannelEmail  = "email"
+	ChannelNtfy   = "ntfy"
+	ChannelGotify = "gotify"
+)
+
+// ChannelTypes are the push channel types users can add
+var ChannelTypes = []string{ChannelNtfy, ChannelGotify}
+
+// pushTimeout is the time a push server has to accept a notification
+const pushTimeout = 10 * time.Second
+
+var pushClient = &http.Client{Timeout: pushTimeout}
+
+// Notification is a message sent over a channel
+type Notification struct {
+	Title    string
+	Summary  string // Short plain text sent by push channels; Text when empty
+	Text     string // Full plain text, sent by email along with HTML
+	HTML     string
+	Severity string // warning or critical, or "" for routine messages
+}
+
+// summary returns the text push channels send
+func (n Notification) summary() string {
+	if n.Summary != "" {
+		return n.Summary
+	}
+	return n.Text
+}
+
+// Notifier sends notifications over one channel
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// EmailNotifier sends notifications as email to one address
+type EmailNotifier struct {
+	Mailer *Mailer
+	To     string
+}
+
+// Notify sends the notification as a multipart email
+func (e EmailNotifier) Notify(n Notification) error {
+	return e.Mailer.Send(Message{To: e.To, Subject: n.Title, Text: n.Text, HTML: n.HTML})
+}
+
+// NtfyNotifier publishes notifications to an ntfy topic URL such as
+// https://ntfy.sh/my-pool
+type NtfyNotifier struct {
+	URL   string
+	Token string // Sent as a bearer token when set
+}
+
+// Notify publishes the summary with the title as a header and a priority
+// and tag following the severity
+func (t NtfyNotifier) Notify(n Notification) error {
+	req, err := http.NewRequest(http.MethodPost, t.URL, strings.NewReader(n.summary()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("Title", mime.QEncoding.Encode("utf-8", n.Title))
+	switch n.Severity {
+	case SeverityCritical:
+		req.Header.Set("Priority", "urgent")
+		req.Header.Set("Tags", "rotating_light")
+	case SeverityWarning:
+		req.Header.Set("Priority", "high")
+		req.Header.Set("Tags", "warning")
+	default:
+		req.Header.Set("Priority", "default")
+	}
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	return push(req)
+}
+
+// GotifyNotifier sends notifications to a Gotify server with an
+// application token
+type GotifyNotifier struct {
+	URL   string // Server URL, e.g. https://gotify.example.com
+	Token string
+}
+
+// Notify posts the summary as a Gotify message with a priority following
+// the severity
+func (g GotifyNotifier) Notify(n Notification) error {
+	priority := 2
+	switch n.Severity {
+	case SeverityCritical:
+		priority = 8
+	case SeverityWarning:
+		priority = 5
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"title":    n.Title,
+		"message":  n.summary(),
+		"priority": priority,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(g.URL, "/")+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return push(req)
+}
+
+// push sends a request to a push server and checks it was accepted. Errors
+// carry only the status of the response, never its body, as they are shown
+// to users.
+func push(req *http.Request) error {
+	req.Header.Set("User-Agent", "Waterlogger")
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push server returned %s", resp.Status)
+	}
+	return nil
+}
+
+// ChannelNotifier returns the notifier of a push channel
+func ChannelNotifier(ch models.NotificationChannel) (Notifier, error) {
+	switch ch.Type {
+	case ChannelNtfy:
+		return NtfyNotifier{URL: ch.URL, Token: ch.Token}, nil
+	case ChannelGotify:
+		return GotifyNotifier{URL: ch.URL, Token: ch.Token}, nil
+	}
+	return nil, fmt.Errorf("unknown channel type %q", ch.Type)
+}
+
+// CheckChannel checks the type, URL and token of a push channel
+func CheckChannel(ch models.NotificationChannel) error {
+	if ch.Type != ChannelNtfy && ch.Type != ChannelGotify {
+		return fmt.Errorf("channel type must be %s", strings.Join(ChannelTypes, " or "))
+	}
+	u, err := url.Parse(ch.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid channel URL: %s", ch.URL)
+	}
+	if ch.Type == ChannelNtfy && strings.Trim(u.Path, "/") == "" {
+		return fmt.Errorf("ntfy URLs must include the topic, e.g. https://ntfy.sh/my-pool")
+	}
+	if ch.Type == ChannelGotify && ch.Token == "" {
+		return fmt.Errorf("Gotify channels need an application token")
+	}
+	if ch.AlertSeverity != SeverityWarning && ch.AlertSeverity != SeverityCritical {
+		return fmt.Errorf("alert severity must be warning or critical")
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package notify
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/config"
+	"waterlogger/internal/models"
+	"waterlogger/internal/schedule"
+)
+
+// reminderCheckInterval is how often Reminders looks for overdue pools
+const reminderCheckInterval = 5 * time.Minute
+
+// minReminderGap is the least time between reminders of a pool that stays
+// overdue, for schedules with shorter intervals
+const minReminderGap = 24 * time.Hour
+
+// ReminderData is the data of reminder templates
+type ReminderData struct {
+	User       string
+	Pool       string
+	Schedule   string     // e.g. twice a week
+	LastTested *time.Time // UTC, nil when never tested
+	NextDue    time.Time  // UTC
+	OverdueFor string     // e.g. 2 days
+}
+
+// Reminders notifies users of pools that are overdue for testing
+type Reminders struct {
+	db  *gorm.DB
+	cfg config.EmailConfig
+}
+
+// NewReminders checks the email configuration, when email is enabled, and
+// returns a reminder sender. Push channels get reminders either way.
+func NewReminders(db *gorm.DB, cfg config.EmailConfig) (*Reminders, error) {
+	if cfg.Enabled {
+		if _, err := NewMailer(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return &Reminders{db: db, cfg: cfg}, nil
+}
+
+// SendDue sends a reminder for each overdue pool with reminders on that has
+// not had one since it fell overdue, or for a day or one test interval, and
+// returns how many pools were reminded of. Reminders go to the email
+// addresses and push channels that receive warnings.
+func (r *Reminders) SendDue(now time.Time) (int, error) {
+	statuses, err := schedule.All(r.db, now)
+	if err != nil {
+		return 0, err
+	}
+	var due []schedule.Status
+	for _, s := range statuses {
+		if s.Overdue && s.Schedule.Reminders && reminderDue(s, now) {
+			due = append(due, s)
+		}
+	}
+	if len(due) == 0 {
+		return 0, nil
+	}
+
+	targets, err := alertTargets(r.db, r.cfg)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range due {
+		for _, target := range targets {
+			if target.Severity == SeverityCritical {
+				continue
+			}
+			n, err := reminderNotification(target.User, s, now)
+			if err != nil {
+				return 0, err
+			}
+			if err := target.Notifier.Notify(n); err != nil {
+				log.Printf("Failed to send test reminder to %s: %v", target.Name, err)
+			}
+		}
+		err := r.db.Model(&models.TestSchedule{}).Where("id = ?", s.Schedule.ID).
+			Update("last_reminder_at", now.UTC()).Error
+		if err != nil {
+			return 0, fmt.Errorf("failed to record test reminder: %w", err)
+		}
+	}
+	return len(due), nil
+}
+
+// reminderDue reports whether an overdue pool should be reminded of
+func reminderDue(s schedule.Status, now time.Time) bool {
+	last := s.Schedule.LastReminderAt
+	if last == nil || last.Before(s.OverdueAt) {
+		return true
+	}
+	gap := schedule.Interval(*s.Schedule)
+	if gap < minReminderGap {
+		gap = minReminderGap
+	}
+	return now.Sub(*last) >= gap
+}
+
+// reminderNotification renders the reminder of an overdue pool for a user
+func reminderNotification(user models.User, s schedule.Status, now time.Time) (Notification, error) {
+	data := ReminderData{
+		User:       user.Username,
+		Pool:       s.Pool,
+		Schedule:   s.Description,
+		LastTested: s.LastTested,
+		NextDue:    s.NextDue,
+		OverdueFor: formatDuration(now.Sub(s.NextDue)),
+	}
+	n, err := render("reminder", fmt.Sprintf("%s is overdue for testing", s.Pool), data)
+	n.Severity = SeverityWarning
+	return n, err
+}
+
+// formatDuration returns a duration in whole days, or hours under two days
+func formatDuration(d time.Duration) string {
+	if hours := int(d.Hours()); hours < 48 {
+		if hours == 1 {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
+
+// Run sends reminders as pools fall overdue. It never returns.
+func (r *Reminders) Run() {
+	ticker := time.NewTicker(reminderCheckInterval)
+	defer ticker.Stop()
+	for {
+		if sent, err := r.SendDue(time.Now()); err != nil {
+			log.Printf("Test reminders failed: %v", err)
+		} else if sent > 0 {
+			log.Printf("Sent test reminders for %d overdue pools", sent)
+		}
+		<-ticker.C
+	}
+}
+
+// StartReminders sends overdue test reminders in the background
+func StartReminders(db *gorm.DB, cfg config.EmailConfig) error {
+	r, err := NewReminders(db, cfg)
+	if err != nil {
+		return err
+	}
+	go r.Run()
+	return nil
+}
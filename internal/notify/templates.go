@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/export"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// Each notification has a text and an HTML template, e.g. alert.txt.tmpl
+// and alert.html.tmpl, and may have a shorter text template for push
+// channels, e.g. alert.summary.txt.tmpl
+var (
+	textTemplates = texttemplate.Must(texttemplate.New("").Funcs(templateFuncs).ParseFS(templateFiles, "templates/*.txt.tmpl"))
+	htmlTemplates = htmltemplate.Must(htmltemplate.New("").Funcs(htmltemplate.FuncMap(templateFuncs)).ParseFS(templateFiles, "templates/*.html.tmpl"))
+)
+
+var templateFuncs = texttemplate.FuncMap{
+	"datetime": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04") },
+	"date":     func(t time.Time) string { return t.UTC().Format("2006-01-02") },
+	"upper":    strings.ToUpper,
+	"pad":      func(width int, s string) string { return fmt.Sprintf("%-*s", width, s) },
+}
+
+// render executes the templates of a notification
+func render(name, title string, data interface{}) (Notification, error) {
+	n := Notification{Title: title}
+	var text, html, summary bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt.tmpl", data); err != nil {
+		return n, fmt.Errorf("failed to render %s notification: %w", name, err)
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html.tmpl", data); err != nil {
+		return n, fmt.Errorf("failed to render %s notification: %w", name, err)
+	}
+	if t := textTemplates.Lookup(name + ".summary.txt.tmpl"); t != nil {
+		if err := t.Execute(&summary, data); err != nil {
+			return n, fmt.Errorf("failed to render %s notification: %w", name, err)
+		}
+	}
+	n.Text, n.HTML, n.Summary = text.String(), html.String(), strings.TrimSpace(summary.String())
+	return n, nil
+}
+
+// Value is a parameter value formatted in the recipient's unit system
+type Value struct {
+	Name  string // e.g. Temperature
+	Value string // e.g. 27.5
+	Unit  string // e.g. °C, or "" for pH and the indices
+}
+
+// String returns the value followed by its unit
+func (v Value) String() string {
+	if v.Unit == "" {
+		return v.Value
+	}
+	return v.Value + " " + v.Unit
+}
+
+// AlertLine is an out-of-range parameter
+type AlertLine struct {
+	Value
+	Status     string // low, high
+	Severity   string // warning, critical
+	IdealRange string
+}
+
+// newValue converts a stored value of a parameter to the unit system of f
+func newValue(f export.Filter, parameter string, value float64) Value {
+	return Value{
+		Name:  chemistry.GetParameterNames()[parameter],
+		Value: formatNumber(f.Value(parameter, value)),
+		Unit:  f.Unit(parameter),
+	}
+}
+
+// newAlertLine converts an alert to the unit system of f
+func newAlertLine(f export.Filter, a chemistry.Alert) AlertLine {
+	return AlertLine{
+		Value:      newValue(f, a.Parameter, a.Value),
+		Status:     a.Status,
+		Severity:   a.Severity,
+		IdealRange: chemistry.GetIdealRanges()[a.Parameter],
+	}
+}
+
+// formatNumber rounds to two decimals and drops trailing zeros
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
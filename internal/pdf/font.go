@@ -0,0 +1,108 @@
+package pdf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Font is one of the standard PDF fonts
+type Font struct {
+	name     string
+	resource string
+	widths   [95]int // Glyph widths of ' ' to '~' in 1/1000 em
+}
+
+// Standard fonts
+var (
+	Helvetica = Font{name: "Helvetica", resource: "F1", widths: [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}}
+	HelveticaBold = Font{name: "Helvetica-Bold", resource: "F2", widths: [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}}
+)
+
+// Width returns the width of a line of text in points
+func (f Font) Width(s string, size float64) float64 {
+	total := 0
+	for _, b := range []byte(encode(s)) {
+		switch {
+		case b >= ' ' && b <= '~':
+			total += f.widths[b-' ']
+		case b == 0xb0: // °
+			total += 400
+		case b == 0x85: // …
+			total += 1000
+		default:
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Wrap breaks text into lines no wider than width, keeping its line breaks.
+// Words longer than a line are broken between characters.
+func (f Font) Wrap(s string, size, width float64) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		line, first := "", len(lines)
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if f.Width(candidate, size) <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			for f.Width(word, size) > width {
+				runes := []rune(word)
+				n := len(runes) - 1
+				for n > 1 && f.Width(string(runes[:n]), size) > width {
+					n--
+				}
+				// A character wider than the line still takes a line of its own
+				n = max(n, 1)
+				lines = append(lines, string(runes[:n]))
+				word = string(runes[n:])
+			}
+			line = word
+		}
+		// Empty paragraphs keep their line
+		if line != "" || len(lines) == first {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Truncate shortens text to fit width, ending it with an ellipsis
+func (f Font) Truncate(s string, size, width float64) string {
+	if f.Width(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for n := len(runes) - 1; n > 0; n-- {
+		if t := strings.TrimSpace(string(runes[:n])) + "…"; f.Width(t, size) <= width {
+			return t
+		}
+	}
+	return ""
+}
+
+func (f Font) dictionary() string {
+	return fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.name)
+}
@@ -0,0 +1,192 @@
+// Package pdf writes simple PDF documents without external tools.
+//
+// Documents are drawn with the standard Helvetica fonts, which every PDF
+// viewer provides, so nothing needs to be embedded. Text is encoded as
+// WinAnsi (Windows-1252); characters outside it are replaced with '?'.
+// Coordinates are in points from the top left corner of the page.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Page sizes in points
+var (
+	Letter = Size{Width: 612, Height: 792}
+	A4     = Size{Width: 595.28, Height: 841.89}
+)
+
+// Size is a page size in points
+type Size struct {
+	Width  float64
+	Height float64
+}
+
+// Color is an RGB color with components from 0 to 1
+type Color struct {
+	R, G, B float64
+}
+
+// Common colors
+var (
+	Black = Color{0, 0, 0}
+	Gray  = Color{0.45, 0.45, 0.45}
+	White = Color{1, 1, 1}
+)
+
+// Document is a PDF document under construction
+type Document struct {
+	Size    Size
+	Title   string
+	Author  string
+	Created time.Time
+	pages   []*Page
+}
+
+// Page is one page of a document
+type Page struct {
+	size    Size
+	content bytes.Buffer
+}
+
+// New returns an empty document with pages of the given size
+func New(size Size) *Document {
+	return &Document{Size: size, Created: time.Now()}
+}
+
+// AddPage appends a blank page
+func (d *Document) AddPage() *Page {
+	p := &Page{size: d.Size}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text draws a line of text with its baseline at y
+func (p *Page) Text(x, y float64, font Font, size float64, color Color, s string) {
+	fmt.Fprintf(&p.content, "BT %s rg /%s %s Tf %s %s Td (%s) Tj ET\n",
+		color.operands(), font.resource, num(size), num(x), num(p.size.Height-y), escape(encode(s)))
+}
+
+// TextRight draws a line of text ending at x
+func (p *Page) TextRight(x, y float64, font Font, size float64, color Color, s string) {
+	p.Text(x-font.Width(s, size), y, font, size, color, s)
+}
+
+// Line draws a straight line
+func (p *Page) Line(x1, y1, x2, y2, width float64, color Color) {
+	fmt.Fprintf(&p.content, "%s RG %s w %s %s m %s %s l S\n",
+		color.operands(), num(width), num(x1), num(p.size.Height-y1), num(x2), num(p.size.Height-y2))
+}
+
+// FillRect fills a rectangle whose top left corner is at x, y
+func (p *Page) FillRect(x, y, width, height float64, color Color) {
+	fmt.Fprintf(&p.content, "%s rg %s %s %s %s re f\n",
+		color.operands(), num(x), num(p.size.Height-y-height), num(width), num(height))
+}
+
+// WriteTo writes the document as PDF 1.4
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3-4 fonts, 5 info, then a page and a content
+	// stream per page
+	const firstPage = 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(Helvetica.dictionary())
+	object(HelveticaBold.dictionary())
+	object(fmt.Sprintf("<< /Title (%s) /Author (%s) /Producer (Waterlogger) /CreationDate (%s) >>",
+		escape(encode(d.Title)), escape(encode(d.Author)), d.Created.UTC().Format("D:20060102150405Z")))
+
+	for i, p := range d.pages {
+		var stream bytes.Buffer
+		zw := zlib.NewWriter(&stream)
+		if _, err := zw.Write(p.content.Bytes()); err != nil {
+			return 0, err
+		}
+		if err := zw.Close(); err != nil {
+			return 0, err
+		}
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /%s %d 0 R /%s %d 0 R >> >> /Contents %d 0 R >>",
+			num(p.size.Width), num(p.size.Height), Helvetica.resource, 3, HelveticaBold.resource, 4, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func (c Color) operands() string {
+	return fmt.Sprintf("%s %s %s", num(c.R), num(c.G), num(c.B))
+}
+
+// num formats a number with at most two decimals, as PDF has no exponents
+func num(v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+// escape quotes a string for a PDF literal string
+func escape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", `\r`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// winAnsi maps the characters of Windows-1252 outside Latin-1
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// encode converts UTF-8 text to WinAnsi bytes
+func encode(s string) string {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r == '\t':
+			out = append(out, ' ')
+		case r < 0x20:
+			// Other control characters have no glyph
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			out = append(out, byte(r))
+		default:
+			if b, ok := winAnsi[r]; ok {
+				out = append(out, b)
+			} else {
+				out = append(out, '?')
+			}
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,279 @@
+// Package report renders custom reports from text and HTML templates.
+//
+// Templates are executed against a Data value. See docs/REPORTS.md for the
+// data model and the template functions.
+package report
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/export"
+	"waterlogger/internal/models"
+)
+
+// Data is the root value templates are executed against
+type Data struct {
+	Title       string
+	GeneratedAt time.Time // UTC
+	From        *time.Time
+	To          *time.Time
+	Units       string      // imperial or metric
+	Parameters  []Parameter // Exported parameters, in display order
+	Pools       []Pool
+	Kits        []models.Kit
+	Alerts      []Alert // Alerts of every pool
+	SampleCount int
+}
+
+// Parameter describes a measured or calculated parameter
+type Parameter struct {
+	Key        string // e.g. fc
+	Name       string // e.g. Free Chlorine
+	Unit       string // in the report's unit system, e.g. ppm, °C, or ""
+	Label      string // Name and unit, e.g. Free Chlorine (ppm)
+	IdealRange string // e.g. 1.0 - 4.0 ppm, or ""
+}
+
+// Pool is a pool with its samples, statistics and alerts
+type Pool struct {
+	ID                uint
+	Name              string
+	Type              string // pool, hot_tub
+	Volume            *float64
+	VolumeUnit        string // gal or L
+	SystemDescription string
+	Samples           []Sample // Sorted as requested, oldest first by default
+	Latest            *Sample  // Most recent sample, nil without samples
+	Stats             []Stat   // Parameters with at least one value
+	Alerts            []Alert  // Out-of-range values of the latest sample
+}
+
+// Sample is one water test. Values holds the recorded parameters, converted
+// to the report's unit system; parameters that were not recorded are absent.
+type Sample struct {
+	ID           uint
+	Time         time.Time // UTC
+	Pool         string
+	User         string
+	Kit          string
+	Values       map[string]float64
+	Appearance   string
+	Maintenance  string
+	IndexComment string
+	Notes        string
+	Additions    []Addition
+}
+
+// Addition is a chemical added after a sample
+type Addition struct {
+	Chemical string
+	Amount   float64
+	Unit     string
+	Notes    string
+}
+
+// Stat summarizes one parameter over a pool's samples
+type Stat struct {
+	Parameter Parameter
+	Count     int
+	Min       float64
+	Max       float64
+	Average   float64
+	Latest    *float64 // Value in the latest sample, nil when not recorded there
+}
+
+// Alert is a parameter of a pool's latest sample outside its ideal range
+type Alert struct {
+	Pool      string
+	Time      time.Time
+	Parameter Parameter
+	Value     float64
+	Status    string // low, high
+	Severity  string // warning, critical
+	Message   string
+}
+
+// Build collects the report data for the pools and samples selected by the filter
+func Build(db *gorm.DB, f export.Filter, now time.Time) (*Data, error) {
+	var pools []models.Pool
+	if err := db.Scopes(f.Pools).Find(&pools).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch pools: %w", err)
+	}
+	var kits []models.Kit
+	if err := db.Order("name ASC").Order("id ASC").Find(&kits).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch kits: %w", err)
+	}
+	var samples []models.Sample
+	err := db.Preload("Pool").Preload("User").Preload("Kit").
+		Preload("Measurements").Preload("Indices").Preload("Additions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id ASC")
+	}).Scopes(f.Samples).Find(&samples).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch samples: %w", err)
+	}
+
+	data := &Data{
+		Title:       "Waterlogger Report",
+		GeneratedAt: now.UTC(),
+		Units:       string(chemistry.Imperial),
+		Kits:        kits,
+		SampleCount: len(samples),
+	}
+	if f.Units == chemistry.Metric {
+		data.Units = string(chemistry.Metric)
+	}
+	if !f.From.IsZero() {
+		from := f.From
+		data.From = &from
+	}
+	if !f.To.IsZero() {
+		to := f.To
+		data.To = &to
+	}
+
+	parameters := make(map[string]Parameter)
+	ranges := chemistry.GetIdealRanges()
+	names := chemistry.GetParameterNames()
+	for _, key := range append(f.MeasurementParameters(), f.IndexParameters()...) {
+		p := Parameter{Key: key, Name: names[key], Unit: f.Unit(key), Label: f.Label(key), IdealRange: ranges[key]}
+		parameters[key] = p
+		data.Parameters = append(data.Parameters, p)
+	}
+
+	byPool := make(map[uint][]*models.Sample)
+	for i := range samples {
+		byPool[samples[i].PoolID] = append(byPool[samples[i].PoolID], &samples[i])
+	}
+
+	for _, p := range pools {
+		pool := Pool{
+			ID:                p.ID,
+			Name:              p.Name,
+			Type:              p.Type,
+			VolumeUnit:        f.Unit("volume"),
+			SystemDescription: stringValue(p.SystemDescription),
+		}
+		if p.VolumeGallons != nil {
+			volume := f.Value("volume", *p.VolumeGallons)
+			pool.Volume = &volume
+		}
+
+		var latest *models.Sample
+		for _, s := range byPool[p.ID] {
+			pool.Samples = append(pool.Samples, newSample(s, data.Parameters, f))
+			if latest == nil || !s.SampleDateTime.Before(latest.SampleDateTime) {
+				latest = s
+			}
+		}
+		if latest != nil {
+			for i := range pool.Samples {
+				if pool.Samples[i].ID == latest.ID {
+					pool.Latest = &pool.Samples[i]
+				}
+			}
+			pool.Stats = poolStats(pool.Samples, pool.Latest, data.Parameters)
+
+			for _, a := range chemistry.CheckSample(latest) {
+				parameter, ok := parameters[a.Parameter]
+				if !ok {
+					continue
+				}
+				pool.Alerts = append(pool.Alerts, Alert{
+					Pool:      p.Name,
+					Time:      latest.SampleDateTime.UTC(),
+					Parameter: parameter,
+					Value:     f.Value(a.Parameter, a.Value),
+					Status:    a.Status,
+					Severity:  a.Severity,
+					Message:   a.Message,
+				})
+			}
+			data.Alerts = append(data.Alerts, pool.Alerts...)
+		}
+
+		data.Pools = append(data.Pools, pool)
+	}
+
+	// Critical alerts first, then by pool
+	sort.SliceStable(data.Alerts, func(i, j int) bool {
+		return data.Alerts[i].Severity == chemistry.SeverityCritical && data.Alerts[j].Severity != chemistry.SeverityCritical
+	})
+
+	return data, nil
+}
+
+func newSample(s *models.Sample, parameters []Parameter, f export.Filter) Sample {
+	sample := Sample{
+		ID:     s.ID,
+		Time:   s.SampleDateTime.UTC(),
+		Values: make(map[string]float64),
+		Notes:  s.Notes,
+	}
+	if s.Pool != nil {
+		sample.Pool = s.Pool.Name
+	}
+	if s.User != nil {
+		sample.User = s.User.Username
+	}
+	if s.Kit != nil {
+		sample.Kit = s.Kit.Name
+	}
+	if m := s.Measurements; m != nil {
+		sample.Appearance = stringValue(m.Appearance)
+		sample.Maintenance = stringValue(m.Maintenance)
+	}
+	if idx := s.Indices; idx != nil {
+		sample.IndexComment = stringValue(idx.Comment)
+	}
+	for _, p := range parameters {
+		if value, ok := s.ParameterValue(p.Key); ok {
+			sample.Values[p.Key] = f.Value(p.Key, value)
+		}
+	}
+	for _, a := range s.Additions {
+		sample.Additions = append(sample.Additions, Addition{
+			Chemical: a.Chemical,
+			Amount:   a.Amount,
+			Unit:     a.Unit,
+			Notes:    stringValue(a.Notes),
+		})
+	}
+	return sample
+}
+
+func poolStats(samples []Sample, latest *Sample, parameters []Parameter) []Stat {
+	var stats []Stat
+	for _, p := range parameters {
+		stat := Stat{Parameter: p, Min: math.Inf(1), Max: math.Inf(-1)}
+		var sum float64
+		for _, s := range samples {
+			if value, ok := s.Values[p.Key]; ok {
+				stat.Count++
+				sum += value
+				stat.Min = math.Min(stat.Min, value)
+				stat.Max = math.Max(stat.Max, value)
+			}
+		}
+		if stat.Count == 0 {
+			continue
+		}
+		stat.Average = sum / float64(stat.Count)
+		if value, ok := latest.Values[p.Key]; ok {
+			stat.Latest = &value
+		}
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
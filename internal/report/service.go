@@ -0,0 +1,390 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+	"waterlogger/internal/pdf"
+)
+
+// ServiceReportContentType is the MIME type of service reports
+const ServiceReportContentType = "application/pdf"
+
+// serviceParameters are the measurements on a service report, in order
+var serviceParameters = []string{"fc", "tc", "ph", "ta", "ch", "cya", "temperature", "salinity", "tds", "orp"}
+
+var (
+	colorCritical = pdf.Color{R: 0.75, G: 0.1, B: 0.1}
+	colorWarning  = pdf.Color{R: 0.8, G: 0.45, B: 0}
+	colorOK       = pdf.Color{R: 0.1, G: 0.5, B: 0.2}
+	colorShade    = pdf.Color{R: 0.93, G: 0.95, B: 0.97}
+	colorRule     = pdf.Color{R: 0.75, G: 0.75, B: 0.75}
+)
+
+const (
+	margin     = 50.0
+	bodySize   = 10.0
+	smallSize  = 8.0
+	lineHeight = 14.0
+)
+
+// ServiceReportFilename returns the download name of a sample's service
+// report, e.g. WL20240714_Backyard-Pool_12.pdf
+func ServiceReportFilename(s *models.Sample) string {
+	pool := "pool"
+	if s.Pool != nil {
+		pool = strings.Trim(unsafeFilename.ReplaceAllString(s.Pool.Name, "-"), "-")
+	}
+	return fmt.Sprintf("WL%s_%s_%d.pdf", s.SampleDateTime.UTC().Format("20060102"), pool, s.ID)
+}
+
+var unsafeFilename = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// WriteServiceReport writes a one-page PDF report of a pool visit: the pool,
+// the measurements in both unit systems, the water balance indices, the
+// chemicals added and the notes. The sample must be loaded with its pool,
+// user, kit, measurements, indices and additions. Sections that do not fit
+// on the page are shortened.
+func WriteServiceReport(w io.Writer, s *models.Sample, size pdf.Size, now time.Time) error {
+	doc := pdf.New(size)
+	doc.Title = "Pool Service Report"
+	doc.Created = now
+	if s.User != nil {
+		doc.Author = s.User.Username
+	}
+
+	r := &serviceReport{page: doc.AddPage(), size: size, y: margin}
+	// Keep room for the footer
+	r.bottom = size.Height - margin - 2*lineHeight
+
+	r.header(s)
+	r.measurements(s)
+	r.balance(s)
+	r.additions(s)
+	r.notes(s)
+	r.footer(s, now)
+
+	_, err := doc.WriteTo(w)
+	return err
+}
+
+// serviceReport lays out a service report from top to bottom
+type serviceReport struct {
+	page   *pdf.Page
+	size   pdf.Size
+	y      float64 // Baseline of the next line
+	bottom float64 // Lowest baseline available to content
+}
+
+func (r *serviceReport) width() float64 {
+	return r.size.Width - 2*margin
+}
+
+// fits reports whether n more lines fit on the page
+func (r *serviceReport) fits(n int) bool {
+	return r.y+float64(n-1)*lineHeight <= r.bottom
+}
+
+func (r *serviceReport) header(s *models.Sample) {
+	r.y += 18
+	r.page.Text(margin, r.y, pdf.HelveticaBold, 20, pdf.Black, "Pool Service Report")
+	r.page.TextRight(margin+r.width(), r.y, pdf.Helvetica, bodySize, pdf.Gray, "Waterlogger")
+	r.y += 10
+	r.page.Line(margin, r.y, margin+r.width(), r.y, 1, pdf.Black)
+	r.y += 20
+
+	pool, volume, system := "", "", ""
+	if p := s.Pool; p != nil {
+		pool = p.Name
+		if p.Type == "hot_tub" {
+			pool += " (hot tub)"
+		}
+		if p.VolumeGallons != nil {
+			converted := chemistry.ConvertVolume(*p.VolumeGallons, chemistry.Imperial)
+			volume = fmt.Sprintf("%s gal (%s L)", formatThousands(converted.Value), formatThousands(converted.Converted))
+		}
+		system = stringValue(p.SystemDescription)
+	}
+	user, kit := "", ""
+	if s.User != nil {
+		user = s.User.Username
+	}
+	if s.Kit != nil {
+		kit = s.Kit.Name
+	}
+
+	half := r.width() / 2
+	left := [][2]string{{"Pool", pool}, {"Volume", volume}, {"System", system}}
+	right := [][2]string{{"Visit", s.SampleDateTime.UTC().Format("2006-01-02 15:04") + " UTC"}, {"Technician", user}, {"Test kit", kit}}
+	for i := range left {
+		r.field(margin, half-10, left[i][0], left[i][1])
+		r.field(margin+half, half, right[i][0], right[i][1])
+		r.y += lineHeight
+	}
+	r.y += 6
+}
+
+// field draws a label and a value truncated to width
+func (r *serviceReport) field(x, width float64, label, value string) {
+	if value == "" {
+		value = "-"
+	}
+	r.page.Text(x, r.y, pdf.HelveticaBold, bodySize, pdf.Black, label+":")
+	offset := 68.0
+	r.page.Text(x+offset, r.y, pdf.Helvetica, bodySize, pdf.Black,
+		pdf.Helvetica.Truncate(value, bodySize, width-offset))
+}
+
+func (r *serviceReport) heading(title string) {
+	r.y += 12
+	r.page.Text(margin, r.y, pdf.HelveticaBold, 13, pdf.Black, title)
+	r.y += 6
+	r.page.Line(margin, r.y, margin+r.width(), r.y, 0.5, colorRule)
+	r.y += lineHeight
+}
+
+// column is a table column: its left edge relative to the margin, and its width
+type column struct {
+	title string
+	x     float64
+	width float64
+	right bool
+}
+
+// cell is a table cell, drawn in black unless a color is given
+type cell struct {
+	text  string
+	color *pdf.Color
+}
+
+func (r *serviceReport) tableHeader(columns []column) {
+	r.page.FillRect(margin, r.y-lineHeight+3, r.width(), lineHeight, colorShade)
+	r.row(columns, pdf.HelveticaBold, titles(columns))
+}
+
+func (r *serviceReport) row(columns []column, font pdf.Font, cells []cell) {
+	for i, c := range columns {
+		if cells[i].text == "" {
+			continue
+		}
+		color := pdf.Black
+		if cells[i].color != nil {
+			color = *cells[i].color
+		}
+		text := font.Truncate(cells[i].text, bodySize, c.width-6)
+		if c.right {
+			r.page.TextRight(margin+c.x+c.width-6, r.y, font, bodySize, color, text)
+		} else {
+			r.page.Text(margin+c.x+3, r.y, font, bodySize, color, text)
+		}
+	}
+	r.y += lineHeight
+}
+
+func titles(columns []column) []cell {
+	cells := make([]cell, len(columns))
+	for i, c := range columns {
+		cells[i] = cell{text: c.title}
+	}
+	return cells
+}
+
+func (r *serviceReport) measurements(s *models.Sample) {
+	r.heading("Measurements")
+	columns := []column{
+		{title: "Parameter", x: 0, width: 150},
+		{title: "Imperial", x: 150, width: 80, right: true},
+		{title: "Metric", x: 230, width: 80, right: true},
+		{title: "Ideal range", x: 318, width: 120},
+		{title: "Status", x: 438, width: r.width() - 438},
+	}
+	r.tableHeader(columns)
+
+	names := chemistry.GetParameterNames()
+	ranges := chemistry.GetIdealRangeLimits()
+	recorded := 0
+	for _, parameter := range serviceParameters {
+		value, ok := s.ParameterValue(parameter)
+		if !ok {
+			continue
+		}
+		recorded++
+
+		converted := chemistry.ConvertMeasurement(value, parameter, chemistry.Imperial)
+		idealRange := ""
+		if limits, ok := ranges[parameter]; ok {
+			idealRange = strings.TrimSpace(fmt.Sprintf("%s - %s %s", formatNumber(limits.Min), formatNumber(limits.Max), converted.Unit))
+		}
+		r.row(columns, pdf.Helvetica, []cell{
+			{text: names[parameter]},
+			{text: withUnit(converted.Value, converted.Unit)},
+			{text: withUnit(converted.Converted, converted.ConvertedUnit)},
+			{text: idealRange},
+			status(parameter, value),
+		})
+	}
+	if recorded == 0 {
+		r.page.Text(margin+3, r.y, pdf.Helvetica, bodySize, pdf.Gray, "No measurements recorded")
+		r.y += lineHeight
+	}
+}
+
+// status returns the status cell of a measurement against its ideal range
+func status(parameter string, value float64) cell {
+	if _, ok := chemistry.GetIdealRangeLimits()[parameter]; !ok {
+		return cell{}
+	}
+	alert, ok := chemistry.CheckValue(parameter, value)
+	if !ok {
+		return cell{text: "OK", color: &colorOK}
+	}
+	text := strings.ToUpper(alert.Status[:1]) + alert.Status[1:]
+	if alert.Severity == chemistry.SeverityCritical {
+		return cell{text: text + " (critical)", color: &colorCritical}
+	}
+	return cell{text: text, color: &colorWarning}
+}
+
+func (r *serviceReport) balance(s *models.Sample) {
+	r.heading("Water Balance")
+	idx := s.Indices
+	if idx == nil || (idx.LSI == nil && idx.RSI == nil) {
+		r.page.Text(margin+3, r.y, pdf.Helvetica, bodySize, pdf.Gray, "Not calculated: pH was not recorded")
+		r.y += lineHeight
+		return
+	}
+
+	columns := []column{
+		{title: "Index", x: 0, width: 150},
+		{title: "Value", x: 150, width: 80, right: true},
+		{title: "Interpretation", x: 238, width: r.width() - 238},
+	}
+	r.tableHeader(columns)
+	if idx.LSI != nil {
+		r.row(columns, pdf.Helvetica, []cell{
+			{text: "Langelier (LSI)"},
+			{text: formatNumber(*idx.LSI)},
+			{text: chemistry.InterpretLSI(*idx.LSI), color: indexColor("lsi", *idx.LSI)},
+		})
+	}
+	if idx.RSI != nil {
+		r.row(columns, pdf.Helvetica, []cell{
+			{text: "Ryznar (RSI)"},
+			{text: formatNumber(*idx.RSI)},
+			{text: chemistry.InterpretRSI(*idx.RSI), color: indexColor("rsi", *idx.RSI)},
+		})
+	}
+	if idx.Comment != nil {
+		for _, line := range pdf.Helvetica.Wrap(*idx.Comment, smallSize, r.width()-6) {
+			r.page.Text(margin+3, r.y, pdf.Helvetica, smallSize, pdf.Gray, line)
+			r.y += smallSize + 3
+		}
+	}
+}
+
+func indexColor(parameter string, value float64) *pdf.Color {
+	return status(parameter, value).color
+}
+
+func (r *serviceReport) additions(s *models.Sample) {
+	r.heading("Chemicals Added")
+	if len(s.Additions) == 0 {
+		r.page.Text(margin+3, r.y, pdf.Helvetica, bodySize, pdf.Gray, "None")
+		r.y += lineHeight
+		return
+	}
+
+	columns := []column{
+		{title: "Chemical", x: 0, width: 180},
+		{title: "Amount", x: 180, width: 90, right: true},
+		{title: "Notes", x: 278, width: r.width() - 278},
+	}
+	r.tableHeader(columns)
+	// Leave room for the notes heading and a line of notes
+	for i, a := range s.Additions {
+		if !r.fits(5) && i < len(s.Additions)-1 {
+			r.page.Text(margin+3, r.y, pdf.Helvetica, bodySize, pdf.Gray,
+				fmt.Sprintf("and %d more", len(s.Additions)-i))
+			r.y += lineHeight
+			return
+		}
+		r.row(columns, pdf.Helvetica, []cell{
+			{text: a.Chemical},
+			{text: strings.TrimSpace(formatNumber(a.Amount) + " " + a.Unit)},
+			{text: stringValue(a.Notes)},
+		})
+	}
+}
+
+func (r *serviceReport) notes(s *models.Sample) {
+	var paragraphs [][2]string
+	if m := s.Measurements; m != nil {
+		if v := strings.TrimSpace(stringValue(m.Appearance)); v != "" {
+			paragraphs = append(paragraphs, [2]string{"Appearance", v})
+		}
+		if v := strings.TrimSpace(stringValue(m.Maintenance)); v != "" {
+			paragraphs = append(paragraphs, [2]string{"Maintenance", v})
+		}
+	}
+	if v := strings.TrimSpace(s.Notes); v != "" {
+		paragraphs = append(paragraphs, [2]string{"Notes", v})
+	}
+
+	r.heading("Notes")
+	if len(paragraphs) == 0 {
+		r.page.Text(margin+3, r.y, pdf.Helvetica, bodySize, pdf.Gray, "None")
+		r.y += lineHeight
+		return
+	}
+
+	for _, p := range paragraphs {
+		if !r.fits(2) {
+			return
+		}
+		r.page.Text(margin+3, r.y, pdf.HelveticaBold, bodySize, pdf.Black, p[0])
+		r.y += lineHeight
+		lines := pdf.Helvetica.Wrap(p[1], bodySize, r.width()-6)
+		for i, line := range lines {
+			if !r.fits(1) {
+				break
+			}
+			// Mark text cut off at the bottom of the page
+			if i < len(lines)-1 && !r.fits(2) {
+				line = pdf.Helvetica.Truncate(line+" …", bodySize, r.width()-6)
+			}
+			r.page.Text(margin+3, r.y, pdf.Helvetica, bodySize, pdf.Black, line)
+			r.y += lineHeight
+		}
+		r.y += 4
+	}
+}
+
+func (r *serviceReport) footer(s *models.Sample, now time.Time) {
+	y := r.size.Height - margin
+	r.page.Line(margin, y-lineHeight, margin+r.width(), y-lineHeight, 0.5, colorRule)
+	r.page.Text(margin, y, pdf.Helvetica, smallSize, pdf.Gray,
+		fmt.Sprintf("Generated by Waterlogger on %s UTC", now.UTC().Format("2006-01-02 15:04")))
+	r.page.TextRight(margin+r.width(), y, pdf.Helvetica, smallSize, pdf.Gray, fmt.Sprintf("Sample #%d", s.ID))
+}
+
+func withUnit(value float64, unit string) string {
+	return strings.TrimSpace(formatNumber(value) + " " + unit)
+}
+
+// formatThousands rounds to a whole number with thousands separators
+func formatThousands(value float64) string {
+	digits := fmt.Sprintf("%.0f", value)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	for i := len(digits) - 3; i > 0; i -= 3 {
+		digits = digits[:i] + "," + digits[i:]
+	}
+	return sign + digits
+}
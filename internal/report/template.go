@@ -0,0 +1,237 @@
+package report
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"waterlogger/internal/export"
+	"waterlogger/internal/models"
+)
+
+// Template formats
+const (
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatText     = "text"
+)
+
+// Formats lists the supported template formats
+var Formats = []string{FormatMarkdown, FormatHTML, FormatText}
+
+// MaxTemplateSize is the largest template that can be uploaded, in bytes
+const MaxTemplateSize = 256 * 1024
+
+var (
+	// ErrInvalidName is returned for template names that are not a lowercase slug
+	ErrInvalidName = errors.New("template names may only contain lowercase letters, digits, '-' and '_'")
+	// ErrUnknownFormat is returned for formats other than markdown, html and text
+	ErrUnknownFormat = errors.New("template format must be markdown, html or text")
+	// ErrTemplateTooLarge is returned for templates over MaxTemplateSize
+	ErrTemplateTooLarge = fmt.Errorf("templates may not be larger than %d KB", MaxTemplateSize/1024)
+)
+
+var templateName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,99}$`)
+
+//go:embed templates/*.tmpl
+var builtinFiles embed.FS
+
+// Template is a built-in or custom report template
+type Template struct {
+	ID          uint   `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Format      string `json:"format"`
+	Description string `json:"description"`
+	Content     string `json:"content"`
+	BuiltIn     bool   `json:"built_in"`
+}
+
+// builtins are the templates shipped with Waterlogger, keyed by name
+var builtins = []Template{
+	{Name: "markdown", Format: FormatMarkdown, Description: "Markdown report with a summary, alerts and tables per pool"},
+	{Name: "html", Format: FormatHTML, Description: "Printable HTML report with a summary, alerts and tables per pool"},
+	{Name: "text", Format: FormatText, Description: "Plain text summary of the latest values and alerts per pool"},
+}
+
+func init() {
+	for i := range builtins {
+		content, err := builtinFiles.ReadFile("templates/" + builtins[i].Name + ".tmpl")
+		if err != nil {
+			panic(fmt.Sprintf("missing built-in report template %s: %v", builtins[i].Name, err))
+		}
+		builtins[i].Content = string(content)
+		builtins[i].BuiltIn = true
+	}
+}
+
+// BuiltinTemplates returns the templates shipped with Waterlogger
+func BuiltinTemplates() []Template {
+	return append([]Template(nil), builtins...)
+}
+
+// BuiltinTemplate returns the built-in template with the given name
+func BuiltinTemplate(name string) (Template, bool) {
+	for _, t := range builtins {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// FromModel converts a stored custom template
+func FromModel(m models.ReportTemplate) Template {
+	t := Template{ID: m.ID, Name: m.Name, Format: m.Format, Content: m.Content}
+	if m.Description != nil {
+		t.Description = *m.Description
+	}
+	return t
+}
+
+// Validate checks the name, format and size of a template and that it parses
+func Validate(name, format, content string) error {
+	if !templateName.MatchString(name) {
+		return ErrInvalidName
+	}
+	if len(content) > MaxTemplateSize {
+		return ErrTemplateTooLarge
+	}
+	_, err := compile(Template{Name: name, Format: format, Content: content})
+	return err
+}
+
+// ContentType returns the MIME type of a rendered template
+func (t Template) ContentType() string {
+	switch t.Format {
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Extension returns the file extension of a rendered template
+func (t Template) Extension() string {
+	switch t.Format {
+	case FormatHTML:
+		return ".html"
+	case FormatMarkdown:
+		return ".md"
+	}
+	return ".txt"
+}
+
+// Render executes the template against data
+func (t Template) Render(w io.Writer, data *Data) error {
+	exec, err := compile(t)
+	if err != nil {
+		return err
+	}
+	if err := exec(w, data); err != nil {
+		return fmt.Errorf("failed to render template %s: %w", t.Name, err)
+	}
+	return nil
+}
+
+// compile parses a template, using html/template for HTML so that values
+// are escaped, and text/template otherwise
+func compile(t Template) (func(io.Writer, *Data) error, error) {
+	switch t.Format {
+	case FormatHTML:
+		tmpl, err := htmltemplate.New(t.Name).Funcs(htmltemplate.FuncMap(templateFuncs)).Parse(t.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return func(w io.Writer, data *Data) error { return tmpl.Execute(w, data) }, nil
+	case FormatMarkdown, FormatText:
+		tmpl, err := texttemplate.New(t.Name).Funcs(templateFuncs).Parse(t.Content)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		return func(w io.Writer, data *Data) error { return tmpl.Execute(w, data) }, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// maxRepeat is the largest count of repeat and width of pad, so an uploaded
+// template cannot exhaust memory while rendering
+const maxRepeat = 1000
+
+// templateFuncs are available in every template; see docs/REPORTS.md
+var templateFuncs = texttemplate.FuncMap{
+	"number":   formatNumber,
+	"value":    formatValue,
+	"date":     func(t time.Time) string { return t.UTC().Format("2006-01-02") },
+	"datetime": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04") },
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     strings.Join,
+	"repeat":   repeat,
+	"cell":     export.EscapeTableCell,
+	"pad":      pad,
+	"add":      func(a, b int) int { return a + b },
+}
+
+// repeat repeats a string up to maxRepeat times
+func repeat(s string, count int) (string, error) {
+	if count < 0 || count > maxRepeat {
+		return "", fmt.Errorf("repeat count must be between 0 and %d", maxRepeat)
+	}
+	return strings.Repeat(s, count), nil
+}
+
+// pad pads a string with spaces to a width of up to maxRepeat
+func pad(width int, s string) (string, error) {
+	if width < 0 || width > maxRepeat {
+		return "", fmt.Errorf("pad width must be between 0 and %d", maxRepeat)
+	}
+	return fmt.Sprintf("%-*s", width, s), nil
+}
+
+// formatNumber formats numbers like Markdown exports. It accepts float64,
+// *float64 (nil gives "") and integers.
+func formatNumber(value interface{}) string {
+	switch v := value.(type) {
+	case float64:
+		return export.FormatNumber(v)
+	case *float64:
+		if v == nil {
+			return ""
+		}
+		return formatNumber(*v)
+	case int:
+		return strconv.Itoa(v)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	}
+	return fmt.Sprint(value)
+}
+
+// formatValue formats a parameter of a sample, or returns "" when it was
+// not recorded
+func formatValue(sample interface{}, key string) string {
+	var values map[string]float64
+	switch s := sample.(type) {
+	case Sample:
+		values = s.Values
+	case *Sample:
+		if s == nil {
+			return ""
+		}
+		values = s.Values
+	default:
+		return ""
+	}
+	if v, ok := values[key]; ok {
+		return formatNumber(v)
+	}
+	return ""
+}
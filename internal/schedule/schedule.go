@@ -0,0 +1,142 @@
+// Package schedule works out when pools are next due for testing from their
+// test schedules and latest samples.
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// Schedule periods
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// maxGraceHours is the longest grace period a schedule may have
+const maxGraceHours = 168
+
+// ErrNoSchedule is returned for pools without a test schedule
+var ErrNoSchedule = errors.New("pool has no test schedule")
+
+// periods are the lengths of the schedule periods. Months count as 30 days.
+var periods = map[string]time.Duration{
+	PeriodDay:   24 * time.Hour,
+	PeriodWeek:  7 * 24 * time.Hour,
+	PeriodMonth: 30 * 24 * time.Hour,
+}
+
+// Check checks the period, number of tests and grace period of a schedule
+func Check(s models.TestSchedule) error {
+	period, ok := periods[s.Period]
+	if !ok {
+		return fmt.Errorf("period must be day, week or month")
+	}
+	if s.Times < 1 || time.Duration(s.Times) > period/time.Hour {
+		return fmt.Errorf("times must be between 1 and %d a %s", period/time.Hour, s.Period)
+	}
+	if s.GraceHours < 0 || s.GraceHours > maxGraceHours {
+		return fmt.Errorf("grace_hours must be between 0 and %d", maxGraceHours)
+	}
+	return nil
+}
+
+// Interval returns the time between tests of a schedule
+func Interval(s models.TestSchedule) time.Duration {
+	if s.Times < 1 {
+		return periods[s.Period]
+	}
+	return periods[s.Period] / time.Duration(s.Times)
+}
+
+// Describe returns a schedule in words, e.g. "daily" or "twice a week"
+func Describe(s models.TestSchedule) string {
+	switch s.Times {
+	case 1:
+		return map[string]string{PeriodDay: "daily", PeriodWeek: "weekly", PeriodMonth: "monthly"}[s.Period]
+	case 2:
+		return "twice a " + s.Period
+	}
+	return fmt.Sprintf("%d times a %s", s.Times, s.Period)
+}
+
+// Status is where a pool stands against its test schedule
+type Status struct {
+	PoolID       uint                 `json:"pool_id"`
+	Pool         string               `json:"pool"`
+	Schedule     *models.TestSchedule `json:"schedule"`
+	Description  string               `json:"description"` // e.g. twice a week
+	LastTested   *time.Time           `json:"last_tested"` // UTC, nil when never tested
+	NextDue      time.Time            `json:"next_due"`    // UTC
+	OverdueAt    time.Time            `json:"overdue_at"`  // NextDue plus the grace period
+	Overdue      bool                 `json:"overdue"`
+	OverdueHours float64              `json:"overdue_hours"` // Hours since the pool was due, 0 unless overdue
+}
+
+// Compute returns the status of a pool's schedule at a time. Pools never
+// tested are due from when the schedule was created.
+func Compute(db *gorm.DB, pool models.Pool, s models.TestSchedule, now time.Time) (*Status, error) {
+	now = now.UTC()
+	status := &Status{PoolID: pool.ID, Pool: pool.Name, Schedule: &s, Description: Describe(s)}
+
+	var times []time.Time
+	err := db.Model(&models.Sample{}).Where("pool_id = ?", pool.ID).
+		Order("sample_date_time DESC").Limit(1).Pluck("sample_date_time", &times).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the latest sample: %w", err)
+	}
+	if len(times) > 0 {
+		last := times[0].UTC()
+		status.LastTested = &last
+		status.NextDue = last.Add(Interval(s))
+	} else {
+		status.NextDue = s.CreatedAt.UTC()
+	}
+	status.OverdueAt = status.NextDue.Add(time.Duration(s.GraceHours) * time.Hour)
+	if now.After(status.OverdueAt) {
+		status.Overdue = true
+		status.OverdueHours = math.Round(now.Sub(status.NextDue).Hours()*10) / 10
+	}
+	return status, nil
+}
+
+// ForPool returns the status of a pool's schedule at a time
+func ForPool(db *gorm.DB, pool models.Pool, now time.Time) (*Status, error) {
+	var schedules []models.TestSchedule
+	if err := db.Where("pool_id = ?", pool.ID).Limit(1).Find(&schedules).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch test schedule: %w", err)
+	}
+	if len(schedules) == 0 {
+		return nil, ErrNoSchedule
+	}
+	return Compute(db, pool, schedules[0], now)
+}
+
+// All returns the status of every pool with a test schedule at a time, by
+// pool name
+func All(db *gorm.DB, now time.Time) ([]Status, error) {
+	var pools []models.Pool
+	err := db.Where("id IN (?)", db.Model(&models.TestSchedule{}).Select("pool_id")).
+		Order("name ASC").Find(&pools).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch scheduled pools: %w", err)
+	}
+
+	var statuses []Status
+	for _, pool := range pools {
+		status, err := ForPool(db, pool, now)
+		if errors.Is(err, ErrNoSchedule) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, *status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,47 @@
+package sensors
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// IndexSource is the source of index readings calculated from other readings
+const IndexSource = "calculated"
+
+// indexInputs are the parameters the indices are calculated from
+var indexInputs = []string{"ph", "temperature", "ch", "ta"}
+
+// RecordIndices calculates the LSI and RSI of a pool from the latest pH,
+// temperature, calcium hardness and total alkalinity at a time, whether
+// tested or read, and stores them as readings. It stores nothing while
+// the pool has no pH. Missing values use the same defaults as samples.
+func RecordIndices(db *gorm.DB, poolID uint, at time.Time) error {
+	var m models.Measurements
+	inputs := map[string]*float64{"ph": &m.PH, "temperature": &m.Temperature, "ch": &m.CH, "ta": &m.TA}
+	for _, parameter := range indexInputs {
+		latest, ok, err := LatestValue(db, poolID, parameter, at)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*inputs[parameter] = latest.Value
+		}
+	}
+	if m.PH == 0 {
+		return nil
+	}
+
+	indices, err := chemistry.CalculateIndices(&m)
+	if err != nil {
+		return fmt.Errorf("failed to calculate indices: %w", err)
+	}
+	at = at.UTC()
+	return StoreReadings(db, []models.SensorReading{
+		{PoolID: poolID, Parameter: "lsi", Value: round(*indices.LSI), Source: IndexSource, TakenAt: at, Count: 1},
+		{PoolID: poolID, Parameter: "rsi", Value: round(*indices.RSI), Source: IndexSource, TakenAt: at, Count: 1},
+	})
+}
@@ -0,0 +1,68 @@
+package sensors
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// TimedValue is a value of a parameter at a time
+type TimedValue struct {
+	Value  float64   `json:"value"`
+	At     time.Time `json:"at"`
+	Source string    `json:"source"` // sample or reading
+}
+
+// LatestTested returns the latest value of a measured or calculated
+// parameter of a pool recorded in a sample at or before a time. Zero values
+// of required measurements are not recorded, as in Sample.ParameterValue.
+func LatestTested(db *gorm.DB, poolID uint, parameter string, at time.Time) (TimedValue, bool, error) {
+	table := "measurements"
+	if models.IndexParameter(parameter) {
+		table = "indices"
+	} else if _, _, ok := chemistry.ValidRange(parameter); !ok {
+		return TimedValue{}, false, fmt.Errorf("%s is not a measured parameter", parameter)
+	}
+	column := table + "." + parameter
+	recorded := column + " IS NOT NULL"
+	if models.RequiredMeasurement(parameter) {
+		recorded = column + " <> 0"
+	}
+
+	var values []TimedValue
+	err := db.Table(table).
+		Select(column+" AS value", "samples.sample_date_time AS at").
+		Joins("JOIN samples ON samples.id = "+table+".sample_id").
+		Where("samples.pool_id = ? AND samples.deleted_at IS NULL AND "+table+".deleted_at IS NULL AND "+recorded, poolID).
+		Where("samples.sample_date_time <= ?", at).
+		Order("samples.sample_date_time DESC").Limit(1).Scan(&values).Error
+	if err != nil {
+		return TimedValue{}, false, fmt.Errorf("failed to fetch latest %s: %w", parameter, err)
+	}
+	if len(values) == 0 {
+		return TimedValue{}, false, nil
+	}
+	values[0].Source = "sample"
+	return values[0], true, nil
+}
+
+// LatestValue returns the latest value of a parameter of a pool at or
+// before a time, from samples or sensor readings, whichever is newer.
+// Index readings are those RecordIndices calculates.
+func LatestValue(db *gorm.DB, poolID uint, parameter string, at time.Time) (TimedValue, bool, error) {
+	tested, ok, err := LatestTested(db, poolID, parameter, at)
+	if err != nil {
+		return TimedValue{}, false, err
+	}
+	readings, err := ListReadings(db, ReadingFilter{PoolID: poolID, Parameters: []string{parameter}, To: at.Add(time.Nanosecond)}, 1)
+	if err != nil {
+		return TimedValue{}, false, err
+	}
+	if len(readings) > 0 && (!ok || readings[0].TakenAt.After(tested.At)) {
+		return TimedValue{Value: readings[0].Value, At: readings[0].TakenAt, Source: "reading"}, true, nil
+	}
+	return tested, ok, nil
+}
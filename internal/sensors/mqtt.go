@@ -0,0 +1,241 @@
+package sensors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/config"
+	"waterlogger/internal/models"
+	"waterlogger/internal/mqtt"
+)
+
+// maxSourceLength is the longest source of a reading
+const maxSourceLength = 100
+
+// Subscriber records the readings published to the configured MQTT topics
+type Subscriber struct {
+	db  *gorm.DB
+	cfg config.MQTTConfig
+}
+
+// NewSubscriber checks the topic configuration
+func NewSubscriber(db *gorm.DB, cfg config.MQTTConfig) (*Subscriber, error) {
+	if cfg.Broker == "" {
+		return nil, errors.New("no MQTT broker is configured")
+	}
+	if len(cfg.Topics) == 0 {
+		return nil, errors.New("no MQTT topics are configured")
+	}
+	for i, t := range cfg.Topics {
+		if t.Topic == "" || t.Pool == "" {
+			return nil, fmt.Errorf("MQTT topic %d needs a topic and a pool", i+1)
+		}
+		if (t.Parameter == "") == (len(t.Fields) == 0) {
+			return nil, fmt.Errorf("MQTT topic %s needs either a parameter or fields", t.Topic)
+		}
+		for _, parameter := range topicParameters(t) {
+			if _, _, ok := chemistry.ValidRange(parameter); !ok {
+				return nil, fmt.Errorf("MQTT topic %s: %s is not a measured parameter", t.Topic, parameter)
+			}
+		}
+	}
+
+	if cfg.ClientID == "" {
+		cfg.ClientID = "waterlogger"
+	}
+	return &Subscriber{db: db, cfg: cfg}, nil
+}
+
+// Handle records the readings of a message as sensor readings taken at a
+// time, with the topic as their source, through RecordReadings like the
+// batch endpoint. Retained messages are ignored, as they were published
+// before the subscription and would be recorded at the wrong time.
+func (s *Subscriber) Handle(msg mqtt.Message, now time.Time) error {
+	if msg.Retain {
+		return nil
+	}
+	var errs []error
+	for _, t := range s.cfg.Topics {
+		if !mqtt.Match(t.Topic, msg.Topic) {
+			continue
+		}
+		if err := s.record(t, msg, now); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", msg.Topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// record stores the readings of a message published to a topic, and the
+// indices of its pool when the message changes their inputs
+func (s *Subscriber) record(t config.MQTTTopicConfig, msg mqtt.Message, now time.Time) error {
+	values, err := decode(t, msg.Payload)
+	if err != nil || len(values) == 0 {
+		return err
+	}
+	poolID, err := ResolvePool(s.db, t.Pool)
+	if err != nil {
+		return err
+	}
+
+	source := msg.Topic
+	if len(source) > maxSourceLength {
+		source = source[:maxSourceLength]
+	}
+	readings := make([]models.SensorReading, 0, len(values))
+	for _, parameter := range topicParameters(t) {
+		if value, ok := values[parameter]; ok {
+			readings = append(readings, models.SensorReading{PoolID: poolID, Parameter: parameter, Value: value, Source: source, TakenAt: now})
+		}
+	}
+	invalid, err := RecordReadings(s.db, readings, now)
+	if err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		var errs []error
+		for _, e := range invalid {
+			errs = append(errs, errors.New(e.Message))
+		}
+		return errors.Join(errs...)
+	}
+	for _, r := range readings {
+		if slices.Contains(indexInputs, r.Parameter) {
+			return RecordIndices(s.db, poolID, now)
+		}
+	}
+	return nil
+}
+
+// Run connects to the broker and records readings, reconnecting with
+// increasing delays when the connection fails. It never returns.
+func (s *Subscriber) Run() {
+	var filters []string
+	seen := make(map[string]bool)
+	for _, t := range s.cfg.Topics {
+		if !seen[t.Topic] {
+			filters = append(filters, t.Topic)
+			seen[t.Topic] = true
+		}
+	}
+
+	opts := mqtt.Options{
+		Broker:   s.cfg.Broker,
+		ClientID: s.cfg.ClientID,
+		Username: s.cfg.Username,
+		Password: s.cfg.Password,
+	}
+	mqtt.RunWithReconnect(opts, "MQTT", func(client *mqtt.Client) error {
+		if err := client.Subscribe(filters...); err != nil {
+			return err
+		}
+		log.Printf("MQTT subscribed to %s", strings.Join(filters, ", "))
+		return client.Listen(func(msg mqtt.Message) {
+			if err := s.Handle(msg, time.Now()); err != nil {
+				log.Printf("MQTT reading rejected: %v", err)
+			}
+		})
+	})
+}
+
+// StartMQTT records readings from the configured MQTT topics in the
+// background when MQTT is enabled
+func StartMQTT(db *gorm.DB, cfg config.MQTTConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	s, err := NewSubscriber(db, cfg)
+	if err != nil {
+		return err
+	}
+	go s.Run()
+	return nil
+}
+
+// topicParameters returns the parameters of a topic, sorted
+func topicParameters(t config.MQTTTopicConfig) []string {
+	if t.Parameter != "" {
+		return []string{t.Parameter}
+	}
+	parameters := make([]string, 0, len(t.Fields))
+	for parameter := range t.Fields {
+		parameters = append(parameters, parameter)
+	}
+	sort.Strings(parameters)
+	return parameters
+}
+
+// decode reads the values of a payload in stored units: a plain number for
+// topics with a parameter, or a JSON object for topics with fields
+func decode(t config.MQTTTopicConfig, payload []byte) (map[string]float64, error) {
+	values := make(map[string]float64)
+	if t.Parameter != "" {
+		value, err := parseValue(strings.Trim(strings.TrimSpace(string(payload)), `"`))
+		if err != nil {
+			return nil, err
+		}
+		values[t.Parameter] = value
+	} else {
+		var object map[string]interface{}
+		if err := json.Unmarshal(payload, &object); err != nil {
+			return nil, fmt.Errorf("payload is not a JSON object: %w", err)
+		}
+		for parameter, path := range t.Fields {
+			field, ok := lookup(object, path)
+			if !ok {
+				continue
+			}
+			var value float64
+			switch v := field.(type) {
+			case float64:
+				value = v
+			case string:
+				parsed, err := parseValue(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", path, err)
+				}
+				value = parsed
+			default:
+				return nil, fmt.Errorf("%s is not a number", path)
+			}
+			values[parameter] = value
+		}
+	}
+
+	if v, ok := values["temperature"]; ok && t.Celsius {
+		values["temperature"] = chemistry.CelsiusToFahrenheit(v)
+	}
+	return values, nil
+}
+
+func parseValue(s string) (float64, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	return value, nil
+}
+
+// lookup finds a field of a JSON object by a dotted path, e.g. "probe.ph"
+func lookup(object map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = object
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, value != nil
+}
@@ -0,0 +1,363 @@
+package sensors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// DefaultCalibrationDays is how far back manual tests are used for an ORP
+// calibration by default
+const DefaultCalibrationDays = 90
+
+// orpMatchWindow is how far from a manual test an ORP reading may be taken
+// to be paired with it
+const orpMatchWindow = 30 * time.Minute
+
+// staleORP is the age after which an estimate warns about its ORP reading
+const staleORP = 2 * time.Hour
+
+// minRSquared is the fit below which an estimate warns about its calibration
+const minRSquared = 0.7
+
+var (
+	// ErrNotCalibrated is returned for pools without an ORP calibration
+	ErrNotCalibrated = errors.New("pool has no ORP calibration")
+	// ErrNoORP is returned when a pool has no ORP reading to estimate from
+	ErrNoORP = errors.New("pool has no ORP reading")
+	// ErrNoPH is returned when a pool has no pH to estimate at
+	ErrNoPH = errors.New("pool has no pH test or reading")
+)
+
+// CalibrationPoint is a manual test used for an ORP calibration
+type CalibrationPoint struct {
+	chemistry.ORPPoint
+	SampleID uint      `json:"sample_id"`
+	TestedAt time.Time `json:"tested_at"`
+	// ORPSource is "sample" when the ORP was recorded with the test and
+	// "reading" when it is the nearest sensor reading
+	ORPSource string `json:"orp_source"`
+}
+
+// cyaMatches reports whether tests at a CYA level can calibrate for another
+func cyaMatches(cya, level float64) bool {
+	return math.Abs(cya-level) <= math.Max(10, 0.15*level)
+}
+
+// ORPPoints pairs the manual FC tests of a pool since a time with the ORP
+// recorded with them or the nearest ORP reading. Only tests at the pool's
+// current CYA level are used, and that level is returned.
+func ORPPoints(db *gorm.DB, poolID uint, since, now time.Time) ([]CalibrationPoint, float64, error) {
+	var cya float64
+	if latest, ok, err := LatestTested(db, poolID, "cya", now); err != nil {
+		return nil, 0, err
+	} else if ok {
+		cya = latest.Value
+	}
+
+	// CYA is tested rarely, so tests use the latest CYA before them
+	var testCYA float64
+	if before, ok, err := LatestTested(db, poolID, "cya", since); err != nil {
+		return nil, 0, err
+	} else if ok {
+		testCYA = before.Value
+	}
+
+	var samples []models.Sample
+	err := db.Preload("Measurements").Where("pool_id = ? AND sample_date_time > ? AND sample_date_time <= ?", poolID, since, now).
+		Order("sample_date_time ASC").Find(&samples).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch samples: %w", err)
+	}
+
+	points := []CalibrationPoint{}
+	for _, s := range samples {
+		if value, ok := s.ParameterValue("cya"); ok {
+			testCYA = value
+		}
+		fc, hasFC := s.ParameterValue("fc")
+		ph, hasPH := s.ParameterValue("ph")
+		if !hasFC || !hasPH || !cyaMatches(testCYA, cya) {
+			continue
+		}
+		temperature, ok := s.ParameterValue("temperature")
+		if !ok {
+			temperature = chemistry.DefaultTemperature
+		}
+
+		point := CalibrationPoint{
+			ORPPoint:  chemistry.ORPPoint{FC: fc, PH: ph, Temperature: temperature, CYA: testCYA},
+			SampleID:  s.ID,
+			TestedAt:  s.SampleDateTime.UTC(),
+			ORPSource: "sample",
+		}
+		if orp, ok := s.ParameterValue("orp"); ok {
+			point.ORP = orp
+		} else {
+			reading, ok, err := nearestReading(db, poolID, "orp", s.SampleDateTime)
+			if err != nil {
+				return nil, 0, err
+			}
+			if !ok {
+				continue
+			}
+			point.ORP = reading.Value
+			point.ORPSource = "reading"
+		}
+		points = append(points, point)
+	}
+	return points, cya, nil
+}
+
+// nearestReading returns the reading of a parameter closest to a time
+// within orpMatchWindow
+func nearestReading(db *gorm.DB, poolID uint, parameter string, at time.Time) (models.SensorReading, bool, error) {
+	var readings []models.SensorReading
+	err := db.Where("pool_id = ? AND parameter = ? AND taken_at >= ? AND taken_at <= ?",
+		poolID, parameter, at.Add(-orpMatchWindow), at.Add(orpMatchWindow)).Find(&readings).Error
+	if err != nil {
+		return models.SensorReading{}, false, fmt.Errorf("failed to fetch readings: %w", err)
+	}
+	if len(readings) == 0 {
+		return models.SensorReading{}, false, nil
+	}
+	sort.Slice(readings, func(i, j int) bool {
+		return readings[i].TakenAt.Sub(at).Abs() < readings[j].TakenAt.Sub(at).Abs()
+	})
+	return readings[0], true, nil
+}
+
+// Calibrate fits and stores the ORP calibration of a pool from its manual
+// tests since a time. The tests used are returned even when they cannot
+// be fitted, in which case the error wraps chemistry.ErrORPFit.
+func Calibrate(db *gorm.DB, poolID uint, since, now time.Time, userID uint) (*models.ORPCalibration, []CalibrationPoint, error) {
+	points, cya, err := ORPPoints(db, poolID, since, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	orpPoints := make([]chemistry.ORPPoint, len(points))
+	for i, p := range points {
+		orpPoints[i] = p.ORPPoint
+	}
+	fit, err := chemistry.FitORP(orpPoints)
+	if err != nil {
+		return nil, points, err
+	}
+
+	var calibration models.ORPCalibration
+	ctx := context.WithValue(context.Background(), "user_id", userID)
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("pool_id = ?", poolID).Limit(1).Find(&calibration).Error; err != nil {
+			return err
+		}
+		calibration.PoolID = poolID
+		calibration.Intercept = fit.Intercept
+		calibration.Slope = fit.Slope
+		calibration.RSquared = fit.RSquared
+		calibration.Points = fit.Points
+		calibration.CYA = cya
+		calibration.FirstTest = points[0].TestedAt
+		calibration.LastTest = points[len(points)-1].TestedAt
+		return tx.Save(&calibration).Error
+	})
+	if err != nil {
+		return nil, points, fmt.Errorf("failed to save ORP calibration: %w", err)
+	}
+	return &calibration, points, nil
+}
+
+// GetCalibration returns the ORP calibration of a pool
+func GetCalibration(db *gorm.DB, poolID uint) (*models.ORPCalibration, error) {
+	var calibrations []models.ORPCalibration
+	if err := db.Where("pool_id = ?", poolID).Limit(1).Find(&calibrations).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch ORP calibration: %w", err)
+	}
+	if len(calibrations) == 0 {
+		return nil, ErrNotCalibrated
+	}
+	return &calibrations[0], nil
+}
+
+func calibrationFit(c *models.ORPCalibration) chemistry.ORPFit {
+	return chemistry.ORPFit{Intercept: c.Intercept, Slope: c.Slope, RSquared: c.RSquared, Points: c.Points}
+}
+
+// TestRef identifies the manual test an estimate follows
+type TestRef struct {
+	FC       float64   `json:"fc"`
+	TestedAt time.Time `json:"tested_at"`
+}
+
+// FCEstimate is the free chlorine of a pool estimated from ORP
+type FCEstimate struct {
+	FC          float64                `json:"fc"`
+	ORP         float64                `json:"orp"`
+	ORPTakenAt  *time.Time             `json:"orp_taken_at,omitempty"`
+	PH          float64                `json:"ph"`
+	Temperature float64                `json:"temperature"`
+	CYA         float64                `json:"cya"`
+	LastTest    *TestRef               `json:"last_test,omitempty"`
+	Calibration *models.ORPCalibration `json:"calibration"`
+	Warnings    []string               `json:"warnings"`
+}
+
+// EstimateFC estimates the current free chlorine of a pool from its latest
+// ORP reading, or a given ORP, at its latest pH and temperature unless
+// given
+func EstimateFC(db *gorm.DB, poolID uint, orp, ph *float64, now time.Time) (*FCEstimate, error) {
+	calibration, err := GetCalibration(db, poolID)
+	if err != nil {
+		return nil, err
+	}
+	estimate := &FCEstimate{Calibration: calibration, Warnings: []string{}}
+
+	if orp != nil {
+		estimate.ORP = *orp
+	} else {
+		latest, ok, err := LatestValue(db, poolID, "orp", now)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrNoORP
+		}
+		estimate.ORP = latest.Value
+		takenAt := latest.At.UTC()
+		estimate.ORPTakenAt = &takenAt
+		if now.Sub(takenAt) > staleORP {
+			estimate.Warnings = append(estimate.Warnings,
+				fmt.Sprintf("The latest ORP reading is from %s", takenAt.Format("2006-01-02 15:04 UTC")))
+		}
+	}
+
+	if ph != nil {
+		estimate.PH = *ph
+	} else if latest, ok, err := LatestValue(db, poolID, "ph", now); err != nil {
+		return nil, err
+	} else if ok {
+		estimate.PH = latest.Value
+	} else {
+		return nil, ErrNoPH
+	}
+
+	estimate.Temperature = chemistry.DefaultTemperature
+	if latest, ok, err := LatestValue(db, poolID, "temperature", now); err != nil {
+		return nil, err
+	} else if ok {
+		estimate.Temperature = latest.Value
+	}
+
+	if latest, ok, err := LatestTested(db, poolID, "cya", now); err != nil {
+		return nil, err
+	} else if ok {
+		estimate.CYA = latest.Value
+	}
+	if !cyaMatches(estimate.CYA, calibration.CYA) {
+		estimate.Warnings = append(estimate.Warnings, fmt.Sprintf(
+			"CYA is now %g ppm but the calibration was fitted at %g ppm; recalibrate after the next tests", estimate.CYA, calibration.CYA))
+	}
+	if calibration.RSquared < minRSquared {
+		estimate.Warnings = append(estimate.Warnings, fmt.Sprintf(
+			"The calibration fits its tests poorly (R² %.2f)", calibration.RSquared))
+	}
+
+	if latest, ok, err := LatestTested(db, poolID, "fc", now); err != nil {
+		return nil, err
+	} else if ok {
+		estimate.LastTest = &TestRef{FC: latest.Value, TestedAt: latest.At.UTC()}
+	}
+
+	estimate.FC = math.Round(calibrationFit(calibration).EstimateFC(estimate.ORP, estimate.PH, estimate.Temperature)*100) / 100
+	return estimate, nil
+}
+
+// EstimateFCSeries estimates free chlorine from the ORP readings of a pool,
+// averaged into buckets as by Series, at the pH and temperature tested or
+// read at the time of each bucket. It returns nothing for pools without a
+// calibration.
+func EstimateFCSeries(db *gorm.DB, f ReadingFilter, maxPoints int) ([]Point, error) {
+	if f.PoolID == 0 {
+		return nil, nil
+	}
+	calibration, err := GetCalibration(db, f.PoolID)
+	if errors.Is(err, ErrNotCalibrated) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f.Parameters = []string{"orp"}
+	series, err := Series(db, f, maxPoints)
+	if err != nil || len(series["orp"]) == 0 {
+		return nil, err
+	}
+
+	ph, err := history(db, f, "ph", maxPoints)
+	if err != nil {
+		return nil, err
+	}
+	if len(ph) == 0 {
+		return nil, nil
+	}
+	temperature, err := history(db, f, "temperature", maxPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	fit := calibrationFit(calibration)
+	estimates := make([]Point, 0, len(series["orp"]))
+	for _, point := range series["orp"] {
+		t := chemistry.DefaultTemperature
+		if len(temperature) > 0 {
+			t = valueAt(temperature, point.Time)
+		}
+		fc := fit.EstimateFC(point.Value, valueAt(ph, point.Time), t)
+		estimates = append(estimates, Point{Time: point.Time, Value: math.Round(fc*100) / 100})
+	}
+	return estimates, nil
+}
+
+// history returns the tested and read values of a parameter of a pool up
+// to the end of a filter, oldest first
+func history(db *gorm.DB, f ReadingFilter, parameter string, maxPoints int) ([]TimedValue, error) {
+	column := "measurements." + parameter
+	query := db.Table("measurements").
+		Select(column+" AS value", "samples.sample_date_time AS at").
+		Joins("JOIN samples ON samples.id = measurements.sample_id").
+		Where("samples.pool_id = ? AND samples.deleted_at IS NULL AND measurements.deleted_at IS NULL AND "+column+" <> 0", f.PoolID)
+	if !f.To.IsZero() {
+		query = query.Where("samples.sample_date_time < ?", f.To)
+	}
+	var values []TimedValue
+	if err := query.Order("samples.sample_date_time ASC").Scan(&values).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch %s tests: %w", parameter, err)
+	}
+
+	f.Parameters = []string{parameter}
+	series, err := Series(db, f, maxPoints)
+	if err != nil {
+		return nil, err
+	}
+	for _, point := range series[parameter] {
+		values = append(values, TimedValue{Value: point.Value, At: point.Time})
+	}
+	sort.SliceStable(values, func(i, j int) bool { return values[i].At.Before(values[j].At) })
+	return values, nil
+}
+
+// valueAt returns the latest value at or before a time, or the first value
+// for times before all of them
+func valueAt(values []TimedValue, at time.Time) float64 {
+	i := sort.Search(len(values), func(i int) bool { return values[i].At.After(at) })
+	if i == 0 {
+		return values[0].Value
+	}
+	return values[i-1].Value
+}
@@ -0,0 +1,391 @@
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/config"
+	"waterlogger/internal/models"
+)
+
+// MaxBatch is the largest number of readings accepted in one batch
+const MaxBatch = 5000
+
+// maxClockSkew is how far in the future a reading may be timestamped
+const maxClockSkew = 5 * time.Minute
+
+// defaultDownsample is the downsampling interval when none is configured
+const defaultDownsample = time.Hour
+
+// readingRetentionInterval is how often StartReadingRetention runs
+const readingRetentionInterval = time.Hour
+
+// insertBatchSize is the number of readings inserted per statement
+const insertBatchSize = 500
+
+// ReadingError describes why a reading of a batch was rejected
+type ReadingError struct {
+	Index   int    `json:"index"`
+	Message string `json:"error"`
+}
+
+func (e ReadingError) Error() string {
+	return fmt.Sprintf("reading %d: %s", e.Index, e.Message)
+}
+
+// CheckReading returns an error when a reading is invalid at a time
+func CheckReading(r models.SensorReading, now time.Time) error {
+	if r.PoolID == 0 {
+		return errors.New("pool is required")
+	}
+	if err := CheckValue(r.Parameter, r.Value); err != nil {
+		return err
+	}
+	if len(r.Source) > 100 {
+		return errors.New("source is longer than 100 characters")
+	}
+	if r.TakenAt.After(now.Add(maxClockSkew)) {
+		return errors.New("taken_at is in the future")
+	}
+	return nil
+}
+
+// PrepareReadings validates a batch of readings and returns the errors of
+// every invalid reading. Readings without a time are taken now.
+func PrepareReadings(db *gorm.DB, readings []models.SensorReading, now time.Time) ([]ReadingError, error) {
+	var invalid []ReadingError
+	pools := make(map[uint]bool)
+	for i := range readings {
+		r := &readings[i]
+		r.ID = 0
+		r.Count = 1
+		r.Downsampled = false
+		if r.TakenAt.IsZero() {
+			r.TakenAt = now
+		}
+		r.TakenAt = r.TakenAt.UTC()
+		if err := CheckReading(*r, now); err != nil {
+			invalid = append(invalid, ReadingError{Index: i, Message: err.Error()})
+			continue
+		}
+		pools[r.PoolID] = false
+	}
+	if len(pools) == 0 {
+		return invalid, nil
+	}
+
+	ids := make([]uint, 0, len(pools))
+	for id := range pools {
+		ids = append(ids, id)
+	}
+	var existing []uint
+	if err := db.Model(&models.Pool{}).Where("id IN ?", ids).Pluck("id", &existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch pools: %w", err)
+	}
+	for _, id := range existing {
+		pools[id] = true
+	}
+	for i, r := range readings {
+		if found, checked := pools[r.PoolID]; checked && !found {
+			invalid = append(invalid, ReadingError{Index: i, Message: fmt.Sprintf("pool %d not found", r.PoolID)})
+		}
+	}
+	sort.SliceStable(invalid, func(i, j int) bool { return invalid[i].Index < invalid[j].Index })
+	return invalid, nil
+}
+
+// RecordReadings checks a batch of readings and stores it when every
+// reading is valid. Otherwise it stores none and returns the errors of the
+// invalid readings.
+func RecordReadings(db *gorm.DB, readings []models.SensorReading, now time.Time) ([]ReadingError, error) {
+	invalid, err := PrepareReadings(db, readings, now)
+	if err != nil || len(invalid) > 0 {
+		return invalid, err
+	}
+	return nil, StoreReadings(db, readings)
+}
+
+// StoreReadings stores readings checked by PrepareReadings
+func StoreReadings(db *gorm.DB, readings []models.SensorReading) error {
+	if len(readings) == 0 {
+		return nil
+	}
+	if err := db.CreateInBatches(&readings, insertBatchSize).Error; err != nil {
+		return fmt.Errorf("failed to store readings: %w", err)
+	}
+	return nil
+}
+
+// ReadingFilter selects readings. Zero fields select everything.
+type ReadingFilter struct {
+	PoolID     uint
+	Parameters []string
+	From       time.Time // Inclusive
+	To         time.Time // Exclusive
+}
+
+// apply adds the filter to a query of readings. Readings of pools in the
+// trash are left out.
+func (f ReadingFilter) apply(query *gorm.DB) *gorm.DB {
+	query = query.Where("pool_id IN (?)", query.Session(&gorm.Session{NewDB: true}).
+		Model(&models.Pool{}).Select("id"))
+	if f.PoolID != 0 {
+		query = query.Where("pool_id = ?", f.PoolID)
+	}
+	if len(f.Parameters) > 0 {
+		query = query.Where("parameter IN ?", f.Parameters)
+	}
+	if !f.From.IsZero() {
+		query = query.Where("taken_at >= ?", f.From)
+	}
+	if !f.To.IsZero() {
+		query = query.Where("taken_at < ?", f.To)
+	}
+	return query
+}
+
+// ListReadings returns up to limit readings, newest first
+func ListReadings(db *gorm.DB, f ReadingFilter, limit int) ([]models.SensorReading, error) {
+	readings := []models.SensorReading{}
+	err := f.apply(db.Model(&models.SensorReading{})).
+		Order("taken_at DESC").Order("id DESC").Limit(limit).Find(&readings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch readings: %w", err)
+	}
+	return readings, nil
+}
+
+// Point is the average of the readings of a parameter in a time bucket
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series returns the readings of each parameter averaged into buckets,
+// oldest first. Buckets are whole minutes, wide enough for the selected
+// time span to need at most maxPoints of them.
+func Series(db *gorm.DB, f ReadingFilter, maxPoints int) (map[string][]Point, error) {
+	series := make(map[string][]Point)
+
+	var first, last []time.Time
+	if err := f.apply(db.Model(&models.SensorReading{})).Order("taken_at ASC").Limit(1).Pluck("taken_at", &first).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch readings: %w", err)
+	}
+	if len(first) == 0 {
+		return series, nil
+	}
+	if err := f.apply(db.Model(&models.SensorReading{})).Order("taken_at DESC").Limit(1).Pluck("taken_at", &last).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch readings: %w", err)
+	}
+	bucket := (last[0].Sub(first[0]) / time.Duration(maxPoints)).Truncate(time.Minute) + time.Minute
+
+	rows, err := f.apply(db.Model(&models.SensorReading{})).
+		Select("parameter", "taken_at", "value", "count").
+		Order("taken_at ASC").Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch readings: %w", err)
+	}
+	defer rows.Close()
+
+	type sum struct {
+		start        time.Time
+		total, count float64
+	}
+	sums := make(map[string]*sum)
+	flush := func(parameter string, s *sum) {
+		series[parameter] = append(series[parameter], Point{Time: s.start, Value: round(s.total / s.count)})
+	}
+	for rows.Next() {
+		var (
+			parameter string
+			timestamp time.Time
+			value     float64
+			count     int
+		)
+		if err := rows.Scan(&parameter, &timestamp, &value, &count); err != nil {
+			return nil, fmt.Errorf("failed to read readings: %w", err)
+		}
+		count = max(count, 1)
+
+		start := timestamp.UTC().Truncate(bucket)
+		s := sums[parameter]
+		if s != nil && !s.start.Equal(start) {
+			flush(parameter, s)
+			s = nil
+		}
+		if s == nil {
+			s = &sum{start: start}
+			sums[parameter] = s
+		}
+		s.total += value * float64(count)
+		s.count += float64(count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read readings: %w", err)
+	}
+	for parameter, s := range sums {
+		flush(parameter, s)
+	}
+	return series, nil
+}
+
+// seriesKey identifies the readings of one parameter of a pool from one source
+type seriesKey struct {
+	PoolID    uint
+	Parameter string
+	Source    string
+}
+
+// Downsample replaces the readings taken before a cutoff with one averaged
+// reading per series and interval, and returns the number of readings
+// replaced. Readings already downsampled are merged with late readings of
+// their interval.
+func Downsample(db *gorm.DB, before time.Time, interval time.Duration) (int, error) {
+	before = before.UTC().Truncate(interval)
+
+	var keys []seriesKey
+	err := db.Model(&models.SensorReading{}).Distinct("pool_id", "parameter", "source").
+		Where("downsampled = ? AND taken_at < ?", false, before).Find(&keys).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find readings to downsample: %w", err)
+	}
+
+	replaced := 0
+	for _, key := range keys {
+		// A page of intervals at a time keeps memory bounded
+		for {
+			var oldest []time.Time
+			err := seriesQuery(db, key).Where("downsampled = ? AND taken_at < ?", false, before).
+				Order("taken_at ASC").Limit(1).Pluck("taken_at", &oldest).Error
+			if err != nil {
+				return replaced, fmt.Errorf("failed to find readings to downsample: %w", err)
+			}
+			if len(oldest) == 0 {
+				break
+			}
+			from := oldest[0].UTC().Truncate(interval)
+			to := from.Add(interval * insertBatchSize)
+			if to.After(before) {
+				to = before
+			}
+
+			n, err := downsampleRange(db, key, from, to, interval)
+			if err != nil {
+				return replaced, err
+			}
+			replaced += n
+		}
+	}
+	return replaced, nil
+}
+
+func seriesQuery(db *gorm.DB, key seriesKey) *gorm.DB {
+	return db.Model(&models.SensorReading{}).
+		Where("pool_id = ? AND parameter = ? AND source = ?", key.PoolID, key.Parameter, key.Source)
+}
+
+// downsampleRange replaces the readings of a series between two interval
+// boundaries with one reading per interval
+func downsampleRange(db *gorm.DB, key seriesKey, from, to time.Time, interval time.Duration) (int, error) {
+	replaced := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var readings []models.SensorReading
+		err := seriesQuery(tx, key).Where("taken_at >= ? AND taken_at < ?", from, to).
+			Order("taken_at ASC").Find(&readings).Error
+		if err != nil {
+			return fmt.Errorf("failed to fetch readings to downsample: %w", err)
+		}
+
+		var averages []models.SensorReading
+		ids := make([]uint, 0, len(readings))
+		for _, r := range readings {
+			start := r.TakenAt.UTC().Truncate(interval)
+			if n := len(averages); n == 0 || !averages[n-1].TakenAt.Equal(start) {
+				averages = append(averages, models.SensorReading{
+					PoolID: key.PoolID, Parameter: key.Parameter, Source: key.Source,
+					TakenAt: start, Downsampled: true,
+				})
+			}
+			// The sum is divided by the count once the interval is complete
+			a := &averages[len(averages)-1]
+			count := max(r.Count, 1)
+			a.Value += r.Value * float64(count)
+			a.Count += count
+			ids = append(ids, r.ID)
+			if !r.Downsampled {
+				replaced++
+			}
+		}
+		for i := range averages {
+			averages[i].Value = round(averages[i].Value / float64(averages[i].Count))
+		}
+
+		for start := 0; start < len(ids); start += insertBatchSize {
+			end := min(start+insertBatchSize, len(ids))
+			if err := tx.Where("id IN ?", ids[start:end]).Delete(&models.SensorReading{}).Error; err != nil {
+				return fmt.Errorf("failed to delete downsampled readings: %w", err)
+			}
+		}
+		if len(averages) > 0 {
+			if err := tx.CreateInBatches(&averages, insertBatchSize).Error; err != nil {
+				return fmt.Errorf("failed to store downsampled readings: %w", err)
+			}
+		}
+		return nil
+	})
+	return replaced, err
+}
+
+// round drops the floating point noise of averaging
+func round(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// ApplyReadingRetention downsamples readings older than cfg.RawDays and
+// deletes readings older than cfg.RetentionDays, leaving out steps whose
+// setting is zero
+func ApplyReadingRetention(db *gorm.DB, cfg config.ReadingsConfig, now time.Time) (downsampled, deleted int, err error) {
+	if cfg.RawDays > 0 {
+		interval := defaultDownsample
+		if cfg.DownsampleMinutes > 0 {
+			interval = time.Duration(cfg.DownsampleMinutes) * time.Minute
+		}
+		downsampled, err = Downsample(db, now.AddDate(0, 0, -cfg.RawDays), interval)
+		if err != nil {
+			return downsampled, 0, err
+		}
+	}
+	if cfg.RetentionDays > 0 {
+		result := db.Where("taken_at < ?", now.UTC().AddDate(0, 0, -cfg.RetentionDays)).Delete(&models.SensorReading{})
+		if result.Error != nil {
+			return downsampled, 0, fmt.Errorf("failed to delete old readings: %w", result.Error)
+		}
+		deleted = int(result.RowsAffected)
+	}
+	return downsampled, deleted, nil
+}
+
+// StartReadingRetention periodically downsamples and deletes old readings.
+// It does nothing when neither is configured.
+func StartReadingRetention(db *gorm.DB, cfg config.ReadingsConfig) {
+	if cfg.RawDays <= 0 && cfg.RetentionDays <= 0 {
+		return
+	}
+
+	go func() {
+		for {
+			downsampled, deleted, err := ApplyReadingRetention(db, cfg, time.Now())
+			if err != nil {
+				log.Printf("Reading retention failed: %v", err)
+			} else if downsampled > 0 || deleted > 0 {
+				log.Printf("Reading retention downsampled %d readings and deleted %d", downsampled, deleted)
+			}
+			time.Sleep(readingRetentionInterval)
+		}
+	}()
+}
@@ -0,0 +1,50 @@
+// Package sensors records readings from pool probes and sensors.
+//
+// Readings are stored as a time series of their own, apart from the
+// samples of manual tests, whether they are posted in batches or published
+// over MQTT. Old readings are downsampled and deleted by retention.
+package sensors
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// ErrUnknownPool is returned for readings of a pool that does not exist
+var ErrUnknownPool = errors.New("unknown pool")
+
+// CheckValue returns an error when a reading of a parameter is not of a
+// measured parameter or is outside the parameter's valid range
+func CheckValue(parameter string, value float64) error {
+	min, max, ok := chemistry.ValidRange(parameter)
+	if !ok {
+		return fmt.Errorf("%s is not a measured parameter", parameter)
+	}
+	if math.IsNaN(value) || value < min || value > max {
+		return fmt.Errorf("%s %s is outside the valid range %s - %s", parameter,
+			strconv.FormatFloat(value, 'f', -1, 64), strconv.FormatFloat(min, 'f', -1, 64), strconv.FormatFloat(max, 'f', -1, 64))
+	}
+	return nil
+}
+
+// ResolvePool returns the ID of a pool given by name or ID
+func ResolvePool(db *gorm.DB, pool string) (uint, error) {
+	var pools []models.Pool
+	query := db.Where("name = ?", pool)
+	if id, err := strconv.ParseUint(pool, 10, 32); err == nil {
+		query = query.Or("id = ?", id)
+	}
+	if err := query.Order("id ASC").Limit(1).Find(&pools).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch pool: %w", err)
+	}
+	if len(pools) == 0 {
+		return 0, fmt.Errorf("%w %q", ErrUnknownPool, pool)
+	}
+	return pools[0].ID, nil
+}
@@ -0,0 +1,229 @@
+package webhooks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/config"
+	"waterlogger/internal/models"
+)
+
+const (
+	// pollInterval is how often the queue is checked for due deliveries
+	// when nothing new has been queued
+	pollInterval = 15 * time.Second
+	// batchSize is the most deliveries sent per check
+	batchSize = 50
+	// firstRetryDelay doubles after each failed attempt, up to maxRetryDelay
+	firstRetryDelay = 30 * time.Second
+	maxRetryDelay   = 6 * time.Hour
+	// maxErrorLength is how much of an error or response body is logged
+	maxErrorLength = 1000
+	// logCleanupInterval is how often old finished deliveries are deleted
+	logCleanupInterval = time.Hour
+)
+
+// wakeup is signalled when deliveries are queued, so they go out without
+// waiting for the next poll
+var wakeup = make(chan struct{}, 1)
+
+func wake() {
+	select {
+	case wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// Dispatcher sends queued deliveries
+type Dispatcher struct {
+	db          *gorm.DB
+	client      *http.Client
+	maxAttempts int
+	logDays     int
+}
+
+// NewDispatcher returns a dispatcher for the configured attempts, timeout
+// and log retention
+func NewDispatcher(db *gorm.DB, cfg config.WebhooksConfig) *Dispatcher {
+	d := &Dispatcher{
+		db:          db,
+		client:      &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second},
+		maxAttempts: cfg.MaxAttempts,
+		logDays:     cfg.LogDays,
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = 8
+	}
+	if cfg.TimeoutSeconds <= 0 {
+		d.client.Timeout = 10 * time.Second
+	}
+	return d
+}
+
+// RetryDelay returns the delay before the attempt after a number of failed
+// ones
+func RetryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// DeliverDue sends the pending deliveries that are due and returns how many
+// were attempted
+func (d *Dispatcher) DeliverDue(now time.Time) (int, error) {
+	var deliveries []models.WebhookDelivery
+	err := d.db.Where("status = ? AND next_attempt_at <= ?", StatusPending, now).
+		Order("next_attempt_at ASC").Order("id ASC").Limit(batchSize).Find(&deliveries).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch webhook deliveries: %w", err)
+	}
+	if len(deliveries) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]uint, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.WebhookID)
+	}
+	var hooks []models.Webhook
+	if err := d.db.Where("id IN ?", ids).Find(&hooks).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch webhooks: %w", err)
+	}
+	byID := make(map[uint]models.Webhook, len(hooks))
+	for _, hook := range hooks {
+		byID[hook.ID] = hook
+	}
+
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		hook, ok := byID[delivery.WebhookID]
+		if !ok {
+			// The webhook was deleted while the delivery was queued
+			delivery.Status = StatusFailed
+			delivery.Error = "webhook no longer exists"
+		} else {
+			d.attempt(hook, delivery, now)
+		}
+		if err := d.db.Save(delivery).Error; err != nil {
+			return i, fmt.Errorf("failed to update webhook delivery: %w", err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// attempt sends a delivery once and records the outcome
+func (d *Dispatcher) attempt(hook models.Webhook, delivery *models.WebhookDelivery, now time.Time) {
+	delivery.Attempts++
+	delivery.ResponseStatus = 0
+	delivery.Error = ""
+
+	err := d.send(hook, delivery)
+	if err == nil {
+		delivered := time.Now().UTC()
+		delivery.Status = StatusDelivered
+		delivery.DeliveredAt = &delivered
+		return
+	}
+
+	delivery.Error = truncate(err.Error())
+	if delivery.Attempts >= d.maxAttempts {
+		delivery.Status = StatusFailed
+		return
+	}
+	delivery.NextAttemptAt = now.Add(RetryDelay(delivery.Attempts))
+}
+
+// send posts the payload of a delivery to its webhook
+func (d *Dispatcher) send(hook models.Webhook, delivery *models.WebhookDelivery) error {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Waterlogger-Webhook")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(HeaderSignature, Sign(hook.Secret, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	delivery.ResponseStatus = resp.StatusCode
+	if resp.StatusCode/100 != 2 {
+		text, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(text)))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func truncate(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+	return s[:maxErrorLength]
+}
+
+// DeleteOldDeliveries deletes delivered and failed deliveries older than
+// the log retention and returns how many were deleted
+func (d *Dispatcher) DeleteOldDeliveries(now time.Time) (int64, error) {
+	if d.logDays <= 0 {
+		return 0, nil
+	}
+	result := d.db.Where("status <> ? AND updated_at < ?", StatusPending, now.AddDate(0, 0, -d.logDays)).
+		Delete(&models.WebhookDelivery{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to delete old webhook deliveries: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Run sends deliveries as they are queued and fall due, and deletes old
+// ones from the log. It never returns.
+func (d *Dispatcher) Run() {
+	poll := time.NewTicker(pollInterval)
+	defer poll.Stop()
+	cleanup := time.NewTicker(logCleanupInterval)
+	defer cleanup.Stop()
+	for {
+		select {
+		case <-wakeup:
+		case <-poll.C:
+		case <-cleanup.C:
+			if deleted, err := d.DeleteOldDeliveries(time.Now()); err != nil {
+				log.Printf("Webhook log cleanup failed: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d old webhook deliveries", deleted)
+			}
+			continue
+		}
+
+		// Keep sending while full batches are due
+		for {
+			sent, err := d.DeliverDue(time.Now())
+			if err != nil {
+				log.Printf("Webhook delivery failed: %v", err)
+			}
+			if err != nil || sent < batchSize {
+				break
+			}
+		}
+	}
+}
+
+// StartDispatcher sends webhook deliveries in the background
+func StartDispatcher(db *gorm.DB, cfg config.WebhooksConfig) {
+	go NewDispatcher(db, cfg).Run()
+}
@@ -0,0 +1,98 @@
+package webhooks
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/chemistry"
+	"waterlogger/internal/models"
+)
+
+// SampleEvent is the data of sample.created and sample.updated events
+type SampleEvent struct {
+	Sample *models.Sample    `json:"sample"`
+	Alerts []chemistry.Alert `json:"alerts"`
+}
+
+// AlertEvent is the data of sample.alert events. Alerts are all the
+// parameters of the sample outside their ideal range.
+type AlertEvent struct {
+	SampleID       uint              `json:"sample_id"`
+	PoolID         uint              `json:"pool_id"`
+	Pool           string            `json:"pool"`
+	SampleDateTime time.Time         `json:"sample_datetime"`
+	Alerts         []chemistry.Alert `json:"alerts"`
+}
+
+// AdditionEvent is the data of addition.created events
+type AdditionEvent struct {
+	SampleID       uint              `json:"sample_id"`
+	PoolID         uint              `json:"pool_id"`
+	Pool           string            `json:"pool"`
+	SampleDateTime time.Time         `json:"sample_datetime"`
+	Additions      []models.Addition `json:"additions"`
+}
+
+// SampleSaved queues the events of a created or updated sample, loaded
+// with its pool, measurements, indices and additions. An alert is sent when
+// a parameter goes out of range or changes between low and high, compared
+// with the alerts of the sample before the update. Additions are those
+// logged by the request.
+func SampleSaved(db *gorm.DB, sample *models.Sample, created bool, before []chemistry.Alert, additions []models.Addition) error {
+	alerts := chemistry.CheckSample(sample)
+	if alerts == nil {
+		alerts = []chemistry.Alert{}
+	}
+	pool := ""
+	if sample.Pool != nil {
+		pool = sample.Pool.Name
+	}
+
+	event := EventSampleUpdated
+	if created {
+		event = EventSampleCreated
+	}
+	errs := []error{Enqueue(db, event, SampleEvent{Sample: sample, Alerts: alerts})}
+
+	if len(chemistry.NewAlerts(alerts, before)) > 0 {
+		errs = append(errs, Enqueue(db, EventSampleAlert, AlertEvent{
+			SampleID:       sample.ID,
+			PoolID:         sample.PoolID,
+			Pool:           pool,
+			SampleDateTime: sample.SampleDateTime.UTC(),
+			Alerts:         alerts,
+		}))
+	}
+	if len(additions) > 0 {
+		errs = append(errs, Enqueue(db, EventAdditionCreated, AdditionEvent{
+			SampleID:       sample.ID,
+			PoolID:         sample.PoolID,
+			Pool:           pool,
+			SampleDateTime: sample.SampleDateTime.UTC(),
+			Additions:      additions,
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// NewAdditions returns the additions that do not match an earlier one by
+// chemical, amount and unit, for updates that replace a sample's additions
+func NewAdditions(additions, before []models.Addition) []models.Addition {
+	used := make([]bool, len(before))
+	var added []models.Addition
+	for _, a := range additions {
+		found := false
+		for i, b := range before {
+			if !used[i] && a.Chemical == b.Chemical && a.Amount == b.Amount && a.Unit == b.Unit {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			added = append(added, a)
+		}
+	}
+	return added
+}
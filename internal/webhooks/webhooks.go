@@ -0,0 +1,196 @@
+// Package webhooks sends events to registered URLs as JSON signed with
+// HMAC-SHA256. Events are queued in the database and retried with
+// increasing delays, and each delivery is kept as a log entry.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"waterlogger/internal/models"
+)
+
+// Event types
+const (
+	EventSampleCreated   = "sample.created"
+	EventSampleUpdated   = "sample.updated"
+	EventSampleAlert     = "sample.alert"
+	EventAdditionCreated = "addition.created"
+	// EventPing is only sent by the test endpoint
+	EventPing = "ping"
+)
+
+// Events are the event types webhooks can subscribe to
+var Events = []string{EventSampleCreated, EventSampleUpdated, EventSampleAlert, EventAdditionCreated}
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Headers sent with each delivery
+const (
+	HeaderEvent     = "X-Waterlogger-Event"
+	HeaderDelivery  = "X-Waterlogger-Delivery"
+	HeaderSignature = "X-Waterlogger-Signature"
+)
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Sign returns the signature header value of a body: sha256= followed by
+// the hex HMAC-SHA256 of the body keyed with the webhook secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random secret for a webhook
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ParseEvents checks and normalizes the event types of a webhook. * or no
+// events subscribe to all of them.
+func ParseEvents(events []string) (string, error) {
+	var types []string
+	for _, event := range events {
+		event = strings.ToLower(strings.TrimSpace(event))
+		if event == "" {
+			continue
+		}
+		if event == "*" {
+			return "*", nil
+		}
+		if !slices.Contains(Events, event) {
+			return "", fmt.Errorf("unknown event %q; events are %s", event, strings.Join(Events, ", "))
+		}
+		if !slices.Contains(types, event) {
+			types = append(types, event)
+		}
+	}
+	if len(types) == 0 {
+		return "*", nil
+	}
+	return strings.Join(types, ","), nil
+}
+
+// EventList returns the event types stored for a webhook
+func EventList(events string) []string {
+	return strings.Split(events, ",")
+}
+
+// subscribed reports whether a webhook receives an event type
+func subscribed(hook models.Webhook, event string) bool {
+	return hook.Events == "*" || slices.Contains(EventList(hook.Events), event)
+}
+
+// CheckURL checks that a webhook URL is an absolute http or https URL
+func CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid webhook URL: %s", raw)
+	}
+	return nil
+}
+
+// Enqueue queues an event for every active webhook subscribed to it
+func Enqueue(db *gorm.DB, event string, data interface{}) error {
+	var hooks []models.Webhook
+	if err := db.Where("active = ?", true).Find(&hooks).Error; err != nil {
+		return fmt.Errorf("failed to fetch webhooks: %w", err)
+	}
+	var targets []models.Webhook
+	for _, hook := range hooks {
+		if subscribed(hook, event) {
+			targets = append(targets, hook)
+		}
+	}
+	_, err := enqueue(db, targets, event, data)
+	return err
+}
+
+// EnqueueFor queues an event for one webhook, whatever it subscribes to
+func EnqueueFor(db *gorm.DB, hook models.Webhook, event string, data interface{}) (*models.WebhookDelivery, error) {
+	deliveries, err := enqueue(db, []models.Webhook{hook}, event, data)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+// enqueue stores a pending delivery of an event for each webhook, all with
+// the same payload, and wakes the dispatcher
+func enqueue(db *gorm.DB, hooks []models.Webhook, event string, data interface{}) ([]models.WebhookDelivery, error) {
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UTC()
+	payload, err := json.Marshal(Payload{Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	deliveries := make([]models.WebhookDelivery, len(hooks))
+	for i, hook := range hooks {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookID:     hook.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        StatusPending,
+			NextAttemptAt: now,
+		}
+	}
+	if err := db.Create(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	wake()
+	return deliveries, nil
+}
+
+// Retry queues a finished delivery to be sent again at once, with its
+// attempts reset
+func Retry(db *gorm.DB, delivery *models.WebhookDelivery) error {
+	delivery.Status = StatusPending
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UTC()
+	delivery.Error = ""
+	if err := db.Save(delivery).Error; err != nil {
+		return fmt.Errorf("failed to queue webhook delivery: %w", err)
+	}
+	wake()
+	return nil
+}
+
+// DeleteWebhook permanently deletes a webhook and its deliveries
+func DeleteWebhook(db *gorm.DB, id uint) (bool, error) {
+	var deleted bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Delete(&models.Webhook{}, id)
+		deleted = result.RowsAffected > 0
+		return result.Error
+	})
+	return deleted, err
+}
@@ -0,0 +1,25 @@
+#!/usr/bin/env python3
+"""Stub InfluxDB write endpoint that prints the line protocol it receives.
+
+Usage: ./testing/influx_stub.py [port]   (default 8086)
+Set influx.url to http://localhost:8086/api/v2/write?org=home&bucket=pool
+"""
+
+import sys
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+
+class WriteHandler(BaseHTTPRequestHandler):
+    def do_POST(self):
+        length = int(self.headers.get("Content-Length", 0))
+        body = self.rfile.read(length).decode("utf-8")
+        print(f"{self.command} {self.path} ({self.headers.get('Authorization', 'no token')})")
+        print(body, end="", flush=True)
+        self.send_response(204)
+        self.end_headers()
+
+
+if __name__ == "__main__":
+    port = int(sys.argv[1]) if len(sys.argv) > 1 else 8086
+    print(f"Listening on http://localhost:{port}")
+    HTTPServer(("localhost", port), WriteHandler).serve_forever()
@@ -0,0 +1,54 @@
+#!/usr/bin/env python3
+"""Stub ntfy and Gotify server that prints the notifications it receives.
+
+Usage: ./testing/push_stub.py [port]   (default 8090)
+Add an ntfy channel with the URL http://localhost:8090/pool-test and a Gotify
+channel with the URL http://localhost:8090 and any token. Requests to
+/forbidden are refused with a body that must never reach Waterlogger users.
+"""
+
+import json
+import sys
+from http.server import BaseHTTPRequestHandler, HTTPServer
+
+
+class PushHandler(BaseHTTPRequestHandler):
+    def do_POST(self):
+        length = int(self.headers.get("Content-Length", 0))
+        body = self.rfile.read(length).decode("utf-8")
+
+        if self.path.startswith("/forbidden"):
+            print(f"{self.command} {self.path} refused", flush=True)
+            self.reply(403, {"error": "secret response body"})
+            return
+
+        if self.path == "/message":
+            message = json.loads(body)
+            print(f"gotify ({self.headers.get('X-Gotify-Key', 'no token')}) "
+                  f"priority {message.get('priority')}: {message.get('title')}")
+            print(message.get("message"), flush=True)
+            self.reply(200, {"id": 1, "appid": 1})
+            return
+
+        print(f"ntfy {self.path} ({self.headers.get('Authorization', 'no token')}) "
+              f"priority {self.headers.get('Priority')} tags {self.headers.get('Tags')}: "
+              f"{self.headers.get('Title')}")
+        print(body, flush=True)
+        self.reply(200, {"id": "stub", "event": "message"})
+
+    def reply(self, status, payload):
+        data = json.dumps(payload).encode("utf-8")
+        self.send_response(status)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(data)))
+        self.end_headers()
+        self.wfile.write(data)
+
+    def log_message(self, format, *args):
+        pass
+
+
+if __name__ == "__main__":
+    port = int(sys.argv[1]) if len(sys.argv) > 1 else 8090
+    print(f"Listening on http://localhost:{port}", flush=True)
+    HTTPServer(("localhost", port), PushHandler).serve_forever()
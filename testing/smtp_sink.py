@@ -0,0 +1,75 @@
+#!/usr/bin/env python3
+"""Stub SMTP server that accepts every message and prints it.
+
+Usage: ./testing/smtp_sink.py [port]   (default 1025)
+Set email.host to localhost, email.port to 1025 and email.security to none.
+Messages to addresses starting with "reject" are refused with a 550, to try
+out retries of failed digests.
+"""
+
+import socketserver
+import sys
+
+
+class SMTPHandler(socketserver.StreamRequestHandler):
+    def reply(self, line):
+        self.wfile.write((line + "\r\n").encode("utf-8"))
+
+    def handle(self):
+        self.reply("220 localhost smtp_sink ready")
+        sender, recipients = None, []
+        while True:
+            line = self.rfile.readline()
+            if not line:
+                return
+            command = line.decode("utf-8", "replace").rstrip("\r\n")
+            verb = command.split(" ", 1)[0].upper()
+
+            if verb == "EHLO":
+                self.reply("250-localhost")
+                self.reply("250 8BITMIME")
+            elif verb == "HELO":
+                self.reply("250 localhost")
+            elif verb == "MAIL":
+                sender, recipients = command[10:], []
+                self.reply("250 OK")
+            elif verb == "RCPT":
+                recipient = command[8:].strip("<>")
+                if recipient.lower().startswith("reject"):
+                    print(f"Rejected recipient {recipient}", flush=True)
+                    self.reply("550 Mailbox unavailable")
+                    continue
+                recipients.append(recipient)
+                self.reply("250 OK")
+            elif verb == "DATA":
+                self.reply("354 End data with <CR><LF>.<CR><LF>")
+                lines = []
+                while True:
+                    data = self.rfile.readline().decode("utf-8", "replace").rstrip("\r\n")
+                    if data == ".":
+                        break
+                    lines.append(data[1:] if data.startswith("..") else data)
+                print(f"--- From {sender} to {', '.join(recipients)}")
+                print("\n".join(lines), flush=True)
+                self.reply("250 OK: queued")
+            elif verb == "RSET":
+                sender, recipients = None, []
+                self.reply("250 OK")
+            elif verb == "NOOP":
+                self.reply("250 OK")
+            elif verb == "QUIT":
+                self.reply("221 Bye")
+                return
+            else:
+                self.reply("502 Command not implemented")
+
+
+class Server(socketserver.ThreadingTCPServer):
+    allow_reuse_address = True
+    daemon_threads = True
+
+
+if __name__ == "__main__":
+    port = int(sys.argv[1]) if len(sys.argv) > 1 else 1025
+    print(f"Listening on localhost:{port}", flush=True)
+    Server(("localhost", port), SMTPHandler).serve_forever()
@@ -0,0 +1,65 @@
+#!/bin/bash
+
+echo "Testing email alerts and weekly digests..."
+
+# Start the SMTP sink; config.yaml needs
+#   email:
+#     enabled: true
+#     host: "localhost"
+#     port: 1025
+#     security: "none"
+#     from: "Waterlogger <pool@example.com>"
+pkill -f smtp_sink.py
+python3 testing/smtp_sink.py 1025 > smtp_sink.log 2>&1 &
+sleep 1
+
+# Start a fresh server
+pkill -f waterlogger
+sleep 2
+./waterlogger > email_test.log 2>&1 &
+sleep 3
+
+# Login
+curl -s -X POST http://localhost:2342/api/login \
+  -H "Content-Type: application/json" \
+  -d '{"username": "jcz", "password": "password"}' \
+  -c email_cookies.txt
+
+# Subscribing marks the digest as sent, so the first scheduled one goes out
+# at the next digest_weekday and digest_hour rather than at once
+echo ""
+echo "Enabling alerts and digests:"
+curl -s -X PUT http://localhost:2342/api/settings/notifications \
+  -H "Content-Type: application/json" \
+  -d '{"email_alerts": true, "email_digest": true, "alert_severity": "warning"}' \
+  -b email_cookies.txt
+
+echo ""
+echo "Emailing the digest now:"
+curl -s -X POST http://localhost:2342/api/settings/notifications/digest -b email_cookies.txt
+
+echo ""
+echo "Creating a sample with a pH out of range for an alert..."
+curl -s -X POST http://localhost:2342/api/samples \
+  -H "Content-Type: application/json" \
+  -d '{
+    "pool_id": 1,
+    "sample_datetime": "2025-07-14T19:00",
+    "kit_id": 1,
+    "user_id": 1,
+    "measurements": {"ph": 7.9, "fc": 3.0, "tc": 3.0, "ta": 90.0, "ch": 250.0, "temperature": 82.0}
+  }' \
+  -b email_cookies.txt > /dev/null
+sleep 2
+
+echo ""
+echo "Received by the sink:"
+cat smtp_sink.log
+
+echo ""
+echo "Scheduled digests sent at startup (expect none for a new subscription):"
+grep -i "digest" email_test.log | grep -v "POST /api/settings"
+
+pkill -f waterlogger
+pkill -f smtp_sink.py
+rm -f email_cookies.txt
@@ -0,0 +1,55 @@
+#!/bin/bash
+
+echo "Testing InfluxDB line protocol export and push..."
+
+# Start the stub write endpoint; config.yaml needs
+#   influx:
+#     url: "http://localhost:8086/api/v2/write?org=home&bucket=pool"
+#     push: true
+#     interval_seconds: 15
+pkill -f influx_stub.py
+python3 testing/influx_stub.py 8086 > influx_stub.log 2>&1 &
+sleep 1
+
+# Start a fresh server
+pkill -f waterlogger
+sleep 2
+./waterlogger > influx_test.log 2>&1 &
+sleep 3
+
+# Login
+curl -s -X POST http://localhost:2342/api/login \
+  -H "Content-Type: application/json" \
+  -d '{"username": "jcz", "password": "password"}' \
+  -c influx_cookies.txt
+
+echo ""
+echo "Line protocol export of the last 30 days:"
+curl -s "http://localhost:2342/api/export/influx?days=30" -b influx_cookies.txt
+
+echo ""
+echo "Pushing the last 30 days to the stub:"
+curl -s -X POST "http://localhost:2342/api/export/influx/push?days=30" -b influx_cookies.txt
+
+# A new sample is pushed in the background once it is older than 10 seconds
+echo ""
+echo "Creating a sample for the background pusher..."
+curl -s -X POST http://localhost:2342/api/samples \
+  -H "Content-Type: application/json" \
+  -d '{
+    "pool_id": 1,
+    "sample_datetime": "2025-07-14T19:00",
+    "kit_id": 1,
+    "user_id": 1,
+    "measurements": {"ph": 7.4, "fc": 3.0, "tc": 3.0, "ta": 90.0, "ch": 250.0, "temperature": 82.0}
+  }' \
+  -b influx_cookies.txt > /dev/null
+sleep 30
+
+echo ""
+echo "Received by the stub:"
+cat influx_stub.log
+
+pkill -f waterlogger
+pkill -f influx_stub.py
+rm -f influx_cookies.txt
@@ -0,0 +1,53 @@
+#!/bin/bash
+
+echo "Testing MQTT sensor ingestion..."
+
+# Needs mosquitto and mosquitto_pub; config.yaml needs
+#   mqtt:
+#     enabled: true
+#     broker: "tcp://localhost:1883"
+#     topics:
+#       - topic: "pool/main/ph"
+#         pool: "1"
+#         parameter: "ph"
+#       - topic: "pool/main/probe"
+#         pool: "1"
+#         fields:
+#           fc: "fc"
+#           temperature: "water.temp"
+#         celsius: true
+pkill -x mosquitto
+mosquitto -p 1883 > mosquitto.log 2>&1 &
+sleep 1
+
+# Start a fresh server
+pkill -f waterlogger
+sleep 2
+./waterlogger > mqtt_test.log 2>&1 &
+sleep 3
+
+echo ""
+echo "Publishing readings..."
+mosquitto_pub -t pool/main/ph -m 7.4
+mosquitto_pub -t pool/main/probe -m '{"fc": 3.1, "water": {"temp": 27.5}}'
+mosquitto_pub -t pool/main/ph -m 15 # rejected, outside the valid range
+sleep 2
+
+# Login
+curl -s -X POST http://localhost:2342/api/login \
+  -H "Content-Type: application/json" \
+  -d '{"username": "jcz", "password": "password"}' \
+  -c mqtt_cookies.txt
+
+echo ""
+echo "Sensor readings of pool 1:"
+curl -s "http://localhost:2342/api/readings?pool_id=1" -b mqtt_cookies.txt
+echo ""
+
+echo ""
+echo "MQTT log lines:"
+grep MQTT mqtt_test.log
+
+pkill -f waterlogger
+pkill -x mosquitto
+rm -f mqtt_cookies.txt
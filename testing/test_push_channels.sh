@@ -0,0 +1,80 @@
+#!/bin/bash
+
+echo "Testing ntfy and Gotify push channels..."
+
+# Start the stub push server
+pkill -f push_stub.py
+python3 testing/push_stub.py 8090 > push_stub.log 2>&1 &
+sleep 1
+
+# Start a fresh server
+pkill -f waterlogger
+sleep 2
+./waterlogger > push_test.log 2>&1 &
+sleep 3
+
+# Login as an administrator
+curl -s -X POST http://localhost:2342/api/login \
+  -H "Content-Type: application/json" \
+  -d '{"username": "jcz", "password": "password"}' \
+  -c push_cookies.txt
+
+echo ""
+echo "Adding an ntfy channel..."
+NTFY_ID=$(curl -s -X POST http://localhost:2342/api/settings/notifications/channels \
+  -H "Content-Type: application/json" \
+  -d '{"name": "Stub ntfy", "type": "ntfy", "url": "http://localhost:8090/pool-test", "token": "tk_stub", "alert_severity": "critical"}' \
+  -b push_cookies.txt | python3 -c "import sys, json; print(json.load(sys.stdin)['id'])")
+echo "Channel $NTFY_ID"
+
+echo "Adding a Gotify channel..."
+GOTIFY_ID=$(curl -s -X POST http://localhost:2342/api/settings/notifications/channels \
+  -H "Content-Type: application/json" \
+  -d '{"name": "Stub Gotify", "type": "gotify", "url": "http://localhost:8090", "token": "AppToken123"}' \
+  -b push_cookies.txt | python3 -c "import sys, json; print(json.load(sys.stdin)['id'])")
+echo "Channel $GOTIFY_ID"
+
+echo "Adding a channel the stub refuses..."
+REFUSED_ID=$(curl -s -X POST http://localhost:2342/api/settings/notifications/channels \
+  -H "Content-Type: application/json" \
+  -d '{"name": "Refused", "type": "ntfy", "url": "http://localhost:8090/forbidden"}' \
+  -b push_cookies.txt | python3 -c "import sys, json; print(json.load(sys.stdin)['id'])")
+echo "Channel $REFUSED_ID"
+
+echo ""
+echo "Testing the ntfy channel (expect urgent priority):"
+curl -s -X POST http://localhost:2342/api/settings/notifications/channels/$NTFY_ID/test -b push_cookies.txt
+echo ""
+echo "Testing the Gotify channel (expect priority 5):"
+curl -s -X POST http://localhost:2342/api/settings/notifications/channels/$GOTIFY_ID/test -b push_cookies.txt
+echo ""
+echo "Testing the refused channel (expect 502 with only the status, no response body):"
+curl -s -X POST http://localhost:2342/api/settings/notifications/channels/$REFUSED_ID/test -b push_cookies.txt
+
+# A critical pH goes to both channels, as both receive critical alerts
+echo ""
+echo "Creating a sample with a critical pH..."
+curl -s -X POST http://localhost:2342/api/samples \
+  -H "Content-Type: application/json" \
+  -d '{
+    "pool_id": 1,
+    "sample_datetime": "2025-07-14T19:00",
+    "kit_id": 1,
+    "user_id": 1,
+    "measurements": {"ph": 8.6, "fc": 3.0, "tc": 3.0, "ta": 90.0, "ch": 250.0, "temperature": 82.0}
+  }' \
+  -b push_cookies.txt > /dev/null
+sleep 2
+
+echo ""
+echo "Received by the stub:"
+cat push_stub.log
+
+# Clean up the channels
+for ID in $NTFY_ID $GOTIFY_ID $REFUSED_ID; do
+  curl -s -X DELETE http://localhost:2342/api/settings/notifications/channels/$ID -b push_cookies.txt > /dev/null
+done
+
+pkill -f waterlogger
+pkill -f push_stub.py
+rm -f push_cookies.txt
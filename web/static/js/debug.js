@@ -187,6 +187,7 @@ window.WaterloggerUnits = {
             cya: ' ppm',
             salinity: ' ppm',
             tds: ' ppm',
+            orp: ' mV',
             lsi: '',
             rsi: '',
             csi: ''
@@ -208,6 +209,7 @@ window.WaterloggerUnits = {
             cya: 'ppm',
             salinity: 'ppm',
             tds: 'ppm',
+            orp: 'mV',
             lsi: '',
             rsi: '',
             csi: ''